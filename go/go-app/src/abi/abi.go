@@ -0,0 +1,9 @@
+// abi.go embeds the contract ABIs shipped with the app so they can be loaded from any working directory
+package abi
+
+import "embed"
+
+// FS holds the embedded ABI JSON files, e.g. "erc20.json"
+//
+//go:embed *.json
+var FS embed.FS
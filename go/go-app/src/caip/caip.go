@@ -0,0 +1,251 @@
+// caip.go parses and formats CAIP-2 chain IDs and CAIP-10 account IDs, in both the standard
+// "namespace:reference" form and Orby's "namespace-reference" form
+package caip
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// Chain namespaces
+const (
+	NamespaceEIP155 = "eip155"
+	NamespaceSolana = "solana"
+)
+
+var (
+	namespacePattern = regexp.MustCompile(`^[-a-z0-9]{3,8}$`)
+	referencePattern = regexp.MustCompile(`^[-_a-zA-Z0-9]{1,32}$`)
+)
+
+// ChainID is a CAIP-2 chain ID such as eip155:1. The zero value means no chain.
+type ChainID struct {
+	Namespace string
+	Reference string
+}
+
+// NewChainID creates a chain ID and checks its namespace and reference
+func NewChainID(namespace string, reference string) (ChainID, error) {
+	if !namespacePattern.MatchString(namespace) {
+		return ChainID{}, fmt.Errorf("invalid chain namespace %q", namespace)
+	}
+	if !referencePattern.MatchString(reference) {
+		return ChainID{}, fmt.Errorf("invalid chain reference %q", reference)
+	}
+	if namespace == NamespaceEIP155 {
+		// EVM chain IDs are positive decimal numbers, written without leading zeros
+		if chainId, ok := new(big.Int).SetString(reference, 10); !ok || chainId.Sign() <= 0 || chainId.String() != reference {
+			return ChainID{}, fmt.Errorf("invalid eip155 chain reference %q: expected a positive chain ID", reference)
+		}
+	}
+	return ChainID{Namespace: namespace, Reference: reference}, nil
+}
+
+// EIP155 returns the chain ID of an EVM chain, or the zero ChainID for 0
+func EIP155(chainId int64) ChainID {
+	if chainId == 0 {
+		return ChainID{}
+	}
+	return ChainID{Namespace: NamespaceEIP155, Reference: fmt.Sprintf("%d", chainId)}
+}
+
+// EIP155FromBig returns the chain ID of an EVM chain, or the zero ChainID for nil or 0
+func EIP155FromBig(chainId *big.Int) ChainID {
+	if chainId == nil || chainId.Sign() == 0 {
+		return ChainID{}
+	}
+	return ChainID{Namespace: NamespaceEIP155, Reference: chainId.String()}
+}
+
+// ParseChainID parses "eip155:1", Orby's "eip155-1", or a bare EVM chain ID such as "1".
+// An empty string parses to the zero ChainID.
+func ParseChainID(input string) (ChainID, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return ChainID{}, nil
+	}
+
+	// Bare decimal IDs are EVM chains
+	if chainId, ok := new(big.Int).SetString(input, 10); ok {
+		if chainId.Sign() <= 0 {
+			return ChainID{}, fmt.Errorf("invalid chain ID %q", input)
+		}
+		return EIP155FromBig(chainId), nil
+	}
+
+	// The namespace cannot contain ':' and Orby separates it with the first '-' after the namespace,
+	// so try the CAIP-2 separator first
+	if namespace, reference, ok := strings.Cut(input, ":"); ok {
+		return NewChainID(namespace, reference)
+	}
+	for _, namespace := range []string{NamespaceEIP155, NamespaceSolana} {
+		if reference, ok := strings.CutPrefix(input, namespace+"-"); ok {
+			return NewChainID(namespace, reference)
+		}
+	}
+	if namespace, reference, ok := strings.Cut(input, "-"); ok {
+		return NewChainID(namespace, reference)
+	}
+
+	return ChainID{}, fmt.Errorf("invalid chain ID %q: expected namespace:reference", input)
+}
+
+// MustParseChainID is like ParseChainID but panics on invalid input, for constants
+func MustParseChainID(input string) ChainID {
+	chainId, err := ParseChainID(input)
+	if err != nil {
+		panic(err)
+	}
+	return chainId
+}
+
+// IsZero reports whether the chain ID is unset
+func (c ChainID) IsZero() bool {
+	return c.Namespace == "" && c.Reference == ""
+}
+
+// IsEVM reports whether the chain is in the eip155 namespace
+func (c ChainID) IsEVM() bool {
+	return c.Namespace == NamespaceEIP155
+}
+
+// IsSolana reports whether the chain is in the solana namespace
+func (c ChainID) IsSolana() bool {
+	return c.Namespace == NamespaceSolana
+}
+
+// String returns the CAIP-2 form, e.g. "eip155:1"
+func (c ChainID) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	return c.Namespace + ":" + c.Reference
+}
+
+// OrbyString returns Orby's form, e.g. "eip155-1"
+func (c ChainID) OrbyString() string {
+	if c.IsZero() {
+		return ""
+	}
+	return c.Namespace + "-" + c.Reference
+}
+
+// BigInt returns the numeric chain ID of an EVM chain, as signers expect it
+func (c ChainID) BigInt() (*big.Int, error) {
+	if !c.IsEVM() {
+		return nil, fmt.Errorf("chain %s is not an EVM chain", c.String())
+	}
+	chainId, ok := new(big.Int).SetString(c.Reference, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid eip155 chain reference %q", c.Reference)
+	}
+	return chainId, nil
+}
+
+// Int64 returns the numeric chain ID of an EVM chain
+func (c ChainID) Int64() (int64, error) {
+	chainId, err := c.BigInt()
+	if err != nil {
+		return 0, err
+	}
+	if !chainId.IsInt64() {
+		return 0, fmt.Errorf("chain ID %s does not fit in int64", chainId.String())
+	}
+	return chainId.Int64(), nil
+}
+
+// MarshalJSON encodes the chain ID in Orby's form, which the Orby API expects
+func (c ChainID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.OrbyString())
+}
+
+// UnmarshalJSON accepts the CAIP-2 form, Orby's form, or a bare EVM chain ID as a string or number
+func (c *ChainID) UnmarshalJSON(data []byte) error {
+	var input string
+	if err := json.Unmarshal(data, &input); err != nil {
+		var number json.Number
+		if numErr := json.Unmarshal(data, &number); numErr != nil {
+			return fmt.Errorf("chain ID must be a string or number: %v", err)
+		}
+		input = number.String()
+	}
+
+	chainId, err := ParseChainID(input)
+	if err != nil {
+		return err
+	}
+	*c = chainId
+	return nil
+}
+
+// AccountID is a CAIP-10 account ID such as eip155:1:0xab16a96d359ec26a11e2c2b3d8f8b8942d5bfcdb
+type AccountID struct {
+	Chain   ChainID
+	Address string
+}
+
+// NewAccountID creates an account ID on a chain
+func NewAccountID(chain ChainID, address string) (AccountID, error) {
+	if chain.IsZero() {
+		return AccountID{}, fmt.Errorf("account ID requires a chain")
+	}
+	if address == "" || len(address) > 128 {
+		return AccountID{}, fmt.Errorf("invalid account address %q", address)
+	}
+	return AccountID{Chain: chain, Address: address}, nil
+}
+
+// ParseAccountID parses "namespace:reference:address", with the chain in either CAIP-2 or Orby's form
+func ParseAccountID(input string) (AccountID, error) {
+	input = strings.TrimSpace(input)
+	separator := strings.LastIndex(input, ":")
+	if separator <= 0 {
+		return AccountID{}, fmt.Errorf("invalid account ID %q: expected chain:address", input)
+	}
+
+	chain, err := ParseChainID(input[:separator])
+	if err != nil {
+		return AccountID{}, fmt.Errorf("invalid account ID %q: %v", input, err)
+	}
+	return NewAccountID(chain, input[separator+1:])
+}
+
+// IsZero reports whether the account ID is unset
+func (a AccountID) IsZero() bool {
+	return a.Chain.IsZero() && a.Address == ""
+}
+
+// String returns the CAIP-10 form, e.g. "eip155:1:0xab16..."
+func (a AccountID) String() string {
+	if a.IsZero() {
+		return ""
+	}
+	return a.Chain.String() + ":" + a.Address
+}
+
+// MarshalJSON encodes the account ID in its CAIP-10 form
+func (a AccountID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON parses a CAIP-10 account ID string
+func (a *AccountID) UnmarshalJSON(data []byte) error {
+	var input string
+	if err := json.Unmarshal(data, &input); err != nil {
+		return fmt.Errorf("account ID must be a string: %v", err)
+	}
+	if input == "" {
+		*a = AccountID{}
+		return nil
+	}
+
+	accountId, err := ParseAccountID(input)
+	if err != nil {
+		return err
+	}
+	*a = accountId
+	return nil
+}
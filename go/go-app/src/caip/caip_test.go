@@ -0,0 +1,85 @@
+package caip
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseChainID(t *testing.T) {
+	ethereum := ChainID{Namespace: NamespaceEIP155, Reference: "1"}
+	base := ChainID{Namespace: NamespaceEIP155, Reference: "8453"}
+	solana := ChainID{Namespace: NamespaceSolana, Reference: "5eykt4UsFv8P8NJdTREpY1vzqKqZKvdp"}
+
+	tests := []struct {
+		input   string
+		want    ChainID
+		wantErr bool
+	}{
+		// Bare EVM chain IDs
+		{input: "1", want: ethereum},
+		{input: " 8453 ", want: base},
+		{input: "", want: ChainID{}},
+		{input: "0", wantErr: true},
+		{input: "-1", wantErr: true},
+
+		// CAIP-2
+		{input: "eip155:1", want: ethereum},
+		{input: "eip155:8453", want: base},
+		{input: "solana:5eykt4UsFv8P8NJdTREpY1vzqKqZKvdp", want: solana},
+		{input: "eip155:0", wantErr: true},
+		{input: "eip155:-1", wantErr: true},
+		{input: "eip155:01", wantErr: true},
+		{input: "eip155:mainnet", wantErr: true},
+		{input: "eip155:", wantErr: true},
+		{input: "EIP155:1", wantErr: true},
+
+		// Orby's form
+		{input: "eip155-1", want: ethereum},
+		{input: "eip155-8453", want: base},
+		{input: "solana-5eykt4UsFv8P8NJdTREpY1vzqKqZKvdp", want: solana},
+		{input: "eip155-0", wantErr: true},
+		{input: "eip155--1", wantErr: true},
+		{input: "eip155-", wantErr: true},
+
+		{input: "mainnet", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseChainID(test.input)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseChainID(%q) = %+v, want an error", test.input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseChainID(%q): %v", test.input, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseChainID(%q) = %+v, want %+v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestChainIDFormats(t *testing.T) {
+	chainId := ChainID{Namespace: NamespaceEIP155, Reference: "8453"}
+	if got := chainId.String(); got != "eip155:8453" {
+		t.Errorf("String = %q, want eip155:8453", got)
+	}
+	if got := chainId.OrbyString(); got != "eip155-8453" {
+		t.Errorf("OrbyString = %q, want eip155-8453", got)
+	}
+
+	// JSON is in Orby's form, and every form parses back
+	data, err := json.Marshal(chainId)
+	if err != nil || string(data) != `"eip155-8453"` {
+		t.Errorf("MarshalJSON = %s, %v, want \"eip155-8453\"", data, err)
+	}
+	for _, input := range []string{`"eip155-8453"`, `"eip155:8453"`, `"8453"`, `8453`} {
+		var parsed ChainID
+		if err := json.Unmarshal([]byte(input), &parsed); err != nil || parsed != chainId {
+			t.Errorf("UnmarshalJSON(%s) = %+v, %v, want %+v", input, parsed, err, chainId)
+		}
+	}
+}
@@ -0,0 +1,688 @@
+// commands.go implements the CLI subcommands, run as `go run ./src <command> [args]`
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go-app/src/caip"
+	"go-app/src/orby"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// usage lists the CLI subcommands
+const usage = `Usage: go run ./src [-config <file>] [-profile <name>] [-set KEY=VALUE]... [command]
+
+Without a command, runs the example selected by EXAMPLE_TYPE.
+
+Global flags:
+  -config <file>                         configuration file (default ORBY_CONFIG_FILE, or orby.yaml if present)
+  -profile <name>                        profile of the configuration file (default ORBY_PROFILE, or default_profile)
+  -set KEY=VALUE                         a setting, repeatable, overriding the environment and the file
+
+Commands:
+  config show [-all]                     Show the settings in effect and where they come from, with secrets
+                                         redacted, and check them; -all also lists the settings not set
+  instance list                          List the instances of the Orby engine
+  instance describe <name>               Show an instance's URLs and settings
+  instance rotate <name>                 Replace an instance's private and public URLs
+  instance delete <name> [-yes]          Delete an instance and its account clusters, after typing
+                                         its name to confirm, or without a prompt with -yes
+  instance configure <name> [flags]      Update an instance's settings:
+      -allowed-origins <a,b>             origins allowed to call the public URL from a browser
+      -rate-limit <n>                    requests per minute, 0 for no limit
+      -chains <1,eip155:137,...>         chains the instance serves
+      -file <config.json>                settings as JSON, overridden by the flags above
+  cluster create [flags]                 Create an account cluster for the current profile's instance
+  cluster add <id> [flags]               Add accounts to an account cluster
+  cluster remove <id> [flags]            Remove accounts from an account cluster
+      -account <account>                 an account, repeatable: 0x..., SCA:0x..., SVM:<address>
+                                         or env:<VARIABLE> for the account of a private key
+      -file <accounts.json>              accounts as a JSON array
+  proxy [flags]                          Serve a local JSON-RPC endpoint whose eth_sendTransaction and
+                                         eth_signTypedData_v4 run as Orby operation sets:
+      -listen <host:port>                address to listen on (default PROXY_LISTEN_ADDRESS or 127.0.0.1:8545)
+      -chain <id>                        chain served (default the default virtual node chain)
+      -allowed-origins <origins>         comma-separated browser origins allowed to call the proxy
+                                         (default PROXY_ALLOWED_ORIGINS or none)
+  state list                             List the profiles with a recorded instance
+  state inspect [profile]                Show a profile's instance, account clusters and virtual nodes
+  state forget [profile]                 Forget a profile's instance, so the next run creates a new one
+  state forget -cluster <id> [profile]   Forget one account cluster of a profile
+
+Instance commands call ORBY_ENGINE_ADMIN_URL. Cluster commands call the private URL of the
+profile's recorded instance; cluster create without accounts uses the configured accounts. The
+profile defaults to -profile, ORBY_PROFILE, the default_profile of the configuration file, or
+ORBY_INSTANCE_NAME. State is stored in ORBY_STATE_FILE. Settings come from -set, the environment,
+.env and the profile of the configuration file, in that order.
+`
+
+// runCommand runs a CLI subcommand
+func runCommand(args []string) error {
+	switch args[0] {
+	case "config":
+		return configCommand(args[1:])
+	case "instance":
+		return instanceCommand(args[1:])
+	case "cluster":
+		return clusterCommand(args[1:])
+	case "proxy":
+		return proxyCommand(args[1:])
+	case "state":
+		return stateCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return nil
+	}
+	fmt.Print(usage)
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// configCommand shows the configuration in effect
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "show" {
+		fmt.Print(usage)
+		return fmt.Errorf("config requires a subcommand: show")
+	}
+	flags := flag.NewFlagSet("config show", flag.ContinueOnError)
+	all := flags.Bool("all", false, "also list the settings not set")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	// 1. Show where the configuration comes from
+	if appConfig.Path == "" {
+		fmt.Printf("[INFO] No configuration file (create %s, or set ORBY_CONFIG_FILE)\n", orby.ConfigFileNames[0])
+	} else {
+		fmt.Printf("[INFO] Configuration file: %s\n", appConfig.Path)
+		if len(appConfig.Profiles) > 0 {
+			profile := appConfig.Profile
+			if profile == "" {
+				profile = "none, only the defaults apply"
+			}
+			fmt.Printf("         Profile: %s (profiles: %s)\n", profile, strings.Join(appConfig.Profiles, ", "))
+		}
+	}
+
+	// 2. Show each setting, redacting secrets
+	fmt.Println("\n[INFO] Settings:")
+	shown := make(map[string]bool)
+	for _, value := range appConfig.Values() {
+		fmt.Printf("         %s=%s  (%s)\n", value.Key, value.Redacted(), value.Source)
+		shown[value.Key] = true
+	}
+	if *all {
+		for _, key := range orby.ConfigSettingKeys() {
+			if !shown[key] {
+				fmt.Printf("         %s  (not set)\n", key)
+			}
+		}
+	}
+
+	// 3. Check the settings the example needs
+	exampleType := orby.GetEnvWithDefault("EXAMPLE_TYPE", "")
+	requirements := append(orby.ClusterRequirements, appConfig.ExampleRequirements(exampleType)...)
+	if err := appConfig.Validate(requirements...); err != nil {
+		fmt.Printf("\n[WARN] %v\n", err)
+		return nil
+	}
+	fmt.Printf("\n[INFO] The configuration is valid for %s\n", exampleType)
+	return nil
+}
+
+// instanceCommand manages the instances of the Orby engine through its admin URL
+func instanceCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Print(usage)
+		return fmt.Errorf("instance requires a subcommand: list, describe, rotate, delete or configure")
+	}
+
+	engineAdminURL := orby.GetEnvWithDefault("ORBY_ENGINE_ADMIN_URL", "")
+	if engineAdminURL == "" {
+		return fmt.Errorf("ORBY_ENGINE_ADMIN_URL is required to manage instances")
+	}
+	adminClient := orby.NewOrbyClient(engineAdminURL, orby.GetEnvWithDefault("ORBY_URL", ""))
+
+	if args[0] == "list" {
+		instances, err := adminClient.ListOrbyInstances()
+		if err != nil {
+			return fmt.Errorf("failed to list instances: %v", err)
+		}
+		if len(instances) == 0 {
+			fmt.Println("[INFO] No instances")
+			return nil
+		}
+		fmt.Printf("[INFO] %d instance(s):\n", len(instances))
+		for _, instance := range instances {
+			fmt.Printf("         %s: %s\n", instance.Name, instance.OrbyInstancePublicUrl)
+		}
+		return nil
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf("instance %s requires an instance name", args[0])
+	}
+	name := args[1]
+
+	store, err := orby.DefaultStateStore()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "describe":
+		instance, err := adminClient.GetOrbyInstance(name)
+		if err != nil {
+			return fmt.Errorf("failed to describe instance %s: %v", name, err)
+		}
+		printOrbyInstance(instance)
+		return nil
+
+	case "rotate":
+		response, err := adminClient.RotateOrbyInstanceUrls(name)
+		if err != nil {
+			return fmt.Errorf("failed to rotate the URLs of instance %s: %v", name, err)
+		}
+		fmt.Printf("[INFO] Rotated the URLs of instance %s:\n", name)
+		fmt.Printf("         Private URL: %s\n", response.OrbyInstancePrivateUrl)
+		fmt.Printf("         Public URL: %s\n", response.OrbyInstancePublicUrl)
+
+		// Keep the recorded state pointing at the new URLs
+		updated, err := store.UpdateInstanceUrls(engineAdminURL, name, response.OrbyInstancePrivateUrl, response.OrbyInstancePublicUrl)
+		if err != nil {
+			return err
+		}
+		if updated > 0 {
+			fmt.Printf("[INFO] Updated %d recorded profile(s)\n", updated)
+		}
+		return nil
+
+	case "delete":
+		flags := flag.NewFlagSet("instance delete", flag.ContinueOnError)
+		yes := flags.Bool("yes", false, "delete without asking for confirmation")
+		if err := flags.Parse(args[2:]); err != nil {
+			return err
+		}
+		if !*yes {
+			if err := confirmInstanceName(name); err != nil {
+				return err
+			}
+		}
+
+		if err := adminClient.DeleteOrbyInstance(name); err != nil {
+			return fmt.Errorf("failed to delete instance %s: %v", name, err)
+		}
+		fmt.Printf("[INFO] Deleted instance %s\n", name)
+
+		removed, err := store.ForgetInstance(engineAdminURL, name)
+		if err != nil {
+			return err
+		}
+		if removed > 0 {
+			fmt.Printf("[INFO] Forgot %d recorded profile(s)\n", removed)
+		}
+		return nil
+
+	case "configure":
+		config, err := parseInstanceConfig(args[2:])
+		if err != nil {
+			return err
+		}
+		instance, err := adminClient.ConfigureOrbyInstance(name, config)
+		if err != nil {
+			return fmt.Errorf("failed to configure instance %s: %v", name, err)
+		}
+		fmt.Printf("[INFO] Configured instance %s\n", name)
+		printOrbyInstance(instance)
+		return nil
+	}
+
+	return fmt.Errorf("unknown instance subcommand %q (expected list, describe, rotate, delete or configure)", args[0])
+}
+
+// confirmInstanceName asks for the instance name to be typed before an instance is deleted
+func confirmInstanceName(name string) error {
+	fmt.Printf("[WARN] Deleting instance %s also deletes its account clusters and cannot be undone.\n", name)
+	fmt.Print("Type the instance name to confirm: ")
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && strings.TrimSpace(answer) == "" {
+		return fmt.Errorf("instance delete needs confirmation: type the instance name, or pass -yes")
+	}
+	if strings.TrimSpace(answer) != name {
+		return fmt.Errorf("the name does not match; instance %s was not deleted", name)
+	}
+	return nil
+}
+
+// parseInstanceConfig reads instance settings from a -file JSON file and the flags that override it
+func parseInstanceConfig(args []string) (orby.OrbyInstanceConfig, error) {
+	flags := flag.NewFlagSet("instance configure", flag.ContinueOnError)
+	file := flags.String("file", "", "settings as JSON")
+	allowedOrigins := flags.String("allowed-origins", "", "comma-separated origins")
+	rateLimit := flags.Int("rate-limit", -1, "requests per minute, 0 for no limit")
+	chains := flags.String("chains", "", "comma-separated chain IDs")
+	if err := flags.Parse(args); err != nil {
+		return orby.OrbyInstanceConfig{}, err
+	}
+
+	var config orby.OrbyInstanceConfig
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return config, fmt.Errorf("failed to read instance config %s: %v", *file, err)
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return config, fmt.Errorf("failed to parse instance config %s: %v", *file, err)
+		}
+	}
+
+	if *allowedOrigins != "" {
+		config.AllowedOrigins = strings.Split(*allowedOrigins, ",")
+	}
+	if *rateLimit >= 0 {
+		config.RateLimitPerMinute = rateLimit
+	}
+	if *chains != "" {
+		config.ChainIds = nil
+		for _, chain := range strings.Split(*chains, ",") {
+			chainId, err := caip.ParseChainID(chain)
+			if err != nil {
+				return config, fmt.Errorf("invalid -chains: %v", err)
+			}
+			config.ChainIds = append(config.ChainIds, chainId)
+		}
+	}
+
+	if config.AllowedOrigins == nil && config.RateLimitPerMinute == nil && config.ChainIds == nil {
+		return config, fmt.Errorf("instance configure requires -file, -allowed-origins, -rate-limit or -chains")
+	}
+	return config, nil
+}
+
+// printOrbyInstance displays an instance returned by the admin API
+func printOrbyInstance(instance *orby.OrbyInstance) {
+	fmt.Printf("[INFO] Instance %s:\n", instance.Name)
+	fmt.Printf("         Private URL: %s\n", instance.OrbyInstancePrivateUrl)
+	fmt.Printf("         Public URL: %s\n", instance.OrbyInstancePublicUrl)
+	if instance.CreatedAt != "" {
+		fmt.Printf("         Created: %s\n", instance.CreatedAt)
+	}
+	if instance.Config == nil {
+		return
+	}
+	if len(instance.Config.AllowedOrigins) > 0 {
+		fmt.Printf("         Allowed Origins: %s\n", strings.Join(instance.Config.AllowedOrigins, ", "))
+	}
+	if instance.Config.RateLimitPerMinute != nil {
+		fmt.Printf("         Rate Limit: %d requests/minute\n", *instance.Config.RateLimitPerMinute)
+	}
+	for _, chainId := range instance.Config.ChainIds {
+		fmt.Printf("         Chain: %s\n", orby.ChainDisplayName(chainId))
+	}
+}
+
+// clusterCommand creates account clusters and adds or removes their accounts through the private
+// URL of the current profile's instance, keeping the recorded state in sync
+func clusterCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Print(usage)
+		return fmt.Errorf("cluster requires a subcommand: create, add or remove")
+	}
+
+	subcommand := args[0]
+	args = args[1:]
+	var accountClusterId string
+	switch subcommand {
+	case "create":
+	case "add", "remove":
+		if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+			return fmt.Errorf("cluster %s requires an account cluster ID", subcommand)
+		}
+		accountClusterId = args[0]
+		args = args[1:]
+	default:
+		return fmt.Errorf("unknown cluster subcommand %q (expected create, add or remove)", subcommand)
+	}
+
+	// 1. Read the accounts from the flags, or the configured accounts for create
+	configs, err := parseClusterAccounts("cluster "+subcommand, args)
+	if err != nil {
+		return err
+	}
+	if len(configs) == 0 {
+		if subcommand != "create" {
+			return fmt.Errorf("cluster %s requires -account or -file", subcommand)
+		}
+		if configs, err = orby.ClusterAccountsFromEnv(); err != nil {
+			return err
+		}
+	}
+	accounts, err := orby.ResolveClusterAccounts(configs)
+	if err != nil {
+		return err
+	}
+	if len(accounts) == 0 {
+		return fmt.Errorf("no accounts given")
+	}
+
+	// 2. Use the private URL of the profile's recorded instance
+	store, err := orby.DefaultStateStore()
+	if err != nil {
+		return err
+	}
+	profile := orby.StateProfile()
+	instance, ok := store.Instance(profile)
+	if !ok {
+		return fmt.Errorf("no instance recorded for profile %q; run an example first to create one", profile)
+	}
+	privateOrbyClient := orby.NewOrbyClient(instance.PrivateURL, instance.PrivateURL)
+
+	// 3. Create the cluster, or update the accounts of the recorded one
+	var cluster orby.ClusterState
+	switch subcommand {
+	case "create":
+		result, err := privateOrbyClient.CreateAccountCluster(accounts)
+		if err != nil {
+			return fmt.Errorf("failed to create account cluster: %v", err)
+		}
+		var response orby.AccountClusterResponse
+		if err := json.Unmarshal(result, &response); err != nil {
+			return fmt.Errorf("failed to parse orby_createAccountCluster response: %v", err)
+		}
+		cluster = orby.ClusterState{
+			AccountClusterId: response.AccountClusterId,
+			Accounts:         accounts,
+			CreatedAt:        time.Now(),
+		}
+		fmt.Printf("[INFO] Created account cluster %s\n", cluster.AccountClusterId)
+
+	case "add":
+		if _, err := privateOrbyClient.AddAccountsToCluster(accountClusterId, accounts); err != nil {
+			return fmt.Errorf("failed to add accounts to account cluster %s: %v", accountClusterId, err)
+		}
+		cluster = recordedCluster(instance, accountClusterId)
+		cluster.Accounts = mergeClusterAccounts(cluster.Accounts, accounts)
+		fmt.Printf("[INFO] Added %d account(s) to account cluster %s\n", len(accounts), accountClusterId)
+
+	case "remove":
+		if _, err := privateOrbyClient.RemoveAccountsFromCluster(accountClusterId, accounts); err != nil {
+			return fmt.Errorf("failed to remove accounts from account cluster %s: %v", accountClusterId, err)
+		}
+		cluster = recordedCluster(instance, accountClusterId)
+		cluster.Accounts = subtractClusterAccounts(cluster.Accounts, accounts)
+		// Virtual nodes entered through a removed account can no longer be used
+		for key, node := range cluster.VirtualNodes {
+			for _, account := range accounts {
+				if strings.EqualFold(node.EntrypointAccountAddress, account.Address) {
+					delete(cluster.VirtualNodes, key)
+				}
+			}
+		}
+		fmt.Printf("[INFO] Removed %d account(s) from account cluster %s\n", len(accounts), accountClusterId)
+	}
+
+	// 4. Record the cluster under its new set of accounts
+	instance.RemoveCluster(cluster.AccountClusterId)
+	instance.SetCluster(cluster)
+	if err := store.PutInstance(instance); err != nil {
+		return err
+	}
+	for _, account := range cluster.Accounts {
+		fmt.Printf("         Account: %s (%s %s)\n", account.Address, account.VMType, account.AccountType)
+	}
+	return nil
+}
+
+// clusterAccountFlags collects repeated -account flags
+type clusterAccountFlags []string
+
+func (f *clusterAccountFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *clusterAccountFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// settingFlags collects repeated -set KEY=VALUE flags
+type settingFlags []string
+
+func (f *settingFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *settingFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// Values returns the settings by key
+func (f settingFlags) Values() (map[string]string, error) {
+	values := make(map[string]string)
+	for _, setting := range f {
+		key, value, ok := strings.Cut(setting, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid -set %q: expected KEY=VALUE", setting)
+		}
+		values[strings.ToUpper(key)] = value
+	}
+	return values, nil
+}
+
+// parseClusterAccounts reads accounts from a -file JSON file and -account flags
+func parseClusterAccounts(name string, args []string) ([]orby.ClusterAccountConfig, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	var accountArgs clusterAccountFlags
+	flags.Var(&accountArgs, "account", "an account, repeatable")
+	file := flags.String("file", "", "accounts as a JSON array")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	var configs []orby.ClusterAccountConfig
+	if *file != "" {
+		fileConfigs, err := orby.LoadClusterAccountsFile(*file)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, fileConfigs...)
+	}
+	for _, arg := range accountArgs {
+		config, err := orby.ParseClusterAccountArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// recordedCluster returns the recorded cluster with the given ID, or a new record for a cluster
+// created elsewhere
+func recordedCluster(instance orby.InstanceState, accountClusterId string) orby.ClusterState {
+	if cluster, ok := instance.ClusterById(accountClusterId); ok {
+		return cluster
+	}
+	return orby.ClusterState{AccountClusterId: accountClusterId, CreatedAt: time.Now()}
+}
+
+// mergeClusterAccounts returns the accounts with the added ones, without duplicates
+func mergeClusterAccounts(accounts []orby.AccountParams, added []orby.AccountParams) []orby.AccountParams {
+	merged := append([]orby.AccountParams{}, accounts...)
+	for _, account := range added {
+		if !containsClusterAccount(merged, account) {
+			merged = append(merged, account)
+		}
+	}
+	return merged
+}
+
+// subtractClusterAccounts returns the accounts without the removed ones
+func subtractClusterAccounts(accounts []orby.AccountParams, removed []orby.AccountParams) []orby.AccountParams {
+	var remaining []orby.AccountParams
+	for _, account := range accounts {
+		if !containsClusterAccount(removed, account) {
+			remaining = append(remaining, account)
+		}
+	}
+	return remaining
+}
+
+func containsClusterAccount(accounts []orby.AccountParams, account orby.AccountParams) bool {
+	fingerprint := orby.AccountsFingerprint([]orby.AccountParams{account})
+	for _, other := range accounts {
+		if orby.AccountsFingerprint([]orby.AccountParams{other}) == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyCommand serves the account cluster as a local Ethereum JSON-RPC endpoint
+func proxyCommand(args []string) error {
+	flags := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	listen := flags.String("listen", orby.GetEnvWithDefault("PROXY_LISTEN_ADDRESS", "127.0.0.1:8545"), "address to listen on")
+	chain := flags.String("chain", "", "chain served")
+	allowedOrigins := flags.String("allowed-origins", orby.GetEnvWithDefault("PROXY_ALLOWED_ORIGINS", ""), "comma-separated browser origins allowed to call the proxy")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := appConfig.Validate(orby.ClusterRequirements...); err != nil {
+		return err
+	}
+
+	// 1. Set up the account cluster and its virtual nodes as the examples do
+	accountClusterId, virtualNodes := setup()
+	if accountClusterId == "" {
+		return fmt.Errorf("failed to set up the account cluster")
+	}
+
+	// 2. Serve the chain given with -chain, or the default virtual node chain
+	chainId := virtualNodes.DefaultChainId
+	if *chain != "" {
+		var err error
+		if chainId, err = caip.ParseChainID(*chain); err != nil {
+			return fmt.Errorf("invalid -chain: %v", err)
+		}
+	}
+	if _, err := virtualNodes.ForChain(accountClusterId, chainId); err != nil {
+		return err
+	}
+
+	// 3. Serve with timeouts; writes wait for signing, which may wait for watch-only signatures
+	proxy := orby.NewRPCProxy(virtualNodes, accountClusterId, chainId)
+	for _, origin := range strings.Split(*allowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			proxy.AllowedOrigins = append(proxy.AllowedOrigins, origin)
+		}
+	}
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           proxy,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      15 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	fmt.Printf("\n[INFO] Serving account cluster %s on %s at http://%s\n", accountClusterId, orby.ChainDisplayName(chainId), *listen)
+	return server.ListenAndServe()
+}
+
+// stateCommand lists, inspects and forgets the instances and clusters recorded in the state store
+func stateCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Print(usage)
+		return fmt.Errorf("state requires a subcommand: list, inspect or forget")
+	}
+
+	store, err := orby.DefaultStateStore()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		instances := store.Instances()
+		if len(instances) == 0 {
+			fmt.Printf("[INFO] No state recorded in %s\n", store.Path())
+			return nil
+		}
+		fmt.Printf("[INFO] State recorded in %s:\n", store.Path())
+		for _, instance := range instances {
+			fmt.Printf("         %s: instance %s, %d account cluster(s), created %s\n",
+				instance.Profile, instance.Name, len(instance.Clusters), instance.CreatedAt.Format(time.RFC3339))
+		}
+		return nil
+
+	case "inspect":
+		profile := profileArg(args[1:])
+		instance, ok := store.Instance(profile)
+		if !ok {
+			return fmt.Errorf("no state recorded for profile %q", profile)
+		}
+		printInstanceState(instance)
+		return nil
+
+	case "forget":
+		flags := flag.NewFlagSet("state forget", flag.ContinueOnError)
+		clusterId := flags.String("cluster", "", "forget only this account cluster")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		profile := profileArg(flags.Args())
+
+		if *clusterId != "" {
+			if err := store.ForgetCluster(profile, *clusterId); err != nil {
+				return err
+			}
+			fmt.Printf("[INFO] Forgot account cluster %s of profile %s\n", *clusterId, profile)
+			return nil
+		}
+		if err := store.Forget(profile); err != nil {
+			return err
+		}
+		fmt.Printf("[INFO] Forgot profile %s. Its instance and clusters still exist in Orby.\n", profile)
+		return nil
+	}
+
+	return fmt.Errorf("unknown state subcommand %q (expected list, inspect or forget)", args[0])
+}
+
+// profileArg returns the profile given as the first argument, or the current profile
+func profileArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return orby.StateProfile()
+}
+
+// printInstanceState displays an instance with its clusters and virtual nodes
+func printInstanceState(instance orby.InstanceState) {
+	fmt.Printf("[INFO] Profile %s:\n", instance.Profile)
+	fmt.Printf("         Instance Name: %s\n", instance.Name)
+	fmt.Printf("         Engine Admin URL: %s\n", instance.EngineAdminURL)
+	fmt.Printf("         Private URL: %s\n", instance.PrivateURL)
+	fmt.Printf("         Public URL: %s\n", instance.PublicURL)
+	fmt.Printf("         Created: %s\n", instance.CreatedAt.Format(time.RFC3339))
+
+	for i, cluster := range instance.SortedClusters() {
+		fmt.Printf("\n         Account Cluster %d: %s\n", i+1, cluster.AccountClusterId)
+		fmt.Printf("           Created: %s\n", cluster.CreatedAt.Format(time.RFC3339))
+		for _, account := range cluster.Accounts {
+			fmt.Printf("           Account: %s (%s %s)\n", account.Address, account.VMType, account.AccountType)
+		}
+		for _, node := range cluster.SortedVirtualNodes() {
+			fmt.Printf("           Virtual Node on %s via %s: %s\n",
+				orby.ChainDisplayName(node.ChainId), node.EntrypointAccountAddress, node.RpcUrl)
+		}
+	}
+}
@@ -4,46 +4,67 @@
 package main
 
 import (
-	"crypto/ecdsa"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"go-app/src/orby"
 	orbyfunctions "go-app/src/orby/orby_functions"
 	"log"
+	"os"
 	"strconv"
-
-	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/joho/godotenv"
+	"time"
 )
 
 type ExampleRunner interface {
 	Run() error
 }
 
+// appConfig is the configuration loaded from the global flags, the environment, .env and the
+// configuration file
+var appConfig *orby.Config
+
 func main() {
-	// Set up account cluster, virtual node, and private key based on env vars
-	accountClusterId, virtualNodeClient := setup()
+	// Load the configuration, which the global flags before the command can override
+	args, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	// Subcommands manage instances and local state; without one, run the example
+	if len(args) > 0 {
+		if err := runCommand(args); err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		return
+	}
+
+	// Check the settings the example needs before creating anything
+	exampleType := orby.GetEnvWithDefault("EXAMPLE_TYPE", "")
+	requirements := append(orby.ClusterRequirements, appConfig.ExampleRequirements(exampleType)...)
+	if err := appConfig.Validate(requirements...); err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	// Set up account cluster and virtual nodes based on the configuration
+	accountClusterId, virtualNodes := setup()
 	if accountClusterId == "" {
 		log.Fatalf("[ERROR] Error setting up account cluster")
 	}
-	if virtualNodeClient == nil {
-		log.Fatalf("[ERROR] Error setting up virtual node")
-	}
 
 	// Run example
 	var example ExampleRunner
 
-	switch orby.GetEnvWithDefault("EXAMPLE_TYPE", "") {
+	switch exampleType {
 	case "getOperationsToSwap":
-		example = orbyfunctions.NewGetOperationsToSwap(*virtualNodeClient, accountClusterId)
+		example = orbyfunctions.NewGetOperationsToSwap(virtualNodes, accountClusterId)
 	case "getOperationsToExecuteTransaction":
-		example = orbyfunctions.NewGetOperationsToExecuteTransaction(*virtualNodeClient, accountClusterId)
+		example = orbyfunctions.NewGetOperationsToExecuteTransaction(virtualNodes, accountClusterId)
 	case "getOperationsToSignTypedData":
-		example = orbyfunctions.NewGetOperationsToSignTypedData(*virtualNodeClient, accountClusterId)
+		example = orbyfunctions.NewGetOperationsToSignTypedData(virtualNodes, accountClusterId)
 	case "getFungibleTokenPortfolio":
-		example = orbyfunctions.NewGetFungibleTokenPortfolio(*virtualNodeClient, accountClusterId)
+		example = orbyfunctions.NewGetFungibleTokenPortfolio(virtualNodes, accountClusterId)
 	default:
-		log.Fatalf("invalid example type: %s", orby.GetEnvWithDefault("EXAMPLE_TYPE", ""))
+		log.Fatalf("invalid example type: %s", exampleType)
 	}
 
 	// Call Run
@@ -52,12 +73,37 @@ func main() {
 	}
 }
 
-// setup creates an account cluster, virtual node, and private key based on the defined environment variables
-func setup() (string, *orby.OrbyClient) {
-	// 0. Load environment variables from .env file
-	err := godotenv.Load()
+// loadConfig parses the global flags, loads the configuration and returns the remaining arguments
+func loadConfig(args []string) ([]string, error) {
+	flags := flag.NewFlagSet("orby", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Print(usage) }
+	path := flags.String("config", "", "configuration file")
+	profile := flags.String("profile", "", "profile of the configuration file")
+	var overrides settingFlags
+	flags.Var(&overrides, "set", "a setting, repeatable: KEY=VALUE")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	values, err := overrides.Values()
 	if err != nil {
-		log.Println("[WARN] .env file not found, using environment variables")
+		return nil, err
+	}
+	if appConfig, err = orby.LoadConfig(*path, *profile, values); err != nil {
+		return nil, err
+	}
+	return flags.Args(), nil
+}
+
+// setup creates an account cluster and a pool of its virtual nodes based on the configuration
+func setup() (string, *orby.VirtualNodePool) {
+	// 0. Show where the configuration comes from
+	if appConfig.Path == "" {
+		fmt.Println("[INFO] No configuration file, using .env and environment variables")
+	} else if appConfig.Profile == "" {
+		fmt.Printf("[INFO] Configuration file: %s\n", appConfig.Path)
+	} else {
+		fmt.Printf("[INFO] Configuration file: %s (profile %s)\n", appConfig.Path, appConfig.Profile)
 	}
 
 	// ******************************** Creating a private instance using orby engine admin ********************************
@@ -71,121 +117,128 @@ func setup() (string, *orby.OrbyClient) {
 	fmt.Printf("[INFO] Orby Engine Admin URL: %s\n", orbyEngineAdminURL)
 	fmt.Printf("[INFO] Orby URL: %s\n", orbyURL)
 
-	// 3. Create Orby instance
+	// 3. Reuse the Orby instance recorded for this profile, or create one
 	instanceName := orby.GetEnvWithDefault("ORBY_INSTANCE_NAME", "")
-	fmt.Printf("\n[INFO] Creating Orby instance with name: %s\n", instanceName)
-	instanceResponse, err := orbyClient.CreateOrbyInstance(instanceName)
+	stateStore, err := orby.DefaultStateStore()
 	if err != nil {
-		log.Fatalf("[ERROR] Error creating Orby instance: %v", err)
+		log.Fatalf("[ERROR] Error opening state store: %v", err)
+	}
+	profile := orby.StateProfile()
+	refreshState, err := strconv.ParseBool(orby.GetEnvWithDefault("ORBY_STATE_REFRESH", "false"))
+	if err != nil {
+		log.Fatalf("[ERROR] Invalid ORBY_STATE_REFRESH: %v", err)
 	}
 
-	fmt.Printf("[INFO] Orby instance created successfully:\n")
-	fmt.Printf("         Success: %v\n", instanceResponse.Success)
-	fmt.Printf("         Private URL: %s\n", instanceResponse.OrbyInstancePrivateUrl)
-	fmt.Printf("         Public URL: %s\n", instanceResponse.OrbyInstancePublicUrl)
-
-	// 4. Create a private Orby client using the private URL from the response
-	privateOrbyClient := orby.NewOrbyClient(instanceResponse.OrbyInstancePrivateUrl, instanceResponse.OrbyInstancePrivateUrl)
-
-	// ********************************** Use private instance to create account cluster ***********************************
+	instance, ok := stateStore.Instance(profile)
+	if ok && !refreshState && instance.Matches(orbyEngineAdminURL, instanceName) {
+		fmt.Printf("\n[INFO] Reusing Orby instance %s of profile %s (created %s)\n",
+			instance.Name, profile, instance.CreatedAt.Format(time.RFC3339))
+		fmt.Printf("         Private URL: %s\n", instance.PrivateURL)
+		fmt.Printf("         Public URL: %s\n", instance.PublicURL)
+	} else {
+		fmt.Printf("\n[INFO] Creating Orby instance with name: %s\n", instanceName)
+		instanceResponse, err := orbyClient.CreateOrbyInstance(instanceName)
+		if err != nil {
+			log.Fatalf("[ERROR] Error creating Orby instance: %v", err)
+		}
 
-	// 5. Get private key from environment variable to derive address
-	privateKey := orby.GetPrivateKey()
-	if privateKey == nil {
-		log.Fatalf("[ERROR] Failed to get private key: %v", err)
+		fmt.Printf("[INFO] Orby instance created successfully:\n")
+		fmt.Printf("         Success: %v\n", instanceResponse.Success)
+		fmt.Printf("         Private URL: %s\n", instanceResponse.OrbyInstancePrivateUrl)
+		fmt.Printf("         Public URL: %s\n", instanceResponse.OrbyInstancePublicUrl)
+
+		instance = orby.InstanceState{
+			Profile:        profile,
+			Name:           instanceName,
+			EngineAdminURL: orbyEngineAdminURL,
+			PrivateURL:     instanceResponse.OrbyInstancePrivateUrl,
+			PublicURL:      instanceResponse.OrbyInstancePublicUrl,
+			CreatedAt:      time.Now(),
+		}
+		if err := stateStore.PutInstance(instance); err != nil {
+			log.Fatalf("[ERROR] Error saving Orby instance: %v", err)
+		}
 	}
 
-	// 6. Get public key
-	publicKey := privateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		log.Fatal("[ERROR] Error casting public key to ECDSA")
-	}
+	// 4. Create a private Orby client using the instance's private URL
+	privateOrbyClient := orby.NewOrbyClient(instance.PrivateURL, instance.PrivateURL)
 
-	// 7. Get address from public key
-	address := crypto.PubkeyToAddress(*publicKeyECDSA).Hex()
-	fmt.Printf("\n[INFO] Derived address from private key: %s\n", address)
+	// ********************************** Use private instance to create account cluster ***********************************
 
-	// 8. Create the accounts array with a single EVM EOA account
-	fmt.Println("\nCreating account cluster...")
-	accounts := []orby.AccountParams{
-		{
-			VMType:      "EVM",
-			Address:     address,
-			AccountType: "EOA",
-		},
+	// 5. Create the accounts array from the EVM EOA account, the smart account it owns and the Solana
+	// account if they are configured, and the accounts in CLUSTER_ACCOUNTS_FILE and CLUSTER_ACCOUNTS
+	accountConfigs, err := orby.ClusterAccountsFromEnv()
+	if err != nil {
+		log.Fatalf("[ERROR] Error reading cluster accounts: %v", err)
 	}
-
-	// 9. Call orby_createAccountCluster
-	clusterResult, err := privateOrbyClient.CreateAccountCluster(accounts)
+	accounts, err := orby.ResolveClusterAccounts(accountConfigs)
 	if err != nil {
-		log.Fatalf("[ERROR] Error creating account cluster: %v", err)
+		log.Fatalf("[ERROR] Error resolving cluster accounts: %v", err)
+	}
+	for _, account := range accounts {
+		fmt.Printf("[INFO] Cluster account %s (%s %s)\n", account.Address, account.VMType, account.AccountType)
 	}
 
-	// 10. Parse the account cluster response into a structured type
-	var clusterResponse orby.AccountClusterResponse
-	if err := json.Unmarshal(clusterResult, &clusterResponse); err != nil {
-		log.Printf("[ERROR] Error parsing orby_createAccountCluster response: %v", err)
-		// Try to display raw response
-		var rawResponse any
-		if json.Unmarshal(clusterResult, &rawResponse) == nil {
-			fmt.Printf("          Raw account cluster response: %v\n", rawResponse)
+	// 6. Reuse the account cluster recorded for these accounts, or call orby_createAccountCluster
+	cluster, ok := instance.Cluster(accounts)
+	if ok && !refreshState {
+		fmt.Printf("\n[INFO] Reusing account cluster %s (created %s)\n", cluster.AccountClusterId, cluster.CreatedAt.Format(time.RFC3339))
+	} else {
+		fmt.Println("\nCreating account cluster...")
+		clusterResult, err := privateOrbyClient.CreateAccountCluster(accounts)
+		if err != nil {
+			log.Fatalf("[ERROR] Error creating account cluster: %v", err)
 		}
-		return "", nil
-	}
 
-	fmt.Println("\n[INFO] Account cluster created successfully:")
-	fmt.Printf("         Account Cluster ID: %s\n", clusterResponse.AccountClusterId)
-	fmt.Println("         Accounts:")
-	for i, account := range clusterResponse.Accounts {
-		fmt.Printf("           Account %d:\n", i+1)
-		fmt.Printf("             Address: %s\n", account.Address)
-		fmt.Printf("             Type: %s\n", account.AccountType)
-		fmt.Printf("             VM Type: %s\n", account.VMType)
-		fmt.Printf("             Chain ID: %s\n", account.ChainId)
-	}
+		// 7. Parse the account cluster response into a structured type
+		var clusterResponse orby.AccountClusterResponse
+		if err := json.Unmarshal(clusterResult, &clusterResponse); err != nil {
+			log.Printf("[ERROR] Error parsing orby_createAccountCluster response: %v", err)
+			// Try to display raw response
+			var rawResponse any
+			if json.Unmarshal(clusterResult, &rawResponse) == nil {
+				fmt.Printf("          Raw account cluster response: %v\n", rawResponse)
+			}
+			return "", nil
+		}
 
-	// ******************************* Create virtual node to interact with account cluster ********************************
+		fmt.Println("\n[INFO] Account cluster created successfully:")
+		fmt.Printf("         Account Cluster ID: %s\n", clusterResponse.AccountClusterId)
+		fmt.Println("         Accounts:")
+		for i, account := range clusterResponse.Accounts {
+			fmt.Printf("           Account %d:\n", i+1)
+			fmt.Printf("             Address: %s\n", account.Address)
+			fmt.Printf("             Type: %s\n", account.AccountType)
+			fmt.Printf("             VM Type: %s\n", account.VMType)
+			fmt.Printf("             Chain: %s\n", orby.ChainDisplayName(account.ChainId))
+		}
 
-	// 11. Get source chain ids
-	inputTokenChainId, err := strconv.ParseInt(orby.GetEnvWithDefault("INPUT_TOKEN_CHAIN_ID", ""), 10, 64)
-	if err != nil {
-		log.Fatalf("[ERROR] Error getting token chain id: %v", err)
+		cluster = orby.ClusterState{
+			AccountClusterId: clusterResponse.AccountClusterId,
+			Accounts:         accounts,
+			CreatedAt:        time.Now(),
+		}
+		instance.SetCluster(cluster)
+		if err := stateStore.PutInstance(instance); err != nil {
+			log.Fatalf("[ERROR] Error saving account cluster: %v", err)
+		}
 	}
 
-	// 12. Format chain IDs to external format
-	externalInputTokenChainId := orby.GetExternalChainIdFromInternalChainId(inputTokenChainId)
-	fmt.Printf("\n[INFO] Input chain ID: %d (external format: %s)\n", inputTokenChainId, externalInputTokenChainId)
+	// ******************************* Create virtual node to interact with account cluster ********************************
 
-	// 13. Get virtual node RPC URL
-	fmt.Println("\nGetting virtual node RPC URL...")
-	virtualNodeResult, err := privateOrbyClient.GetVirtualNodeRpcUrl(
-		clusterResponse.AccountClusterId,
-		externalInputTokenChainId,
-		address,
-	)
-	if err != nil {
-		log.Fatalf("[ERROR] Error getting virtual node RPC URL: %v", err)
-	}
-
-	// 14. Parse and display the virtual node RPC URL response
-	var virtualNodeResponse orby.VirtualNodeRpcUrlResponse
-	var virtualNodeRpcUrl string
-	if err := json.Unmarshal(virtualNodeResult, &virtualNodeResponse); err != nil {
-		log.Printf("[ERROR] Error parsing orby_getVirtualNodeRpcUrl response: %v", err)
-		// Try to display raw response
-		var rawResponse interface{}
-		if json.Unmarshal(virtualNodeResult, &rawResponse) == nil {
-			fmt.Printf("          Raw virtual node RPC URL response: %v\n", rawResponse)
-		}
-	} else {
-		virtualNodeRpcUrl = virtualNodeResponse.VirtualNodeRpcUrl
-		fmt.Printf("\n[INFO] Virtual Node RPC URL: %s\n", virtualNodeRpcUrl)
-		fmt.Printf("\n[INFO] You can now use this URL to interact with the virtual node:\n%s\n", virtualNodeRpcUrl)
+	// 8. Check the chain registry, which names chains in the logs
+	if _, err := orby.DefaultChainRegistry(); err != nil {
+		log.Fatalf("[ERROR] Error loading chain registry: %v", err)
 	}
 
-	// 15. Create a client using the virtual node RPC URL for standardized token IDs
-	virtualNodeClient := orby.NewOrbyClient(virtualNodeRpcUrl, virtualNodeRpcUrl)
+	// 9. Create a pool that gets the virtual node of each chain the first time it is used
+	virtualNodes := orby.NewVirtualNodePool(privateOrbyClient, stateStore, profile)
+	virtualNodes.Refresh = refreshState
+	if virtualNodes.DefaultChainId, err = orby.DefaultVirtualNodeChainId(); err != nil {
+		log.Fatalf("[ERROR] Error getting the default virtual node chain id: %v", err)
+	}
+	fmt.Printf("\n[INFO] Default virtual node chain: %s (external format: %s)\n",
+		orby.ChainDisplayName(virtualNodes.DefaultChainId), virtualNodes.DefaultChainId.OrbyString())
 
-	return clusterResponse.AccountClusterId, virtualNodeClient
+	return cluster.AccountClusterId, virtualNodes
 }
@@ -0,0 +1,297 @@
+// abi_registry.go keeps the ABIs used to encode and decode calldata
+package orby
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	abifiles "go-app/src/abi"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Names of the ABIs embedded in the app
+const (
+	ABINameERC20   = "erc20"
+	ABINameERC721  = "erc721"
+	ABINameERC1155 = "erc1155"
+	ABINamePermit2 = "permit2"
+	ABINameWETH    = "weth"
+)
+
+// ABIRegistry maps ABI names, and optionally contract addresses, to parsed ABIs
+type ABIRegistry struct {
+	mu        sync.RWMutex
+	abis      map[string]abi.ABI
+	contracts map[string]string
+}
+
+// NewABIRegistry creates a registry preloaded with the embedded ABIs
+func NewABIRegistry() (*ABIRegistry, error) {
+	registry := &ABIRegistry{
+		abis:      make(map[string]abi.ABI),
+		contracts: make(map[string]string),
+	}
+
+	entries, err := abifiles.FS.ReadDir(".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded ABIs: %v", err)
+	}
+	for _, entry := range entries {
+		contractAbi, err := LoadABIFromFS(abifiles.FS, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		registry.Register(abiNameFromFile(entry.Name()), contractAbi)
+	}
+
+	// Permit2 and Multicall3 are deployed at the same address on every chain
+	registry.RegisterContract(Permit2Address, ABINamePermit2)
+	registry.RegisterContract(Multicall3Address, ABINameMulticall3)
+
+	return registry, nil
+}
+
+var (
+	defaultABIRegistry     *ABIRegistry
+	defaultABIRegistryErr  error
+	defaultABIRegistryOnce sync.Once
+)
+
+// DefaultABIRegistry returns the shared registry of embedded ABIs, plus any ABIs in the ABI_DIR directory
+func DefaultABIRegistry() (*ABIRegistry, error) {
+	defaultABIRegistryOnce.Do(func() {
+		defaultABIRegistry, defaultABIRegistryErr = NewABIRegistry()
+		if defaultABIRegistryErr != nil {
+			return
+		}
+		if dir := GetEnvWithDefault("ABI_DIR", ""); dir != "" {
+			defaultABIRegistryErr = defaultABIRegistry.LoadDir(dir)
+		}
+	})
+	return defaultABIRegistry, defaultABIRegistryErr
+}
+
+// Register adds or replaces an ABI under the given name
+func (r *ABIRegistry) Register(name string, contractAbi abi.ABI) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.abis[strings.ToLower(name)] = contractAbi
+}
+
+// RegisterContract associates a contract address with a registered ABI name, so calls to it decode
+// with that ABI first
+func (r *ABIRegistry) RegisterContract(address string, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.contracts[strings.ToLower(address)] = strings.ToLower(name)
+}
+
+// LoadDir registers every *.json file in dir, named after the file without its extension.
+// Files may contain a plain ABI array or a build artifact with an "abi" field.
+func (r *ABIRegistry) LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list ABIs in %s: %v", dir, err)
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read ABI file %s: %v", path, err)
+		}
+
+		// Unwrap build artifacts such as those produced by Foundry or Hardhat
+		var artifact struct {
+			ABI json.RawMessage `json:"abi"`
+		}
+		if json.Unmarshal(data, &artifact) == nil && len(artifact.ABI) > 0 {
+			data = artifact.ABI
+		}
+
+		contractAbi, err := ParseABIJSON(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		r.Register(abiNameFromFile(path), contractAbi)
+	}
+
+	return nil
+}
+
+// Get returns the ABI registered under name
+func (r *ABIRegistry) Get(name string) (abi.ABI, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	contractAbi, ok := r.abis[strings.ToLower(name)]
+	if !ok {
+		return abi.ABI{}, fmt.Errorf("unknown ABI %q (available: %s)", name, strings.Join(r.namesLocked(), ", "))
+	}
+	return contractAbi, nil
+}
+
+// Resolve returns the ABI registered under nameOrPath, or loads it from a file if no such name exists
+func (r *ABIRegistry) Resolve(nameOrPath string) (abi.ABI, error) {
+	if contractAbi, err := r.Get(nameOrPath); err == nil {
+		return contractAbi, nil
+	}
+	if _, err := os.Stat(nameOrPath); err != nil {
+		return abi.ABI{}, fmt.Errorf("%q is neither a registered ABI nor a readable file", nameOrPath)
+	}
+	return LoadABIFromFile(nameOrPath)
+}
+
+// Names returns the registered ABI names in sorted order
+func (r *ABIRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.namesLocked()
+}
+
+func (r *ABIRegistry) namesLocked() []string {
+	names := make([]string, 0, len(r.abis))
+	for name := range r.abis {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DecodedArgument is a single decoded calldata argument
+type DecodedArgument struct {
+	Name  string
+	Type  string
+	Value any
+}
+
+// DecodedCall is calldata decoded against a registered ABI
+type DecodedCall struct {
+	ABIName   string
+	Method    abi.Method
+	Arguments []DecodedArgument
+	// InnerCalls are the decoded calls bundled by a batching method such as Multicall3's aggregate3Value
+	InnerCalls []InnerCall
+}
+
+// InnerCall is a call bundled inside a batching call
+type InnerCall struct {
+	Target  common.Address
+	Value   *big.Int
+	Decoded *DecodedCall
+	Data    []byte
+}
+
+// String formats the call as "method(name=value, ...)"
+func (d *DecodedCall) String() string {
+	args := make([]string, len(d.Arguments))
+	for i, arg := range d.Arguments {
+		if arg.Name != "" {
+			args[i] = fmt.Sprintf("%s=%v", arg.Name, arg.Value)
+		} else {
+			args[i] = fmt.Sprintf("%v", arg.Value)
+		}
+	}
+	return fmt.Sprintf("%s(%s)", d.Method.RawName, strings.Join(args, ", "))
+}
+
+// DecodeCalldata decodes calldata sent to the given contract address. The ABI registered for the
+// address is tried first, then every registered ABI in name order.
+func (r *ABIRegistry) DecodeCalldata(to string, data []byte) (*DecodedCall, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("calldata is too short to contain a method selector")
+	}
+
+	r.mu.RLock()
+	names := r.namesLocked()
+	if name, ok := r.contracts[strings.ToLower(to)]; ok {
+		names = append([]string{name}, names...)
+	}
+	abis := make([]abi.ABI, len(names))
+	for i, name := range names {
+		abis[i] = r.abis[name]
+	}
+	r.mu.RUnlock()
+
+	for i, contractAbi := range abis {
+		method, err := contractAbi.MethodById(data[:4])
+		if err != nil {
+			continue
+		}
+
+		values, err := method.Inputs.Unpack(data[4:])
+		if err != nil {
+			continue
+		}
+
+		decoded := &DecodedCall{ABIName: names[i], Method: *method}
+		for j, input := range method.Inputs {
+			decoded.Arguments = append(decoded.Arguments, DecodedArgument{
+				Name:  input.Name,
+				Type:  input.Type.String(),
+				Value: values[j],
+			})
+		}
+		decoded.InnerCalls = r.decodeInnerCalls(values)
+		return decoded, nil
+	}
+
+	return nil, fmt.Errorf("no registered ABI has a method with selector %s", hexutil.Encode(data[:4]))
+}
+
+// decodeInnerCalls finds arrays of (target, data) tuples in the decoded arguments, as used by
+// Multicall3 and smart account executeBatch methods, and decodes each bundled call
+func (r *ABIRegistry) decodeInnerCalls(values []any) []InnerCall {
+	var innerCalls []InnerCall
+	for _, value := range values {
+		items := reflect.ValueOf(value)
+		if items.Kind() != reflect.Slice || items.Type().Elem().Kind() != reflect.Struct {
+			continue
+		}
+
+		for i := 0; i < items.Len(); i++ {
+			item := items.Index(i)
+
+			targetField := item.FieldByName("Target")
+			if !targetField.IsValid() {
+				break
+			}
+			target, ok := targetField.Interface().(common.Address)
+			if !ok {
+				break
+			}
+
+			var data []byte
+			for _, name := range []string{"CallData", "Data"} {
+				if field := item.FieldByName(name); field.IsValid() {
+					data, _ = field.Interface().([]byte)
+				}
+			}
+
+			innerCall := InnerCall{Target: target, Data: data, Value: new(big.Int)}
+			if field := item.FieldByName("Value"); field.IsValid() {
+				if callValue, ok := field.Interface().(*big.Int); ok {
+					innerCall.Value = callValue
+				}
+			}
+			if decoded, err := r.DecodeCalldata(target.Hex(), data); err == nil {
+				innerCall.Decoded = decoded
+			}
+			innerCalls = append(innerCalls, innerCall)
+		}
+	}
+	return innerCalls
+}
+
+func abiNameFromFile(path string) string {
+	return strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+}
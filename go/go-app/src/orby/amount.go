@@ -0,0 +1,207 @@
+// amount.go defines token amounts and converts between base units and decimal strings
+package orby
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Amount represents a token amount in base units (e.g. wei for ETH, 1e-6 USDC for USDC)
+type Amount struct {
+	value *big.Int
+}
+
+// NewAmount creates an Amount from a big.Int in base units
+func NewAmount(value *big.Int) Amount {
+	if value == nil {
+		return Amount{}
+	}
+	return Amount{value: new(big.Int).Set(value)}
+}
+
+// ParseRawAmount parses a base-10 integer string of base units into an Amount
+func ParseRawAmount(raw string) (Amount, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return Amount{}, fmt.Errorf("amount is empty")
+	}
+
+	value, ok := new(big.Int).SetString(raw, 10)
+	if !ok {
+		return Amount{}, fmt.Errorf("invalid raw amount: %s", raw)
+	}
+	if value.Sign() < 0 {
+		return Amount{}, fmt.Errorf("amount must not be negative: %s", raw)
+	}
+
+	return Amount{value: value}, nil
+}
+
+// ToRawAmount converts a decimal amount such as "1.5" into base units using the given decimals
+func ToRawAmount(amount string, decimals int) (Amount, error) {
+	amount = strings.TrimSpace(amount)
+	if amount == "" {
+		return Amount{}, fmt.Errorf("amount is empty")
+	}
+	if decimals < 0 {
+		return Amount{}, fmt.Errorf("invalid decimals: %d", decimals)
+	}
+	if strings.HasPrefix(amount, "-") {
+		return Amount{}, fmt.Errorf("amount must not be negative: %s", amount)
+	}
+
+	whole, fraction, _ := strings.Cut(amount, ".")
+	if whole == "" && fraction == "" {
+		return Amount{}, fmt.Errorf("invalid amount: %s", amount)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+
+	// Reject fractional digits that cannot be represented with the token's decimals
+	trimmedFraction := strings.TrimRight(fraction, "0")
+	if len(trimmedFraction) > decimals {
+		return Amount{}, fmt.Errorf("amount %s has more than %d decimal places", amount, decimals)
+	}
+
+	digits := whole + trimmedFraction + strings.Repeat("0", decimals-len(trimmedFraction))
+	value, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return Amount{}, fmt.Errorf("invalid amount: %s", amount)
+	}
+
+	return Amount{value: value}, nil
+}
+
+// ParseAmountInput parses a user-provided amount.
+// Plain integers ("1000000") are treated as base units, while amounts with a decimal point
+// or a unit suffix ("1.5", "1.5 USDC") are converted to base units using the given decimals.
+// It also returns the unit suffix, if any.
+func ParseAmountInput(input string, decimals int) (Amount, string, error) {
+	input = strings.TrimSpace(input)
+	number, unit, _ := strings.Cut(input, " ")
+	unit = strings.TrimSpace(unit)
+
+	if unit == "" && !strings.Contains(number, ".") {
+		amount, err := ParseRawAmount(number)
+		return amount, "", err
+	}
+
+	if decimals < 0 {
+		return Amount{}, unit, fmt.Errorf("token decimals are required to parse amount %q", input)
+	}
+
+	amount, err := ToRawAmount(number, decimals)
+	return amount, unit, err
+}
+
+// CheckAmountUnit checks the unit suffix of an amount ("USDC" in "1.5 USDC") names the token it is
+// an amount of, ignoring case
+func CheckAmountUnit(unit, symbol string) error {
+	if unit == "" || strings.EqualFold(unit, symbol) {
+		return nil
+	}
+	return fmt.Errorf("amount is in %s but the token is %s", unit, symbol)
+}
+
+// IsDecimalAmountInput returns true if ParseAmountInput needs token decimals to parse the input
+func IsDecimalAmountInput(input string) bool {
+	input = strings.TrimSpace(input)
+	return strings.Contains(input, ".") || strings.Contains(input, " ")
+}
+
+// BigInt returns a copy of the amount in base units
+func (a Amount) BigInt() *big.Int {
+	if a.value == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(a.value)
+}
+
+// IsZero returns true if the amount is zero or unset
+func (a Amount) IsZero() bool {
+	return a.value == nil || a.value.Sign() == 0
+}
+
+// String returns the amount in base units as a base-10 string
+func (a Amount) String() string {
+	if a.value == nil {
+		return "0"
+	}
+	return a.value.String()
+}
+
+// Format returns the amount as a decimal string using the given decimals, e.g. "1.5"
+func (a Amount) Format(decimals int) string {
+	raw := a.String()
+	if decimals <= 0 {
+		return raw
+	}
+
+	negative := strings.HasPrefix(raw, "-")
+	raw = strings.TrimPrefix(raw, "-")
+	if len(raw) <= decimals {
+		raw = strings.Repeat("0", decimals-len(raw)+1) + raw
+	}
+
+	whole := raw[:len(raw)-decimals]
+	fraction := strings.TrimRight(raw[len(raw)-decimals:], "0")
+
+	formatted := whole
+	if fraction != "" {
+		formatted += "." + fraction
+	}
+	if negative {
+		formatted = "-" + formatted
+	}
+	return formatted
+}
+
+// MarshalJSON encodes the amount as a base-10 string of base units
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON decodes an amount from a base-10 string, a hex string or a JSON number
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(strings.TrimSpace(string(data)), `"`)
+	if text == "" || text == "null" {
+		a.value = nil
+		return nil
+	}
+
+	value := new(big.Int)
+	var ok bool
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		value, ok = value.SetString(text[2:], 16)
+	} else {
+		value, ok = value.SetString(text, 10)
+	}
+	if !ok {
+		return fmt.Errorf("invalid amount: %s", text)
+	}
+
+	a.value = value
+	return nil
+}
+
+// Format returns the currency amount as a decimal string with its symbol, e.g. "1.5 USDC"
+func (c CurrencyAmount) Format() string {
+	return FormatAmountWithSymbol(c.Amount, c.Currency)
+}
+
+// Format returns the token amount as a decimal string with its symbol, e.g. "1.5 USDC"
+func (t TokenAmount) Format() string {
+	return FormatAmountWithSymbol(t.Amount, t.Token.Currency)
+}
+
+// FormatAmountWithSymbol formats a base unit amount using the currency's decimals and symbol
+func FormatAmountWithSymbol(amount Amount, currency Currency) string {
+	formatted := amount.Format(currency.Decimals)
+	if currency.Asset.Symbol == "" {
+		return formatted
+	}
+	return formatted + " " + currency.Asset.Symbol
+}
@@ -0,0 +1,141 @@
+package orby
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestParseAmountInput(t *testing.T) {
+	tests := []struct {
+		input    string
+		decimals int
+		want     string
+		unit     string
+		wantErr  bool
+	}{
+		// Plain integers are base units, whatever the decimals
+		{input: "1000000", decimals: 6, want: "1000000"},
+		{input: " 42 ", decimals: -1, want: "42"},
+		{input: "0", decimals: 18, want: "0"},
+		{input: "115792089237316195423570985008687907853269984665640564039457584007913129639935", decimals: 18,
+			want: "115792089237316195423570985008687907853269984665640564039457584007913129639935"},
+
+		// Decimal amounts are converted with the token's decimals
+		{input: "1.5", decimals: 6, want: "1500000"},
+		{input: "0.000001", decimals: 6, want: "1"},
+		{input: ".5", decimals: 6, want: "500000"},
+		{input: "1.", decimals: 6, want: "1000000"},
+		{input: "2.0", decimals: 0, want: "2"},
+		{input: "1.5", decimals: 18, want: "1500000000000000000"},
+
+		// Excess precision is refused unless the extra digits are zeros
+		{input: "1.0000001", decimals: 6, wantErr: true},
+		{input: "1.50000000", decimals: 6, want: "1500000"},
+		{input: "0.5", decimals: 0, wantErr: true},
+
+		// Unit suffixes convert even without a decimal point
+		{input: "1.5 USDC", decimals: 6, want: "1500000", unit: "USDC"},
+		{input: "2 ETH", decimals: 18, want: "2000000000000000000", unit: "ETH"},
+		{input: "  3   usdc ", decimals: 6, want: "3000000", unit: "usdc"},
+		{input: "1.0000001 USDC", decimals: 6, unit: "USDC", wantErr: true},
+
+		// Decimal amounts need the token's decimals
+		{input: "1.5", decimals: -1, wantErr: true},
+		{input: "1 USDC", decimals: -1, unit: "USDC", wantErr: true},
+
+		// Negative and malformed amounts are refused
+		{input: "-1", decimals: 6, wantErr: true},
+		{input: "-1.5", decimals: 6, wantErr: true},
+		{input: "-0.5 USDC", decimals: 6, unit: "USDC", wantErr: true},
+		{input: "", decimals: 6, wantErr: true},
+		{input: ".", decimals: 6, wantErr: true},
+		{input: "1.2.3", decimals: 6, wantErr: true},
+		{input: "1e6", decimals: 6, wantErr: true},
+		{input: "0x10", decimals: 6, wantErr: true},
+		{input: "one USDC", decimals: 6, unit: "USDC", wantErr: true},
+	}
+
+	for _, test := range tests {
+		amount, unit, err := ParseAmountInput(test.input, test.decimals)
+		if unit != test.unit {
+			t.Errorf("ParseAmountInput(%q, %d) unit = %q, want %q", test.input, test.decimals, unit, test.unit)
+		}
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseAmountInput(%q, %d) = %s, want an error", test.input, test.decimals, amount)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAmountInput(%q, %d): %v", test.input, test.decimals, err)
+			continue
+		}
+		if amount.String() != test.want {
+			t.Errorf("ParseAmountInput(%q, %d) = %s, want %s", test.input, test.decimals, amount, test.want)
+		}
+	}
+}
+
+func TestAmountFormat(t *testing.T) {
+	tests := []struct {
+		raw      string
+		decimals int
+		want     string
+	}{
+		{raw: "1500000", decimals: 6, want: "1.5"},
+		{raw: "1000000", decimals: 6, want: "1"},
+		{raw: "1", decimals: 6, want: "0.000001"},
+		{raw: "0", decimals: 6, want: "0"},
+		{raw: "123456789", decimals: 6, want: "123.456789"},
+		{raw: "1500000000000000000", decimals: 18, want: "1.5"},
+		{raw: "42", decimals: 0, want: "42"},
+		{raw: "42", decimals: -1, want: "42"},
+		{raw: "-1500000", decimals: 6, want: "-1.5"},
+		{raw: "-5", decimals: 6, want: "-0.000005"},
+	}
+
+	for _, test := range tests {
+		value, _ := new(big.Int).SetString(test.raw, 10)
+		amount := NewAmount(value)
+		formatted := amount.Format(test.decimals)
+		if formatted != test.want {
+			t.Errorf("Format(%s, %d) = %q, want %q", test.raw, test.decimals, formatted, test.want)
+		}
+
+		// Non-negative amounts parse back from their formatted form
+		if value.Sign() < 0 || test.decimals < 0 {
+			continue
+		}
+		parsed, _, err := ParseAmountInput(formatted+" TOKEN", test.decimals)
+		if err != nil || parsed.String() != test.raw {
+			t.Errorf("ParseAmountInput(Format(%s, %d)) = %s, %v, want %s", test.raw, test.decimals, parsed, err, test.raw)
+		}
+	}
+
+	if formatted := (Amount{}).Format(6); formatted != "0" {
+		t.Errorf("Format of an unset amount = %q, want 0", formatted)
+	}
+}
+
+func TestCheckAmountUnit(t *testing.T) {
+	tests := []struct {
+		unit    string
+		symbol  string
+		wantErr bool
+	}{
+		{unit: "", symbol: "USDC"},
+		{unit: "USDC", symbol: "USDC"},
+		{unit: "usdc", symbol: "USDC"},
+		{unit: "Eth", symbol: "ETH"},
+		{unit: "USDT", symbol: "USDC", wantErr: true},
+		{unit: "USDC.e", symbol: "USDC", wantErr: true},
+		{unit: "USDC extra", symbol: "USDC", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := CheckAmountUnit(test.unit, test.symbol)
+		if (err != nil) != test.wantErr {
+			t.Errorf("CheckAmountUnit(%q, %q) = %v, want error %v", test.unit, test.symbol, err, test.wantErr)
+		}
+	}
+}
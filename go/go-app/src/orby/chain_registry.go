@@ -0,0 +1,400 @@
+// chain_registry.go keeps chain metadata: names, native currencies, block explorers and default RPC endpoints
+package orby
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go-app/src/caip"
+)
+
+// NativeCurrency is the currency a chain's fees are paid in
+type NativeCurrency struct {
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol"`
+	Decimals int    `json:"decimals"`
+}
+
+// ChainInfo describes a chain
+type ChainInfo struct {
+	ChainId        caip.ChainID   `json:"chainId"`
+	Name           string         `json:"name,omitempty"`
+	NativeCurrency NativeCurrency `json:"nativeCurrency"`
+	// RpcUrls are public RPC endpoints, the first being the default
+	RpcUrls []string `json:"rpcUrls,omitempty"`
+	// ExplorerUrls are block explorer base URLs, the first being the default. A query string, such as
+	// Solana's ?cluster=devnet, is kept after the transaction or address path.
+	ExplorerUrls []string `json:"explorerUrls,omitempty"`
+	Testnet      *bool    `json:"testnet,omitempty"`
+}
+
+// IsTestnet reports whether the chain is a testnet
+func (c ChainInfo) IsTestnet() bool {
+	return c.Testnet != nil && *c.Testnet
+}
+
+// RpcUrl returns the default RPC endpoint, or an empty string if there is none
+func (c ChainInfo) RpcUrl() string {
+	if len(c.RpcUrls) == 0 {
+		return ""
+	}
+	return c.RpcUrls[0]
+}
+
+// DisplayName returns the chain's name and CAIP-2 ID, e.g. "Ethereum (eip155:1)"
+func (c ChainInfo) DisplayName() string {
+	if c.Name == "" {
+		return c.ChainId.String()
+	}
+	return fmt.Sprintf("%s (%s)", c.Name, c.ChainId.String())
+}
+
+// ExplorerTxURL returns the default explorer's page for a transaction, or an empty string if the
+// chain has no explorer
+func (c ChainInfo) ExplorerTxURL(hash string) string {
+	return c.explorerURL("tx", hash)
+}
+
+// ExplorerAddressURL returns the default explorer's page for an address, or an empty string if the
+// chain has no explorer
+func (c ChainInfo) ExplorerAddressURL(address string) string {
+	if c.ChainId.IsSolana() {
+		return c.explorerURL("account", address)
+	}
+	return c.explorerURL("address", address)
+}
+
+func (c ChainInfo) explorerURL(kind string, value string) string {
+	if len(c.ExplorerUrls) == 0 {
+		return ""
+	}
+	explorerUrl, err := url.Parse(c.ExplorerUrls[0])
+	if err != nil {
+		return ""
+	}
+	explorerUrl.Path = strings.TrimSuffix(explorerUrl.Path, "/") + "/" + kind + "/" + value
+	return explorerUrl.String()
+}
+
+// merge overrides the fields set in other
+func (c ChainInfo) merge(other ChainInfo) ChainInfo {
+	if other.Name != "" {
+		c.Name = other.Name
+	}
+	if other.NativeCurrency.Name != "" {
+		c.NativeCurrency.Name = other.NativeCurrency.Name
+	}
+	if other.NativeCurrency.Symbol != "" {
+		c.NativeCurrency.Symbol = other.NativeCurrency.Symbol
+	}
+	if other.NativeCurrency.Decimals != 0 {
+		c.NativeCurrency.Decimals = other.NativeCurrency.Decimals
+	}
+	if len(other.RpcUrls) > 0 {
+		c.RpcUrls = other.RpcUrls
+	}
+	if len(other.ExplorerUrls) > 0 {
+		c.ExplorerUrls = other.ExplorerUrls
+	}
+	if other.Testnet != nil {
+		c.Testnet = other.Testnet
+	}
+	return c
+}
+
+// ChainRegistry maps chain IDs to chain metadata
+type ChainRegistry struct {
+	mu     sync.RWMutex
+	chains map[string]ChainInfo
+}
+
+// NewChainRegistry creates a registry preloaded with the built-in chains
+func NewChainRegistry() *ChainRegistry {
+	registry := &ChainRegistry{chains: make(map[string]ChainInfo)}
+	for _, chain := range builtinChains() {
+		registry.Register(chain)
+	}
+	return registry
+}
+
+var (
+	defaultChainRegistry     *ChainRegistry
+	defaultChainRegistryErr  error
+	defaultChainRegistryOnce sync.Once
+)
+
+// DefaultChainRegistry returns the shared registry of built-in chains, with the overrides in the
+// CHAIN_REGISTRY_FILE file applied
+func DefaultChainRegistry() (*ChainRegistry, error) {
+	defaultChainRegistryOnce.Do(func() {
+		defaultChainRegistry = NewChainRegistry()
+		if path := GetEnvWithDefault("CHAIN_REGISTRY_FILE", ""); path != "" {
+			defaultChainRegistryErr = defaultChainRegistry.LoadFile(path)
+		}
+	})
+	return defaultChainRegistry, defaultChainRegistryErr
+}
+
+// Register adds a chain, or replaces a registered chain's metadata
+func (r *ChainRegistry) Register(chain ChainInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.chains[chain.ChainId.String()] = chain
+}
+
+// Override merges the fields set in chain into the registered chain, or adds it if it is unknown
+func (r *ChainRegistry) Override(chain ChainInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := chain.ChainId.String()
+	if existing, ok := r.chains[key]; ok {
+		chain = existing.merge(chain)
+	}
+	r.chains[key] = chain
+}
+
+// LoadFile applies the chain overrides in a JSON file holding an array of chains. Only the fields
+// set on a known chain are overridden, so a file can, for example, just replace the RPC endpoints.
+func (r *ChainRegistry) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read chain registry file %s: %v", path, err)
+	}
+
+	var chains []ChainInfo
+	if err := json.Unmarshal(data, &chains); err != nil {
+		return fmt.Errorf("failed to parse chain registry file %s: %v", path, err)
+	}
+	for i, chain := range chains {
+		if chain.ChainId.IsZero() {
+			return fmt.Errorf("%s: chain %d has no chainId", path, i+1)
+		}
+		r.Override(chain)
+	}
+	return nil
+}
+
+// Get returns the metadata of a chain
+func (r *ChainRegistry) Get(chainId caip.ChainID) (ChainInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	chain, ok := r.chains[chainId.String()]
+	return chain, ok
+}
+
+// Chains returns the registered chains sorted by chain ID
+func (r *ChainRegistry) Chains() []ChainInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	chains := make([]ChainInfo, 0, len(r.chains))
+	for _, chain := range r.chains {
+		chains = append(chains, chain)
+	}
+	sort.Slice(chains, func(i, j int) bool {
+		a, b := chains[i].ChainId, chains[j].ChainId
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		// Order EVM chains numerically
+		if len(a.Reference) != len(b.Reference) && a.IsEVM() {
+			return len(a.Reference) < len(b.Reference)
+		}
+		return a.Reference < b.Reference
+	})
+	return chains
+}
+
+// ChainDisplayName returns a chain's name and CAIP-2 ID from the default registry, or just the ID if
+// the chain is unknown
+func ChainDisplayName(chainId caip.ChainID) string {
+	registry, err := DefaultChainRegistry()
+	if err != nil {
+		return chainId.String()
+	}
+	chain, ok := registry.Get(chainId)
+	if !ok {
+		return chainId.String()
+	}
+	return chain.DisplayName()
+}
+
+// ValidateTransactionChain checks that a transaction can be signed for a chain: it must be an EVM
+// chain, and when CHAIN_REGISTRY_STRICT is true it must be in the chain registry
+func ValidateTransactionChain(chainId caip.ChainID) error {
+	if !chainId.IsEVM() {
+		return fmt.Errorf("cannot sign an EVM transaction for chain %s", chainId.String())
+	}
+
+	registry, err := DefaultChainRegistry()
+	if err != nil {
+		return err
+	}
+	if _, ok := registry.Get(chainId); ok {
+		return nil
+	}
+
+	strict, err := strconv.ParseBool(GetEnvWithDefault("CHAIN_REGISTRY_STRICT", "false"))
+	if err != nil {
+		return fmt.Errorf("invalid CHAIN_REGISTRY_STRICT: %v", err)
+	}
+	if strict {
+		return fmt.Errorf("chain %s is not in the chain registry", chainId.String())
+	}
+	fmt.Printf("[INFO] Chain %s is not in the chain registry\n", chainId.String())
+	return nil
+}
+
+// OperationRpcUrl returns the RPC endpoint PrintOperationSet shows for an operation: its TxRpcUrl, or
+// the default RPC endpoint of its chain in the chain registry. Nothing watches receipts on it yet.
+func OperationRpcUrl(operation Operation) (string, error) {
+	if operation.TxRpcUrl != "" {
+		return operation.TxRpcUrl, nil
+	}
+
+	registry, err := DefaultChainRegistry()
+	if err != nil {
+		return "", err
+	}
+	if chain, ok := registry.Get(operation.ChainId); ok && chain.RpcUrl() != "" {
+		return chain.RpcUrl(), nil
+	}
+	return "", fmt.Errorf("operation has no txRpcUrl and chain %s has no RPC endpoint in the chain registry", operation.ChainId.String())
+}
+
+// builtinChains returns the chains known without a chain registry file
+func builtinChains() []ChainInfo {
+	mainnet, testnet := false, true
+	ether := NativeCurrency{Name: "Ether", Symbol: "ETH", Decimals: 18}
+	sepoliaEther := NativeCurrency{Name: "Sepolia Ether", Symbol: "ETH", Decimals: 18}
+	sol := NativeCurrency{Name: "Solana", Symbol: "SOL", Decimals: 9}
+
+	return []ChainInfo{
+		{
+			ChainId:        caip.EIP155(1),
+			Name:           "Ethereum",
+			NativeCurrency: ether,
+			RpcUrls:        []string{"https://ethereum-rpc.publicnode.com"},
+			ExplorerUrls:   []string{"https://etherscan.io"},
+			Testnet:        &mainnet,
+		},
+		{
+			ChainId:        caip.EIP155(11155111),
+			Name:           "Sepolia",
+			NativeCurrency: sepoliaEther,
+			RpcUrls:        []string{"https://ethereum-sepolia-rpc.publicnode.com"},
+			ExplorerUrls:   []string{"https://sepolia.etherscan.io"},
+			Testnet:        &testnet,
+		},
+		{
+			ChainId:        caip.EIP155(10),
+			Name:           "OP Mainnet",
+			NativeCurrency: ether,
+			RpcUrls:        []string{"https://mainnet.optimism.io"},
+			ExplorerUrls:   []string{"https://optimistic.etherscan.io"},
+			Testnet:        &mainnet,
+		},
+		{
+			ChainId:        caip.EIP155(11155420),
+			Name:           "OP Sepolia",
+			NativeCurrency: sepoliaEther,
+			RpcUrls:        []string{"https://sepolia.optimism.io"},
+			ExplorerUrls:   []string{"https://sepolia-optimism.etherscan.io"},
+			Testnet:        &testnet,
+		},
+		{
+			ChainId:        caip.EIP155(42161),
+			Name:           "Arbitrum One",
+			NativeCurrency: ether,
+			RpcUrls:        []string{"https://arb1.arbitrum.io/rpc"},
+			ExplorerUrls:   []string{"https://arbiscan.io"},
+			Testnet:        &mainnet,
+		},
+		{
+			ChainId:        caip.EIP155(421614),
+			Name:           "Arbitrum Sepolia",
+			NativeCurrency: sepoliaEther,
+			RpcUrls:        []string{"https://sepolia-rollup.arbitrum.io/rpc"},
+			ExplorerUrls:   []string{"https://sepolia.arbiscan.io"},
+			Testnet:        &testnet,
+		},
+		{
+			ChainId:        caip.EIP155(8453),
+			Name:           "Base",
+			NativeCurrency: ether,
+			RpcUrls:        []string{"https://mainnet.base.org"},
+			ExplorerUrls:   []string{"https://basescan.org"},
+			Testnet:        &mainnet,
+		},
+		{
+			ChainId:        caip.EIP155(84532),
+			Name:           "Base Sepolia",
+			NativeCurrency: sepoliaEther,
+			RpcUrls:        []string{"https://sepolia.base.org"},
+			ExplorerUrls:   []string{"https://sepolia.basescan.org"},
+			Testnet:        &testnet,
+		},
+		{
+			ChainId:        caip.EIP155(137),
+			Name:           "Polygon",
+			NativeCurrency: NativeCurrency{Name: "POL", Symbol: "POL", Decimals: 18},
+			RpcUrls:        []string{"https://polygon-rpc.com"},
+			ExplorerUrls:   []string{"https://polygonscan.com"},
+			Testnet:        &mainnet,
+		},
+		{
+			ChainId:        caip.EIP155(80002),
+			Name:           "Polygon Amoy",
+			NativeCurrency: NativeCurrency{Name: "POL", Symbol: "POL", Decimals: 18},
+			RpcUrls:        []string{"https://rpc-amoy.polygon.technology"},
+			ExplorerUrls:   []string{"https://amoy.polygonscan.com"},
+			Testnet:        &testnet,
+		},
+		{
+			ChainId:        caip.EIP155(56),
+			Name:           "BNB Smart Chain",
+			NativeCurrency: NativeCurrency{Name: "BNB", Symbol: "BNB", Decimals: 18},
+			RpcUrls:        []string{"https://bsc-dataseed.bnbchain.org"},
+			ExplorerUrls:   []string{"https://bscscan.com"},
+			Testnet:        &mainnet,
+		},
+		{
+			ChainId:        caip.EIP155(43114),
+			Name:           "Avalanche C-Chain",
+			NativeCurrency: NativeCurrency{Name: "Avalanche", Symbol: "AVAX", Decimals: 18},
+			RpcUrls:        []string{"https://api.avax.network/ext/bc/C/rpc"},
+			ExplorerUrls:   []string{"https://snowtrace.io"},
+			Testnet:        &mainnet,
+		},
+		{
+			ChainId:        SolanaMainnetChainId,
+			Name:           "Solana",
+			NativeCurrency: sol,
+			RpcUrls:        []string{"https://api.mainnet-beta.solana.com"},
+			ExplorerUrls:   []string{"https://explorer.solana.com"},
+			Testnet:        &mainnet,
+		},
+		{
+			ChainId:        SolanaDevnetChainId,
+			Name:           "Solana Devnet",
+			NativeCurrency: sol,
+			RpcUrls:        []string{"https://api.devnet.solana.com"},
+			ExplorerUrls:   []string{"https://explorer.solana.com?cluster=devnet"},
+			Testnet:        &testnet,
+		},
+		{
+			ChainId:        SolanaTestnetChainId,
+			Name:           "Solana Testnet",
+			NativeCurrency: sol,
+			RpcUrls:        []string{"https://api.testnet.solana.com"},
+			ExplorerUrls:   []string{"https://explorer.solana.com?cluster=testnet"},
+			Testnet:        &testnet,
+		},
+	}
+}
@@ -0,0 +1,238 @@
+// cluster_accounts.go describes the accounts of an account cluster and the keys that sign for them
+package orby
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"go-app/src/caip"
+)
+
+// ClusterAccountConfig is an account to put in an account cluster, as given in CLUSTER_ACCOUNTS_FILE,
+// CLUSTER_ACCOUNTS or on the command line
+type ClusterAccountConfig struct {
+	// VMType is EVM (the default) or SVM
+	VMType string `json:"vmType,omitempty"`
+	// AccountType is EOA (the default) or SCA
+	AccountType string `json:"accountType,omitempty"`
+	// Address may be omitted for EOAs with a key, whose address is derived from it
+	Address string `json:"address,omitempty"`
+	// PrivateKeyEnv names the environment variable holding the account's key, or a smart account's
+	// owner key. Accounts without a key are watch-only.
+	PrivateKeyEnv string `json:"privateKeyEnv,omitempty"`
+	// ChainIds limits the chains the account signs on; it signs on every chain of its VM if empty
+	ChainIds []caip.ChainID `json:"chainIds,omitempty"`
+	// SignatureScheme is how a smart account's owner signatures are made (owner by default), and
+	// SignaturePrefix the hex prefix of the prefixed scheme
+	SignatureScheme string `json:"signatureScheme,omitempty"`
+	SignaturePrefix string `json:"signaturePrefix,omitempty"`
+}
+
+// ParseClusterAccountArg parses an account given as [VM:][TYPE:]<address> or [VM:]env:<VARIABLE>,
+// e.g. 0xabc..., SCA:0xabc..., SVM:<base58 address> or env:PRIVATE_KEY_2
+func ParseClusterAccountArg(arg string) (ClusterAccountConfig, error) {
+	config := ClusterAccountConfig{VMType: VMTypeEVM, AccountType: AccountTypeEOA}
+
+	parts := strings.Split(strings.TrimSpace(arg), ":")
+	for len(parts) > 1 {
+		switch strings.ToUpper(parts[0]) {
+		case VMTypeEVM, VMTypeSVM:
+			config.VMType = strings.ToUpper(parts[0])
+		case AccountTypeEOA, AccountTypeSCA:
+			config.AccountType = strings.ToUpper(parts[0])
+		case "ENV":
+			if len(parts) != 2 || parts[1] == "" {
+				return ClusterAccountConfig{}, fmt.Errorf("invalid account %q: expected env:<VARIABLE>", arg)
+			}
+			config.PrivateKeyEnv = parts[1]
+			return config, nil
+		default:
+			return ClusterAccountConfig{}, fmt.Errorf("invalid account %q: unknown prefix %q", arg, parts[0])
+		}
+		parts = parts[1:]
+	}
+
+	if parts[0] == "" {
+		return ClusterAccountConfig{}, fmt.Errorf("invalid account %q: missing address", arg)
+	}
+	config.Address = parts[0]
+	return config, nil
+}
+
+// LoadClusterAccountsFile reads a JSON array of cluster accounts
+func LoadClusterAccountsFile(path string) ([]ClusterAccountConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster accounts file %s: %v", path, err)
+	}
+
+	var configs []ClusterAccountConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse cluster accounts file %s: %v", path, err)
+	}
+	return configs, nil
+}
+
+// ClusterAccountsFromEnv returns the configured cluster accounts: the EOA of PRIVATE_KEY, the smart
+// account in SMART_ACCOUNT_ADDRESS, the Solana account of SOLANA_PRIVATE_KEY, the accounts in the
+// CLUSTER_ACCOUNTS_FILE file and the comma-separated accounts in CLUSTER_ACCOUNTS
+func ClusterAccountsFromEnv() ([]ClusterAccountConfig, error) {
+	var configs []ClusterAccountConfig
+	if GetEnvWithDefault("PRIVATE_KEY", "") != "" {
+		configs = append(configs, ClusterAccountConfig{VMType: VMTypeEVM, AccountType: AccountTypeEOA, PrivateKeyEnv: "PRIVATE_KEY"})
+	}
+
+	smartAccount, err := SmartAccountFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if smartAccount != nil {
+		configs = append(configs, ClusterAccountConfig{
+			VMType:          VMTypeEVM,
+			AccountType:     AccountTypeSCA,
+			Address:         smartAccount.Address.Hex(),
+			PrivateKeyEnv:   "PRIVATE_KEY",
+			SignatureScheme: string(smartAccount.Scheme),
+			SignaturePrefix: GetEnvWithDefault("SMART_ACCOUNT_SIGNATURE_PREFIX", ""),
+		})
+	}
+
+	if GetEnvWithDefault("SOLANA_PRIVATE_KEY", "") != "" {
+		configs = append(configs, ClusterAccountConfig{VMType: VMTypeSVM, AccountType: AccountTypeEOA, PrivateKeyEnv: "SOLANA_PRIVATE_KEY"})
+	}
+
+	if path := GetEnvWithDefault("CLUSTER_ACCOUNTS_FILE", ""); path != "" {
+		fileConfigs, err := LoadClusterAccountsFile(path)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, fileConfigs...)
+	}
+
+	for _, arg := range strings.Split(GetEnvWithDefault("CLUSTER_ACCOUNTS", ""), ",") {
+		if strings.TrimSpace(arg) == "" {
+			continue
+		}
+		config, err := ParseClusterAccountArg(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CLUSTER_ACCOUNTS: %v", err)
+		}
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
+
+// Resolve returns the account's cluster parameters, deriving its address from its key if needed
+func (c ClusterAccountConfig) Resolve() (AccountParams, error) {
+	account := AccountParams{
+		VMType:      strings.ToUpper(c.VMType),
+		Address:     strings.TrimSpace(c.Address),
+		AccountType: strings.ToUpper(c.AccountType),
+	}
+	if account.VMType == "" {
+		account.VMType = VMTypeEVM
+	}
+	if account.AccountType == "" {
+		account.AccountType = AccountTypeEOA
+	}
+
+	if c.PrivateKeyEnv != "" && account.AccountType == AccountTypeEOA {
+		address, err := c.keyAddress(account.VMType)
+		if err != nil {
+			return AccountParams{}, err
+		}
+		if account.Address != "" && !sameAddress(account.VMType, account.Address, address) {
+			return AccountParams{}, fmt.Errorf("account %s does not match the key in %s (%s)", account.Address, c.PrivateKeyEnv, address)
+		}
+		account.Address = address
+	}
+
+	switch account.VMType {
+	case VMTypeEVM:
+		if !common.IsHexAddress(account.Address) {
+			return AccountParams{}, fmt.Errorf("invalid EVM account address %q", account.Address)
+		}
+		account.Address = common.HexToAddress(account.Address).Hex()
+	case VMTypeSVM:
+		if decoded, err := Base58Decode(account.Address); err != nil || len(decoded) != ed25519.PublicKeySize {
+			return AccountParams{}, fmt.Errorf("invalid Solana account address %q", account.Address)
+		}
+		if account.AccountType != AccountTypeEOA {
+			return AccountParams{}, fmt.Errorf("Solana account %s must be an %s", account.Address, AccountTypeEOA)
+		}
+	default:
+		return AccountParams{}, fmt.Errorf("unknown VM type %q (expected %s or %s)", account.VMType, VMTypeEVM, VMTypeSVM)
+	}
+	if account.AccountType != AccountTypeEOA && account.AccountType != AccountTypeSCA {
+		return AccountParams{}, fmt.Errorf("unknown account type %q (expected %s or %s)", account.AccountType, AccountTypeEOA, AccountTypeSCA)
+	}
+
+	return account, nil
+}
+
+// SmartAccount returns the smart account of an SCA account, signing in its scheme, or nil for an EOA
+func (c ClusterAccountConfig) SmartAccount(account AccountParams) (*SmartAccount, error) {
+	if account.AccountType != AccountTypeSCA {
+		if c.SignatureScheme != "" || c.SignaturePrefix != "" {
+			return nil, fmt.Errorf("account %s is not a smart account and cannot have a signature scheme", account.Address)
+		}
+		return nil, nil
+	}
+	return NewSmartAccount(account.Address, c.SignatureScheme, c.SignaturePrefix)
+}
+
+// keyAddress returns the address of the key in the account's PrivateKeyEnv variable
+func (c ClusterAccountConfig) keyAddress(vmType string) (string, error) {
+	value := strings.TrimSpace(os.Getenv(c.PrivateKeyEnv))
+	if value == "" {
+		return "", fmt.Errorf("%s is not set", c.PrivateKeyEnv)
+	}
+
+	if vmType == VMTypeSVM {
+		privateKey, err := ParseSolanaPrivateKey(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s: %v", c.PrivateKeyEnv, err)
+		}
+		return SolanaAddress(privateKey.Public().(ed25519.PublicKey)), nil
+	}
+
+	privateKey, err := ParsePrivateKey(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %v", c.PrivateKeyEnv, err)
+	}
+	return crypto.PubkeyToAddress(privateKey.PublicKey).Hex(), nil
+}
+
+// ResolveClusterAccounts resolves account configs into cluster parameters, dropping duplicates
+func ResolveClusterAccounts(configs []ClusterAccountConfig) ([]AccountParams, error) {
+	accounts := make([]AccountParams, 0, len(configs))
+	seen := make(map[string]bool)
+	for _, config := range configs {
+		account, err := config.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		key := AccountsFingerprint([]AccountParams{account})
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// sameAddress compares addresses, ignoring case for EVM addresses
+func sameAddress(vmType string, a string, b string) bool {
+	if vmType == VMTypeSVM {
+		return a == b
+	}
+	return strings.EqualFold(a, b)
+}
@@ -0,0 +1,615 @@
+// config.go loads the settings of a profile from a YAML configuration file, layered under the
+// environment and command line overrides, and validates them before they are used
+package orby
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+
+	"go-app/src/caip"
+)
+
+// ConfigFileNames are the files looked for in the working directory when neither -config nor
+// ORBY_CONFIG_FILE names the configuration file
+var ConfigFileNames = []string{"orby.yaml", "orby.yml"}
+
+type settingKind int
+
+const (
+	kindString settingKind = iota
+	kindURL
+	kindBool
+	kindUint
+	kindSeconds
+	kindChainID
+	kindAddress
+	kindPrivateKey
+	kindSolanaPrivateKey
+)
+
+// configSetting describes a setting, named by its environment variable
+type configSetting struct {
+	Key    string
+	Kind   settingKind
+	Values []string // the allowed values, if restricted
+	Secret bool
+}
+
+// configSettings are the settings read by the app, in the order they are shown
+var configSettings = []configSetting{
+	{Key: "ORBY_ENGINE_ADMIN_URL", Kind: kindURL},
+	{Key: "ORBY_URL", Kind: kindURL},
+	{Key: "ORBY_INSTANCE_NAME"},
+	{Key: "ORBY_PROFILE"},
+	{Key: "ORBY_STATE_FILE"},
+	{Key: "ORBY_STATE_REFRESH", Kind: kindBool},
+	{Key: "EXAMPLE_TYPE", Values: []string{"getOperationsToSwap", "getOperationsToExecuteTransaction", "getOperationsToSignTypedData", "getFungibleTokenPortfolio"}},
+	{Key: "PRIVATE_KEY", Kind: kindPrivateKey, Secret: true},
+	{Key: "SMART_ACCOUNT_ADDRESS", Kind: kindAddress},
+	{Key: "SMART_ACCOUNT_SCHEME", Values: []string{string(SignatureSchemeOwner), string(SignatureSchemePrefixed), string(SignatureSchemeSafe)}},
+	{Key: "SMART_ACCOUNT_SIGNATURE_PREFIX"},
+	{Key: "SMART_ACCOUNT_RPC_URL", Kind: kindURL},
+	{Key: "USER_OPERATION_ENTRY_POINT", Kind: kindAddress},
+	{Key: "SOLANA_PRIVATE_KEY", Kind: kindSolanaPrivateKey, Secret: true},
+	{Key: "CLUSTER_ACCOUNTS"},
+	{Key: "CLUSTER_ACCOUNTS_FILE"},
+	{Key: "WATCH_ONLY_SIGNATURE_DIR"},
+	{Key: "WATCH_ONLY_SIGNATURE_TIMEOUT_SECONDS", Kind: kindUint},
+	{Key: "VIRTUAL_NODE_CHAIN_ID", Kind: kindChainID},
+	{Key: "PROXY_LISTEN_ADDRESS"},
+	{Key: "PROXY_ALLOWED_ORIGINS"},
+	{Key: "INPUT_TOKEN_ADDRESS"},
+	{Key: "INPUT_TOKEN_CHAIN_ID", Kind: kindChainID},
+	{Key: "INPUT_TOKEN_DECIMALS", Kind: kindUint},
+	{Key: "OUTPUT_TOKEN_ADDRESS"},
+	{Key: "OUTPUT_TOKEN_CHAIN_ID", Kind: kindChainID},
+	{Key: "AMOUNT"},
+	{Key: "TOKEN_LIST"},
+	{Key: "TOKEN_REGISTRY_STORE"},
+	{Key: "TOKEN_REGISTRY_TTL_SECONDS", Kind: kindUint},
+	{Key: "CHAIN_REGISTRY_FILE"},
+	{Key: "CHAIN_REGISTRY_STRICT", Kind: kindBool},
+	{Key: "ABI_DIR"},
+	{Key: "CONTRACT_ADDRESS"},
+	{Key: "CONTRACT_ABI"},
+	{Key: "CONTRACT_METHOD"},
+	{Key: "CONTRACT_ARGS"},
+	{Key: "CONTRACT_VALUE"},
+	{Key: "CONTRACT_CALLS"},
+	{Key: "BATCH_MODE", Values: []string{string(BatchModeMulticall3), string(BatchModeAccount)}},
+	{Key: "BATCH_ACCOUNT_ADDRESS", Kind: kindAddress},
+	{Key: "TYPED_DATA"},
+	{Key: "PERMIT_TYPE", Values: []string{"permit2", string(PermitKindERC2612), string(PermitKindDAI)}},
+	{Key: "PERMIT_SPENDER", Kind: kindAddress},
+	{Key: "PERMIT_DEADLINE", Kind: kindUint},
+	{Key: "PERMIT_ALLOWED", Kind: kindBool},
+	{Key: "PERMIT_RPC_URL", Kind: kindURL},
+	{Key: "PERMIT2_SPENDER", Kind: kindAddress},
+	{Key: "PERMIT2_DEADLINE", Kind: kindUint},
+	{Key: "PERMIT2_NONCE"},
+	{Key: "PERMIT2_NONCE_STORE"},
+	{Key: "PERMIT2_INVALIDATE_NONCES"},
+	{Key: "PERMIT2_WITNESS"},
+	{Key: "PERMIT2_RPC_URL", Kind: kindURL},
+	{Key: "QUOTE_MAX_AGE_SECONDS", Kind: kindSeconds},
+	{Key: "QUOTE_MAX_PRICE_MOVEMENT_BPS", Kind: kindUint},
+	{Key: "QUOTE_MAX_REFRESHES", Kind: kindUint},
+}
+
+// ConfigSettingKeys returns the environment variables of the known settings
+func ConfigSettingKeys() []string {
+	keys := make([]string, len(configSettings))
+	for i, setting := range configSettings {
+		keys[i] = setting.Key
+	}
+	return keys
+}
+
+func lookupSetting(key string) (configSetting, bool) {
+	key = strings.ToUpper(key)
+	for _, setting := range configSettings {
+		if setting.Key == key {
+			return setting, true
+		}
+	}
+	return configSetting{}, false
+}
+
+// ConfigFile is the YAML configuration file: settings shared by every profile, and named profiles
+// such as testnet, mainnet or one per team. Settings are named by their environment variable, in
+// lower or upper case.
+//
+//	default_profile: testnet
+//	defaults:
+//	  orby_engine_admin_url: https://admin.example
+//	profiles:
+//	  testnet:
+//	    input_token_chain_id: 11155111
+//	  payments-team:
+//	    extends: testnet
+//	    orby_instance_name: payments
+type ConfigFile struct {
+	DefaultProfile string                   `yaml:"default_profile"`
+	Defaults       ConfigProfile            `yaml:"defaults"`
+	Profiles       map[string]ConfigProfile `yaml:"profiles"`
+}
+
+// ConfigProfile is a set of settings. Extends names a profile whose settings it overrides, and Env
+// holds other environment variables to set, such as the private keys CLUSTER_ACCOUNTS refers to.
+type ConfigProfile struct {
+	Extends  string
+	Settings map[string]string
+	Env      map[string]string
+}
+
+// UnmarshalYAML reads a profile, rejecting unknown settings with the line they are on
+func (p *ConfigProfile) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: a profile must be a mapping of settings", node.Line)
+	}
+	p.Settings = make(map[string]string)
+	p.Env = make(map[string]string)
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch key.Value {
+		case "extends":
+			if value.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: extends must be a profile name", value.Line)
+			}
+			p.Extends = value.Value
+		case "env":
+			if value.Kind != yaml.MappingNode {
+				return fmt.Errorf("line %d: env must be a mapping of environment variables", value.Line)
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				if value.Content[j+1].Kind != yaml.ScalarNode {
+					return fmt.Errorf("line %d: env %s must be a single value", value.Content[j+1].Line, value.Content[j].Value)
+				}
+				p.Env[value.Content[j].Value] = value.Content[j+1].Value
+			}
+		default:
+			setting, ok := lookupSetting(key.Value)
+			if !ok {
+				return fmt.Errorf("line %d: unknown setting %q; settings are the environment variables listed in the README, and other variables go under env", key.Line, key.Value)
+			}
+			if setting.Key == "ORBY_PROFILE" {
+				return fmt.Errorf("line %d: the profile is selected with -profile, ORBY_PROFILE or default_profile, not set in a profile", key.Line)
+			}
+			if value.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: %s must be a single value", value.Line, key.Value)
+			}
+			// The raw text is kept, so hex values such as addresses are not read as numbers
+			p.Settings[setting.Key] = value.Value
+		}
+	}
+	return nil
+}
+
+// ConfigValue is the value of a setting or environment variable, and where it came from
+type ConfigValue struct {
+	Key    string
+	Value  string
+	Source string
+	// KeyVariable is set when the value is named as a private key by CLUSTER_ACCOUNTS or
+	// CLUSTER_ACCOUNTS_FILE, whatever its name
+	KeyVariable bool
+}
+
+// Config is the configuration in effect: the command line overrides, then the environment and
+// .env, then the selected profile of the configuration file and the profiles it extends, then its
+// defaults. The values are exported to the environment, where the rest of the app reads them.
+type Config struct {
+	// Path is the configuration file, empty if there is none
+	Path string
+	// Profile is the selected profile of the configuration file, empty if there is none
+	Profile string
+	// Profiles are the profiles defined in the configuration file
+	Profiles []string
+
+	values map[string]ConfigValue
+	extra  []string
+}
+
+// LoadConfig loads the configuration. path and profile come from the -config and -profile flags,
+// and overrides from -set; empty values fall back to ORBY_CONFIG_FILE, ORBY_PROFILE and the
+// file's default_profile.
+func LoadConfig(path, profile string, overrides map[string]string) (*Config, error) {
+	config := &Config{values: make(map[string]ConfigValue)}
+
+	// 1. Apply the command line overrides, which take precedence over everything else
+	for key, value := range overrides {
+		setting, ok := lookupSetting(key)
+		if !ok {
+			return nil, fmt.Errorf("-set %s: unknown setting; settings are the environment variables listed in the README", key)
+		}
+		if err := config.export(setting.Key, value, "-set flag", true); err != nil {
+			return nil, err
+		}
+	}
+	if profile != "" {
+		if err := config.export("ORBY_PROFILE", profile, "-profile flag", true); err != nil {
+			return nil, err
+		}
+	}
+
+	// 2. Record the settings in the environment, then load .env without overriding them
+	for _, setting := range configSettings {
+		if _, ok := config.values[setting.Key]; ok {
+			continue
+		}
+		if value := os.Getenv(setting.Key); value != "" {
+			config.record(setting.Key, value, "environment")
+		}
+	}
+	dotenv, err := godotenv.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read .env: %v", err)
+	}
+	for key, value := range dotenv {
+		if err := config.export(key, value, ".env", false); err != nil {
+			return nil, err
+		}
+	}
+
+	// 3. Find the configuration file
+	config.Path = path
+	if config.Path == "" {
+		config.Path = GetEnvWithDefault("ORBY_CONFIG_FILE", "")
+	}
+	if config.Path == "" {
+		for _, name := range ConfigFileNames {
+			if _, err := os.Stat(name); err == nil {
+				config.Path = name
+				break
+			}
+		}
+	}
+	if config.Path == "" {
+		return config, nil
+	}
+	file, err := ReadConfigFile(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	for name := range file.Profiles {
+		config.Profiles = append(config.Profiles, name)
+	}
+	sort.Strings(config.Profiles)
+
+	// 4. Select the profile; it also names the recorded state, so each profile keeps its own instance
+	config.Profile = GetEnvWithDefault("ORBY_PROFILE", "")
+	if config.Profile == "" && file.DefaultProfile != "" {
+		config.Profile = file.DefaultProfile
+		if err := config.export("ORBY_PROFILE", config.Profile, "default_profile in "+config.Path, false); err != nil {
+			return nil, err
+		}
+	}
+	if config.Profile != "" && len(file.Profiles) > 0 {
+		if _, ok := file.Profiles[config.Profile]; !ok {
+			return nil, fmt.Errorf("profile %q is not defined in %s (profiles: %s); select one with -profile or ORBY_PROFILE",
+				config.Profile, config.Path, strings.Join(config.Profiles, ", "))
+		}
+	}
+
+	// 5. Apply the profile, then the profiles it extends, then the defaults, each only setting what
+	// is still unset
+	layers, err := file.chain(config.Profile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", config.Path, err)
+	}
+	for _, name := range layers {
+		source := fmt.Sprintf("profile %q in %s", name, config.Path)
+		if err := config.exportProfile(file.Profiles[name], source); err != nil {
+			return nil, err
+		}
+	}
+	if err := config.exportProfile(file.Defaults, "defaults in "+config.Path); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ReadConfigFile reads and parses a configuration file
+func ReadConfigFile(path string) (*ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %v", err)
+	}
+	var file ConfigFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if file.DefaultProfile != "" {
+		if _, ok := file.Profiles[file.DefaultProfile]; !ok {
+			return nil, fmt.Errorf("%s: default_profile %q is not defined under profiles", path, file.DefaultProfile)
+		}
+	}
+	return &file, nil
+}
+
+// chain returns a profile followed by the profiles it extends
+func (f *ConfigFile) chain(profile string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for name := profile; name != ""; {
+		if seen[name] {
+			return nil, fmt.Errorf("profile %q extends itself through %s", name, strings.Join(names, " -> "))
+		}
+		seen[name] = true
+		p, ok := f.Profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("profile %q extends %q, which is not defined", names[len(names)-1], name)
+		}
+		names = append(names, name)
+		name = p.Extends
+	}
+	return names, nil
+}
+
+func (c *Config) exportProfile(profile ConfigProfile, source string) error {
+	for key, value := range profile.Settings {
+		if err := c.export(key, value, source, false); err != nil {
+			return err
+		}
+	}
+	for key, value := range profile.Env {
+		if err := c.export(key, value, source+", under env", false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// export sets an environment variable unless it is already set or override is true, and records it
+func (c *Config) export(key, value, source string, override bool) error {
+	if !override && os.Getenv(key) != "" {
+		return nil
+	}
+	if err := os.Setenv(key, value); err != nil {
+		return fmt.Errorf("failed to set %s: %v", key, err)
+	}
+	c.record(key, value, source)
+	return nil
+}
+
+func (c *Config) record(key, value, source string) {
+	if _, ok := lookupSetting(key); !ok {
+		if _, seen := c.values[key]; !seen {
+			c.extra = append(c.extra, key)
+		}
+	}
+	c.values[key] = ConfigValue{Key: key, Value: value, Source: source}
+}
+
+// Get returns the value of a setting
+func (c *Config) Get(key string) string {
+	return c.values[key].Value
+}
+
+// Values returns the values that are set, the known settings first
+func (c *Config) Values() []ConfigValue {
+	var values []ConfigValue
+	for _, setting := range configSettings {
+		if value, ok := c.values[setting.Key]; ok {
+			values = append(values, value)
+		}
+	}
+	extra := append([]string(nil), c.extra...)
+	sort.Strings(extra)
+	for _, key := range extra {
+		values = append(values, c.values[key])
+	}
+
+	keyVariables := c.keyVariables()
+	for i := range values {
+		values[i].KeyVariable = keyVariables[values[i].Key]
+	}
+	return values
+}
+
+// keyVariables returns the variables named as private keys by the env:<VARIABLE> accounts of
+// CLUSTER_ACCOUNTS and the privateKeyEnv fields of CLUSTER_ACCOUNTS_FILE. Accounts that do not parse
+// are skipped here and reported when the cluster is set up.
+func (c *Config) keyVariables() map[string]bool {
+	variables := make(map[string]bool)
+	for _, arg := range strings.Split(c.Get("CLUSTER_ACCOUNTS"), ",") {
+		if account, err := ParseClusterAccountArg(arg); err == nil && account.PrivateKeyEnv != "" {
+			variables[account.PrivateKeyEnv] = true
+		}
+	}
+	if path := c.Get("CLUSTER_ACCOUNTS_FILE"); path != "" {
+		if accounts, err := LoadClusterAccountsFile(path); err == nil {
+			for _, account := range accounts {
+				if account.PrivateKeyEnv != "" {
+					variables[account.PrivateKeyEnv] = true
+				}
+			}
+		}
+	}
+	return variables
+}
+
+// Redacted returns a value for display: secrets and the keys of cluster accounts are hidden, as are
+// passwords and query parameters of URLs, which often carry API keys
+func (v ConfigValue) Redacted() string {
+	setting, known := lookupSetting(v.Key)
+	if setting.Secret || v.KeyVariable || (!known && secretName(v.Key)) {
+		return "<redacted>"
+	}
+	if setting.Kind == kindURL || (!known && strings.HasSuffix(v.Key, "_URL")) {
+		if u, err := url.Parse(v.Value); err == nil && u.Host != "" {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), "redacted")
+			}
+			if u.RawQuery != "" {
+				query := u.Query()
+				for key := range query {
+					query.Set(key, "redacted")
+				}
+				u.RawQuery = query.Encode()
+			}
+			return u.String()
+		}
+	}
+	return v.Value
+}
+
+func secretName(key string) bool {
+	for _, part := range []string{"PRIVATE_KEY", "SECRET", "PASSWORD", "API_KEY", "MNEMONIC"} {
+		if strings.Contains(strings.ToUpper(key), part) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigRequirement lists settings of which at least one must be set
+type ConfigRequirement []string
+
+// ClusterRequirements are the settings needed to create the instance and account cluster
+var ClusterRequirements = []ConfigRequirement{
+	{"ORBY_ENGINE_ADMIN_URL"},
+	{"ORBY_INSTANCE_NAME"},
+	{"PRIVATE_KEY", "SOLANA_PRIVATE_KEY", "CLUSTER_ACCOUNTS", "CLUSTER_ACCOUNTS_FILE"},
+}
+
+// ExampleRequirements returns the settings needed to run an example, besides ClusterRequirements
+func (c *Config) ExampleRequirements(exampleType string) []ConfigRequirement {
+	requirements := []ConfigRequirement{{"EXAMPLE_TYPE"}}
+	switch exampleType {
+	case "getOperationsToSwap":
+		requirements = append(requirements, ConfigRequirement{"INPUT_TOKEN_ADDRESS"}, ConfigRequirement{"INPUT_TOKEN_CHAIN_ID"},
+			ConfigRequirement{"OUTPUT_TOKEN_ADDRESS"}, ConfigRequirement{"OUTPUT_TOKEN_CHAIN_ID"}, ConfigRequirement{"AMOUNT"})
+	case "getOperationsToExecuteTransaction":
+		requirements = append(requirements, ConfigRequirement{"INPUT_TOKEN_ADDRESS"}, ConfigRequirement{"INPUT_TOKEN_CHAIN_ID"})
+	case "getOperationsToSignTypedData":
+		if c.Get("TYPED_DATA") != "" {
+			break
+		}
+		requirements = append(requirements, ConfigRequirement{"INPUT_TOKEN_ADDRESS"}, ConfigRequirement{"INPUT_TOKEN_CHAIN_ID"})
+		switch c.Get("PERMIT_TYPE") {
+		case "", "permit2":
+			requirements = append(requirements, ConfigRequirement{"PERMIT2_SPENDER"})
+		default:
+			requirements = append(requirements, ConfigRequirement{"PERMIT_SPENDER"})
+		}
+	}
+	return requirements
+}
+
+// Validate checks the format of every setting that is set and that the required ones are, and
+// returns every problem at once along with where to fix it
+func (c *Config) Validate(requirements ...ConfigRequirement) error {
+	var problems []string
+
+	// 1. Check the format of the settings that are set
+	for _, value := range c.Values() {
+		setting, ok := lookupSetting(value.Key)
+		if !ok {
+			continue
+		}
+		if err := setting.check(value.Value); err != nil {
+			shown := value.Redacted()
+			if setting.Secret || value.KeyVariable {
+				shown = "value"
+			} else {
+				shown = strconv.Quote(shown)
+			}
+			problems = append(problems, fmt.Sprintf("%s %s (from %s) %v", value.Key, shown, value.Source, err))
+		}
+	}
+
+	// 2. Check the required settings
+	for _, requirement := range requirements {
+		found := false
+		for _, key := range requirement {
+			if c.Get(key) != "" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s is required: %s", strings.Join(requirement, " or "), c.where(requirement[0])))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+}
+
+// where tells where a setting can be set
+func (c *Config) where(key string) string {
+	name := strings.ToLower(key)
+	switch {
+	case c.Path != "" && c.Profile != "":
+		return fmt.Sprintf("set %s in profile %q of %s, or the %s environment variable", name, c.Profile, c.Path, key)
+	case c.Path != "":
+		return fmt.Sprintf("set %s under defaults in %s, or the %s environment variable", name, c.Path, key)
+	}
+	return fmt.Sprintf("set %s in .env or the environment, or create %s", key, ConfigFileNames[0])
+}
+
+// check returns why a value does not fit the setting, if it does not
+func (s configSetting) check(value string) error {
+	if len(s.Values) > 0 {
+		for _, allowed := range s.Values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("is not one of %s", strings.Join(s.Values, ", "))
+	}
+
+	switch s.Kind {
+	case kindURL:
+		u, err := url.Parse(value)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ws" && u.Scheme != "wss") {
+			return fmt.Errorf("is not a URL: expected one such as https://host/path")
+		}
+	case kindBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("is not a boolean: expected true or false")
+		}
+	case kindUint:
+		if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+			return fmt.Errorf("is not a whole number of 0 or more")
+		}
+	case kindSeconds:
+		if seconds, err := strconv.ParseFloat(value, 64); err != nil || seconds <= 0 {
+			return fmt.Errorf("is not a number of seconds greater than 0")
+		}
+	case kindChainID:
+		if _, err := caip.ParseChainID(value); err != nil {
+			return fmt.Errorf("is not a chain ID: use a number (1), the CAIP-2 form (eip155:1) or Orby's form (eip155-1)")
+		}
+	case kindAddress:
+		if !common.IsHexAddress(value) {
+			return fmt.Errorf("is not an address: expected 0x followed by 40 hex characters")
+		}
+	case kindPrivateKey:
+		if _, err := ParsePrivateKey(value); err != nil {
+			return fmt.Errorf("is not an EVM private key: expected 64 hex characters, with or without 0x")
+		}
+	case kindSolanaPrivateKey:
+		if _, err := ParseSolanaPrivateKey(value); err != nil {
+			return fmt.Errorf("is not a Solana private key: %v", err)
+		}
+	}
+	return nil
+}
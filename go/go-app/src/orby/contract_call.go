@@ -0,0 +1,504 @@
+// contract_call.go builds calldata for arbitrary contract calls executed through Orby
+package orby
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// ContractCall describes a contract method call to execute through orby_getOperationsToExecuteTransaction
+type ContractCall struct {
+	To     string
+	ABI    abi.ABI
+	Method string
+	Args   []any
+	Value  Amount
+}
+
+// NewContractCall creates a ContractCall for the given method, converting JSON or string arguments
+// to the Go types expected by the method's inputs
+func NewContractCall(to string, contractAbi abi.ABI, method string, args []any, value Amount) (*ContractCall, error) {
+	abiMethod, ok := contractAbi.Methods[method]
+	if !ok {
+		return nil, fmt.Errorf("method %s not found in ABI", method)
+	}
+
+	typedArgs, err := ConvertArguments(abiMethod, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ContractCall{
+		To:     to,
+		ABI:    contractAbi,
+		Method: method,
+		Args:   typedArgs,
+		Value:  value,
+	}, nil
+}
+
+// Pack encodes the call as hex calldata
+func (c *ContractCall) Pack() (string, error) {
+	data, err := c.ABI.Pack(c.Method, c.Args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s call data: %v", c.Method, err)
+	}
+	return hexutil.Encode(data), nil
+}
+
+// ParseABIJSON parses a JSON ABI
+func ParseABIJSON(data []byte) (abi.ABI, error) {
+	parsed, err := abi.JSON(bytes.NewReader(data))
+	if err != nil {
+		return abi.ABI{}, fmt.Errorf("failed to parse ABI: %v", err)
+	}
+	return parsed, nil
+}
+
+// LoadABIFromFile reads and parses a JSON ABI file
+func LoadABIFromFile(path string) (abi.ABI, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return abi.ABI{}, fmt.Errorf("failed to read ABI file %s: %v", path, err)
+	}
+	return ParseABIJSON(data)
+}
+
+// LoadABIFromFS reads and parses a JSON ABI from a file system, such as an embed.FS
+func LoadABIFromFS(fsys fs.FS, path string) (abi.ABI, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return abi.ABI{}, fmt.Errorf("failed to read ABI %s: %v", path, err)
+	}
+	return ParseABIJSON(data)
+}
+
+// ParseMethodSignature parses a human-readable method signature such as "approve(address,uint256)" or
+// "function balanceOf(address owner) view returns (uint256)" into an ABI containing that method.
+// It returns the ABI and the method name.
+func ParseMethodSignature(signature string) (abi.ABI, string, error) {
+	signature = strings.TrimSpace(signature)
+	signature = strings.TrimPrefix(signature, "function ")
+	signature = strings.TrimSpace(signature)
+
+	open := strings.Index(signature, "(")
+	if open <= 0 {
+		return abi.ABI{}, "", fmt.Errorf("invalid method signature: %s", signature)
+	}
+	name := strings.TrimSpace(signature[:open])
+
+	closeIndex, err := matchingParen(signature, open)
+	if err != nil {
+		return abi.ABI{}, "", fmt.Errorf("invalid method signature %s: %v", signature, err)
+	}
+
+	inputs, err := parseSignatureParams(signature[open+1 : closeIndex])
+	if err != nil {
+		return abi.ABI{}, "", fmt.Errorf("invalid method signature %s: %v", signature, err)
+	}
+
+	// Parse modifiers and return values following the inputs
+	stateMutability := "nonpayable"
+	outputs := []abi.ArgumentMarshaling{}
+	rest := strings.TrimSpace(signature[closeIndex+1:])
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "returns"):
+			rest = strings.TrimSpace(strings.TrimPrefix(rest, "returns"))
+			if !strings.HasPrefix(rest, "(") {
+				return abi.ABI{}, "", fmt.Errorf("invalid returns clause in method signature %s", signature)
+			}
+			returnsClose, err := matchingParen(rest, 0)
+			if err != nil {
+				return abi.ABI{}, "", fmt.Errorf("invalid method signature %s: %v", signature, err)
+			}
+			outputs, err = parseSignatureParams(rest[1:returnsClose])
+			if err != nil {
+				return abi.ABI{}, "", fmt.Errorf("invalid method signature %s: %v", signature, err)
+			}
+			rest = strings.TrimSpace(rest[returnsClose+1:])
+		default:
+			word, remaining, _ := strings.Cut(rest, " ")
+			switch word {
+			case "view", "pure", "payable", "nonpayable":
+				stateMutability = word
+			case "external", "public":
+			default:
+				return abi.ABI{}, "", fmt.Errorf("unexpected %q in method signature %s", word, signature)
+			}
+			rest = strings.TrimSpace(remaining)
+		}
+	}
+
+	abiJson, err := json.Marshal([]map[string]any{
+		{
+			"type":            "function",
+			"name":            name,
+			"inputs":          inputs,
+			"outputs":         outputs,
+			"stateMutability": stateMutability,
+		},
+	})
+	if err != nil {
+		return abi.ABI{}, "", err
+	}
+
+	parsed, err := ParseABIJSON(abiJson)
+	if err != nil {
+		return abi.ABI{}, "", err
+	}
+	return parsed, name, nil
+}
+
+// parseSignatureParams parses a comma separated parameter list such as "address to, (uint256,bool)[] items"
+func parseSignatureParams(params string) ([]abi.ArgumentMarshaling, error) {
+	arguments := []abi.ArgumentMarshaling{}
+	if strings.TrimSpace(params) == "" {
+		return arguments, nil
+	}
+
+	parts, err := splitTopLevel(params)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("empty parameter at position %d", i)
+		}
+
+		var argument abi.ArgumentMarshaling
+		var rest string
+
+		if strings.HasPrefix(part, "(") || strings.HasPrefix(part, "tuple(") {
+			// Tuple parameter, e.g. "(address,uint256)[] calls"
+			part = strings.TrimPrefix(part, "tuple")
+			closeIndex, err := matchingParen(part, 0)
+			if err != nil {
+				return nil, err
+			}
+			components, err := parseSignatureParams(part[1:closeIndex])
+			if err != nil {
+				return nil, err
+			}
+			// Tuple components need names to be mapped onto Go struct fields
+			for j := range components {
+				if components[j].Name == "" {
+					components[j].Name = fmt.Sprintf("field%d", j)
+				}
+			}
+			suffix, remaining, _ := strings.Cut(part[closeIndex+1:], " ")
+			argument = abi.ArgumentMarshaling{Type: "tuple" + suffix, Components: components}
+			rest = remaining
+		} else {
+			typeName, remaining, _ := strings.Cut(part, " ")
+			argument = abi.ArgumentMarshaling{Type: normalizeSignatureType(typeName)}
+			rest = remaining
+		}
+
+		// Remaining words are data locations, "indexed" and the parameter name
+		for _, word := range strings.Fields(rest) {
+			switch word {
+			case "memory", "calldata", "storage", "indexed":
+			default:
+				argument.Name = word
+			}
+		}
+
+		arguments = append(arguments, argument)
+	}
+
+	return arguments, nil
+}
+
+// normalizeSignatureType expands the uint/int aliases used in human-readable signatures
+func normalizeSignatureType(typeName string) string {
+	base, suffix := typeName, ""
+	if i := strings.Index(typeName, "["); i >= 0 {
+		base, suffix = typeName[:i], typeName[i:]
+	}
+	switch base {
+	case "uint":
+		base = "uint256"
+	case "int":
+		base = "int256"
+	}
+	return base + suffix
+}
+
+// splitTopLevel splits a string on commas that are not nested in parentheses
+func splitTopLevel(value string) ([]string, error) {
+	var parts []string
+	depth, start := 0, 0
+	for i, char := range value {
+		switch char {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in %q", value)
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in %q", value)
+	}
+	return append(parts, value[start:]), nil
+}
+
+// matchingParen returns the index of the parenthesis closing the one at open
+func matchingParen(value string, open int) (int, error) {
+	depth := 0
+	for i := open; i < len(value); i++ {
+		switch value[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unbalanced parentheses in %q", value)
+}
+
+// ParseJSONArguments decodes a JSON array of arguments, keeping numbers exact
+func ParseJSONArguments(data string) ([]any, error) {
+	if strings.TrimSpace(data) == "" {
+		return []any{}, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+
+	var args []any
+	if err := decoder.Decode(&args); err != nil {
+		return nil, fmt.Errorf("arguments must be a JSON array: %v", err)
+	}
+	return args, nil
+}
+
+// ConvertArguments converts CLI strings or decoded JSON values into the Go types go-ethereum expects
+// for the method's inputs. Arrays and tuples may be given as JSON values or JSON-encoded strings.
+func ConvertArguments(method abi.Method, args []any) ([]any, error) {
+	if len(args) != len(method.Inputs) {
+		return nil, fmt.Errorf("method %s expects %d arguments, got %d", method.Sig, len(method.Inputs), len(args))
+	}
+
+	converted := make([]any, len(args))
+	for i, input := range method.Inputs {
+		value, err := convertArgument(input.Type, args[i])
+		if err != nil {
+			name := input.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i)
+			}
+			return nil, fmt.Errorf("invalid argument %s (%s): %v", name, input.Type.String(), err)
+		}
+		converted[i] = value
+	}
+	return converted, nil
+}
+
+func convertArgument(t abi.Type, value any) (any, error) {
+	converted, err := convertArgumentValue(t, value)
+	if err != nil {
+		return nil, err
+	}
+	return converted.Interface(), nil
+}
+
+func convertArgumentValue(t abi.Type, value any) (reflect.Value, error) {
+	goType := t.GetType()
+
+	// Arrays and tuples may be passed as JSON-encoded strings from the command line
+	if text, ok := value.(string); ok && (t.T == abi.SliceTy || t.T == abi.ArrayTy || t.T == abi.TupleTy) {
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return reflect.Value{}, fmt.Errorf("expected JSON value: %v", err)
+		}
+	}
+
+	switch t.T {
+	case abi.AddressTy:
+		text, ok := value.(string)
+		if !ok || !common.IsHexAddress(text) {
+			return reflect.Value{}, fmt.Errorf("expected address, got %v", value)
+		}
+		return reflect.ValueOf(common.HexToAddress(text)), nil
+
+	case abi.BoolTy:
+		switch v := value.(type) {
+		case bool:
+			return reflect.ValueOf(v), nil
+		case string:
+			switch strings.ToLower(v) {
+			case "true":
+				return reflect.ValueOf(true), nil
+			case "false":
+				return reflect.ValueOf(false), nil
+			}
+		}
+		return reflect.Value{}, fmt.Errorf("expected bool, got %v", value)
+
+	case abi.StringTy:
+		text, ok := value.(string)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("expected string, got %v", value)
+		}
+		return reflect.ValueOf(text), nil
+
+	case abi.UintTy, abi.IntTy:
+		number, err := parseBigIntArgument(value)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if t.T == abi.UintTy && number.Sign() < 0 {
+			return reflect.Value{}, fmt.Errorf("expected unsigned integer, got %s", number)
+		}
+		if !fitsInIntegerType(number, t) {
+			return reflect.Value{}, fmt.Errorf("%s does not fit in %s", number, t.String())
+		}
+		if goType == reflect.TypeOf(&big.Int{}) {
+			return reflect.ValueOf(number), nil
+		}
+		result := reflect.New(goType).Elem()
+		if t.T == abi.UintTy {
+			result.SetUint(number.Uint64())
+		} else {
+			result.SetInt(number.Int64())
+		}
+		return result, nil
+
+	case abi.BytesTy, abi.FixedBytesTy:
+		text, ok := value.(string)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("expected hex string, got %v", value)
+		}
+		data, err := hexutil.Decode(text)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("expected hex string: %v", err)
+		}
+		if t.T == abi.BytesTy {
+			return reflect.ValueOf(data), nil
+		}
+		if len(data) != t.Size {
+			return reflect.Value{}, fmt.Errorf("expected %d bytes, got %d", t.Size, len(data))
+		}
+		result := reflect.New(goType).Elem()
+		reflect.Copy(result, reflect.ValueOf(data))
+		return result, nil
+
+	case abi.SliceTy, abi.ArrayTy:
+		items, ok := value.([]any)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("expected array, got %v", value)
+		}
+		if t.T == abi.ArrayTy && len(items) != t.Size {
+			return reflect.Value{}, fmt.Errorf("expected %d items, got %d", t.Size, len(items))
+		}
+
+		var result reflect.Value
+		if t.T == abi.SliceTy {
+			result = reflect.MakeSlice(goType, len(items), len(items))
+		} else {
+			result = reflect.New(goType).Elem()
+		}
+		for i, item := range items {
+			element, err := convertArgumentValue(*t.Elem, item)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("item %d: %v", i, err)
+			}
+			result.Index(i).Set(element)
+		}
+		return result, nil
+
+	case abi.TupleTy:
+		result := reflect.New(goType).Elem()
+		for i, elem := range t.TupleElems {
+			var item any
+			switch v := value.(type) {
+			case []any:
+				if len(v) != len(t.TupleElems) {
+					return reflect.Value{}, fmt.Errorf("expected %d tuple fields, got %d", len(t.TupleElems), len(v))
+				}
+				item = v[i]
+			case map[string]any:
+				field, ok := v[t.TupleRawNames[i]]
+				if !ok {
+					return reflect.Value{}, fmt.Errorf("missing tuple field %s", t.TupleRawNames[i])
+				}
+				item = field
+			default:
+				return reflect.Value{}, fmt.Errorf("expected tuple as JSON array or object, got %v", value)
+			}
+
+			field, err := convertArgumentValue(*elem, item)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("field %s: %v", t.TupleRawNames[i], err)
+			}
+			result.Field(i).Set(field)
+		}
+		return result, nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("unsupported argument type %s", t.String())
+}
+
+// fitsInIntegerType returns true if number is within the range of the uintN or intN type
+func fitsInIntegerType(number *big.Int, t abi.Type) bool {
+	if t.T == abi.UintTy {
+		return number.BitLen() <= t.Size
+	}
+	if number.Sign() < 0 {
+		// -2^(N-1) is the smallest intN value
+		magnitude := new(big.Int).Neg(number)
+		return magnitude.Sub(magnitude, big.NewInt(1)).BitLen() <= t.Size-1
+	}
+	return number.BitLen() <= t.Size-1
+}
+
+func parseBigIntArgument(value any) (*big.Int, error) {
+	var text string
+	switch v := value.(type) {
+	case json.Number:
+		text = v.String()
+	case string:
+		text = strings.TrimSpace(v)
+	case float64:
+		text = big.NewFloat(v).Text('f', 0)
+	default:
+		return nil, fmt.Errorf("expected integer, got %v", value)
+	}
+
+	number := new(big.Int)
+	var ok bool
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		number, ok = number.SetString(text[2:], 16)
+	} else {
+		number, ok = number.SetString(text, 10)
+	}
+	if !ok {
+		return nil, fmt.Errorf("expected integer, got %s", text)
+	}
+	return number, nil
+}
@@ -0,0 +1,283 @@
+// eip7702.go signs EIP-7702 authorizations and the set-code transactions that carry them
+package orby
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+// AuthorizationFormat is the operation format of a standalone EIP-7702 authorization
+const AuthorizationFormat = "AUTHORIZATION"
+
+// AuthorizationRequest is an EIP-7702 authorization tuple, signed or not. Unsigned tuples are signed
+// with the private key; tuples that already carry a signature are passed through.
+type AuthorizationRequest struct {
+	// ChainId is the chain the delegation is valid on, 0 for every chain. Defaults to the operation's chain.
+	ChainId *hexutil.Big `json:"chainId,omitempty"`
+	// Address is the contract whose code the EOA delegates to
+	Address common.Address `json:"address"`
+	// Nonce is the EOA's nonce when the authorization is processed. It may only be omitted when the EOA
+	// also sends the transaction, and then is the nonce the sender has after the transaction and its
+	// earlier authorizations incremented it. Authorizations signed by another EOA must give its nonce.
+	Nonce   *hexutil.Uint64 `json:"nonce,omitempty"`
+	YParity *hexutil.Uint64 `json:"yParity,omitempty"`
+	R       *hexutil.Big    `json:"r,omitempty"`
+	S       *hexutil.Big    `json:"s,omitempty"`
+}
+
+// SetCodeTransactionRequest is a TRANSACTION operation's JSON data when it requests delegation
+type SetCodeTransactionRequest struct {
+	To                   *common.Address        `json:"to,omitempty"`
+	Nonce                *hexutil.Uint64        `json:"nonce,omitempty"`
+	GasLimit             *hexutil.Uint64        `json:"gasLimit,omitempty"`
+	Value                *hexutil.Big           `json:"value,omitempty"`
+	Data                 hexutil.Bytes          `json:"data,omitempty"`
+	MaxFeePerGas         *hexutil.Big           `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big           `json:"maxPriorityFeePerGas"`
+	AccessList           types.AccessList       `json:"accessList,omitempty"`
+	AuthorizationList    []AuthorizationRequest `json:"authorizationList"`
+}
+
+// SignAuthorization signs an EIP-7702 authorization delegating the key's EOA to address.
+// A chainId of 0 makes the authorization valid on every chain.
+func SignAuthorization(chainId *big.Int, address common.Address, nonce uint64, privateKey *ecdsa.PrivateKey) (types.SetCodeAuthorization, error) {
+	chainId256, overflow := uint256.FromBig(chainId)
+	if overflow || chainId.Sign() < 0 {
+		return types.SetCodeAuthorization{}, fmt.Errorf("invalid authorization chain ID: %s", chainId.String())
+	}
+
+	authorization, err := types.SignSetCode(privateKey, types.SetCodeAuthorization{
+		ChainID: *chainId256,
+		Address: address,
+		Nonce:   nonce,
+	})
+	if err != nil {
+		return types.SetCodeAuthorization{}, fmt.Errorf("failed to sign authorization: %v", err)
+	}
+
+	// Verify the signature by recovering the authority
+	authority, err := authorization.Authority()
+	if err != nil {
+		return types.SetCodeAuthorization{}, fmt.Errorf("failed to recover authorization signer: %v", err)
+	}
+	if expected := crypto.PubkeyToAddress(privateKey.PublicKey); authority != expected {
+		return types.SetCodeAuthorization{}, fmt.Errorf("authorization signer %s does not match %s", authority.Hex(), expected.Hex())
+	}
+
+	return authorization, nil
+}
+
+// AuthorizationSignature returns an authorization's signature as r || s || yParity
+func AuthorizationSignature(authorization types.SetCodeAuthorization) []byte {
+	signature := make([]byte, 65)
+	authorization.R.WriteToSlice(signature[:32])
+	authorization.S.WriteToSlice(signature[32:64])
+	signature[64] = authorization.V
+	return signature
+}
+
+// SignAuthorizationOperation signs an AUTHORIZATION operation whose data is an AuthorizationRequest,
+// returning the signature as r || s || yParity
+func SignAuthorizationOperation(operation Operation) (string, error) {
+	privateKey, err := OperationPrivateKey(operation)
+	if err != nil {
+		return "", err
+	}
+
+	var request AuthorizationRequest
+	if err := json.Unmarshal([]byte(operation.Data), &request); err != nil {
+		return "", fmt.Errorf("failed to parse authorization JSON: %v", err)
+	}
+	if request.Nonce == nil {
+		return "", fmt.Errorf("authorization is missing its nonce")
+	}
+
+	chainId, err := authorizationChainId(request, operation)
+	if err != nil {
+		return "", err
+	}
+
+	authorization, err := SignAuthorization(chainId, request.Address, uint64(*request.Nonce), privateKey)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Signed authorization: chainId=%s, address=%s, nonce=%d\n",
+		chainId.String(), request.Address.Hex(), authorization.Nonce)
+
+	return hexutil.Encode(AuthorizationSignature(authorization)), nil
+}
+
+// SignSetCodeTransaction builds and signs an EIP-7702 set-code transaction for a TRANSACTION
+// operation whose JSON data has an authorizationList
+func SignSetCodeTransaction(operation Operation) (string, error) {
+	privateKey, err := OperationPrivateKey(operation)
+	if err != nil {
+		return "", err
+	}
+	sender := crypto.PubkeyToAddress(privateKey.PublicKey)
+
+	// 1. Parse the transaction
+	var request SetCodeTransactionRequest
+	if err := json.Unmarshal([]byte(operation.Data), &request); err != nil {
+		return "", fmt.Errorf("failed to parse set-code transaction JSON: %v", err)
+	}
+	if len(request.AuthorizationList) == 0 {
+		return "", fmt.Errorf("set-code transaction has an empty authorizationList")
+	}
+	if request.MaxFeePerGas == nil || request.MaxPriorityFeePerGas == nil {
+		return "", fmt.Errorf("set-code transaction requires maxFeePerGas and maxPriorityFeePerGas")
+	}
+
+	chainId, err := operation.ChainId.BigInt()
+	if err != nil {
+		return "", err
+	}
+
+	// Set-code transactions cannot create contracts, so they call the operation's target,
+	// or the EOA itself (e.g. to initialize the delegated code)
+	to := sender
+	if request.To != nil {
+		to = *request.To
+	} else if common.IsHexAddress(operation.To) {
+		to = common.HexToAddress(operation.To)
+	}
+
+	var nonce uint64
+	if request.Nonce != nil {
+		nonce = uint64(*request.Nonce)
+	}
+	gasLimit := uint64(300_000) // default gas limit
+	if request.GasLimit != nil {
+		gasLimit = uint64(*request.GasLimit)
+	}
+
+	// 2. Sign the authorizations that are not signed yet. The sender's nonce is incremented by the
+	// transaction, and then by each of its authorizations as it is processed.
+	authorizations := make([]types.SetCodeAuthorization, len(request.AuthorizationList))
+	senderNonce := nonce + 1
+	for i, authRequest := range request.AuthorizationList {
+		authorization, err := buildAuthorization(authRequest, operation, senderNonce, privateKey)
+		if err != nil {
+			return "", fmt.Errorf("authorization %d: %v", i+1, err)
+		}
+		if authority, err := authorization.Authority(); err == nil && authority == sender && authorization.Nonce == senderNonce {
+			senderNonce++
+		}
+		authorizations[i] = authorization
+		fmt.Printf("Authorization %d: delegate to %s on chain %s with nonce %d\n",
+			i+1, authorization.Address.Hex(), authorization.ChainID.String(), authorization.Nonce)
+	}
+
+	// 3. Build and sign the transaction
+	values := make([]*uint256.Int, 4)
+	for i, value := range []*hexutil.Big{(*hexutil.Big)(chainId), request.MaxFeePerGas, request.MaxPriorityFeePerGas, request.Value} {
+		if value == nil {
+			values[i] = new(uint256.Int)
+			continue
+		}
+		var overflow bool
+		values[i], overflow = uint256.FromBig(value.ToInt())
+		if overflow || value.ToInt().Sign() < 0 {
+			return "", fmt.Errorf("value %s does not fit in uint256", value.ToInt().String())
+		}
+	}
+
+	tx := types.NewTx(&types.SetCodeTx{
+		ChainID:    values[0],
+		Nonce:      nonce,
+		GasFeeCap:  values[1],
+		GasTipCap:  values[2],
+		Gas:        gasLimit,
+		To:         to,
+		Value:      values[3],
+		Data:       request.Data,
+		AccessList: request.AccessList,
+		AuthList:   authorizations,
+	})
+
+	fmt.Printf("Set-code transaction: to=%s, nonce=%d, gasLimit=%d, authorizations=%d\n",
+		to.Hex(), nonce, gasLimit, len(authorizations))
+
+	signer := types.NewPragueSigner(chainId)
+	signedTx, err := types.SignTx(tx, signer, privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign set-code transaction: %v", err)
+	}
+
+	// Verify the signature by recovering the sender address
+	recovered, err := signer.Sender(signedTx)
+	if err != nil {
+		return "", fmt.Errorf("failed to recover sender from signed transaction: %v", err)
+	}
+	if recovered != sender {
+		return "", fmt.Errorf("transaction signature verification failed: recovered sender %s does not match original address %s",
+			recovered.Hex(), sender.Hex())
+	}
+
+	signedTxBytes, err := signedTx.MarshalBinary()
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal signed transaction: %v", err)
+	}
+
+	return hexutil.Encode(signedTxBytes), nil
+}
+
+// buildAuthorization signs an authorization request with the sender's key, or converts it if it is
+// already signed. senderNonce is the sender's nonce when the authorization is processed, used for
+// authorizations without a nonce, which must then be the sender's own.
+func buildAuthorization(request AuthorizationRequest, operation Operation, senderNonce uint64, privateKey *ecdsa.PrivateKey) (types.SetCodeAuthorization, error) {
+	chainId, err := authorizationChainId(request, operation)
+	if err != nil {
+		return types.SetCodeAuthorization{}, err
+	}
+
+	nonce := senderNonce
+	if request.Nonce != nil {
+		nonce = uint64(*request.Nonce)
+	}
+
+	if request.R == nil || request.S == nil || request.YParity == nil {
+		return SignAuthorization(chainId, request.Address, nonce, privateKey)
+	}
+
+	authorization := types.SetCodeAuthorization{
+		Address: request.Address,
+		Nonce:   nonce,
+		V:       uint8(*request.YParity),
+	}
+	authorization.ChainID.SetFromBig(chainId)
+	authorization.R.SetFromBig(request.R.ToInt())
+	authorization.S.SetFromBig(request.S.ToInt())
+
+	authority, err := authorization.Authority()
+	if err != nil {
+		return types.SetCodeAuthorization{}, fmt.Errorf("invalid authorization signature: %v", err)
+	}
+
+	// The signature covers the nonce, so without one it only recovers to the sender if it was signed by
+	// the sender with the sender's nonce. Anything else is another EOA's authorization, whose nonce the
+	// sender's nonce says nothing about.
+	if request.Nonce == nil {
+		if sender := crypto.PubkeyToAddress(privateKey.PublicKey); authority != sender {
+			return types.SetCodeAuthorization{}, fmt.Errorf(
+				"signed authorization has no nonce and was not signed by the sender %s with nonce %d; give the nonce of the EOA that signed it",
+				sender.Hex(), nonce)
+		}
+	}
+	return authorization, nil
+}
+
+func authorizationChainId(request AuthorizationRequest, operation Operation) (*big.Int, error) {
+	if request.ChainId != nil {
+		return request.ChainId.ToInt(), nil
+	}
+	return operation.ChainId.BigInt()
+}
@@ -0,0 +1,410 @@
+// erc20_permit.go builds EIP-2612 and DAI-style permit typed data for tokens with a native permit
+package orby
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// Names of the embedded token permit ABIs
+const (
+	ABINameERC20Permit = "erc20_permit"
+	ABINameDAI         = "dai"
+)
+
+// PermitKind selects which permit a token implements
+type PermitKind string
+
+const (
+	// PermitKindERC2612 is the standard Permit(owner, spender, value, nonce, deadline)
+	PermitKindERC2612 PermitKind = "erc2612"
+	// PermitKindDAI is DAI's legacy Permit(holder, spender, nonce, expiry, allowed)
+	PermitKindDAI PermitKind = "dai"
+)
+
+var (
+	erc2612PermitType = []apitypes.Type{
+		{Name: "owner", Type: "address"},
+		{Name: "spender", Type: "address"},
+		{Name: "value", Type: "uint256"},
+		{Name: "nonce", Type: "uint256"},
+		{Name: "deadline", Type: "uint256"},
+	}
+	daiPermitType = []apitypes.Type{
+		{Name: "holder", Type: "address"},
+		{Name: "spender", Type: "address"},
+		{Name: "nonce", Type: "uint256"},
+		{Name: "expiry", Type: "uint256"},
+		{Name: "allowed", Type: "bool"},
+	}
+)
+
+// TokenPermitDomain is a token's EIP-712 domain together with the EIP712Domain fields it uses.
+// Tokens differ in which fields they include (most omit salt, some omit version), and the
+// domain separator only matches the on-chain value if the same fields are hashed.
+type TokenPermitDomain struct {
+	Domain apitypes.TypedDataDomain
+	Fields []apitypes.Type
+}
+
+// NewTokenPermitDomain returns the common name/version/chainId/verifyingContract domain of a token.
+// The version field is left out if version is empty.
+func NewTokenPermitDomain(name string, version string, chainId *big.Int, token string) TokenPermitDomain {
+	domain := TokenPermitDomain{
+		Domain: apitypes.TypedDataDomain{
+			Name:              name,
+			Version:           version,
+			ChainId:           (*math.HexOrDecimal256)(new(big.Int).Set(chainId)),
+			VerifyingContract: common.HexToAddress(token).Hex(),
+		},
+		Fields: []apitypes.Type{{Name: "name", Type: "string"}},
+	}
+	if version != "" {
+		domain.Fields = append(domain.Fields, apitypes.Type{Name: "version", Type: "string"})
+	}
+	domain.Fields = append(domain.Fields,
+		apitypes.Type{Name: "chainId", Type: "uint256"},
+		apitypes.Type{Name: "verifyingContract", Type: "address"})
+	return domain
+}
+
+// Separator returns the domain separator, i.e. hashStruct(EIP712Domain)
+func (d TokenPermitDomain) Separator() (common.Hash, error) {
+	typedData := apitypes.TypedData{Types: apitypes.Types{"EIP712Domain": d.Fields}, Domain: d.Domain}
+	separator, err := typedData.HashStruct("EIP712Domain", d.Domain.Map())
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to create domain separator: %v", err)
+	}
+	return common.BytesToHash(separator), nil
+}
+
+// ERC2612PermitParams are the inputs of an EIP-2612 permit
+type ERC2612PermitParams struct {
+	Domain   TokenPermitDomain
+	Owner    string
+	Spender  string
+	Value    *big.Int
+	Nonce    *big.Int
+	Deadline *big.Int
+}
+
+// DAIPermitParams are the inputs of a DAI-style permit, which approves the maximum amount
+// (or revokes the allowance if Allowed is false)
+type DAIPermitParams struct {
+	Domain  TokenPermitDomain
+	Holder  string
+	Spender string
+	Nonce   *big.Int
+	// Expiry is the unix timestamp the permit expires at, or 0 for no expiry
+	Expiry  *big.Int
+	Allowed bool
+}
+
+// BuildERC2612Permit builds typed data for an EIP-2612 permit
+func BuildERC2612Permit(params ERC2612PermitParams) (*apitypes.TypedData, error) {
+	if !common.IsHexAddress(params.Owner) {
+		return nil, fmt.Errorf("invalid owner address: %q", params.Owner)
+	}
+	if !common.IsHexAddress(params.Spender) {
+		return nil, fmt.Errorf("invalid spender address: %q", params.Spender)
+	}
+	if params.Value == nil || params.Value.Sign() < 0 {
+		return nil, fmt.Errorf("value is required")
+	}
+	if params.Nonce == nil || params.Nonce.Sign() < 0 {
+		return nil, fmt.Errorf("nonce is required")
+	}
+	if params.Deadline == nil || params.Deadline.Sign() <= 0 {
+		return nil, fmt.Errorf("deadline is required")
+	}
+
+	return buildTokenPermitTypedData(params.Domain, erc2612PermitType, apitypes.TypedDataMessage{
+		"owner":    common.HexToAddress(params.Owner).Hex(),
+		"spender":  common.HexToAddress(params.Spender).Hex(),
+		"value":    params.Value.String(),
+		"nonce":    params.Nonce.String(),
+		"deadline": params.Deadline.String(),
+	})
+}
+
+// BuildDAIPermit builds typed data for a DAI-style permit
+func BuildDAIPermit(params DAIPermitParams) (*apitypes.TypedData, error) {
+	if !common.IsHexAddress(params.Holder) {
+		return nil, fmt.Errorf("invalid holder address: %q", params.Holder)
+	}
+	if !common.IsHexAddress(params.Spender) {
+		return nil, fmt.Errorf("invalid spender address: %q", params.Spender)
+	}
+	if params.Nonce == nil || params.Nonce.Sign() < 0 {
+		return nil, fmt.Errorf("nonce is required")
+	}
+	expiry := params.Expiry
+	if expiry == nil {
+		expiry = new(big.Int)
+	}
+
+	return buildTokenPermitTypedData(params.Domain, daiPermitType, apitypes.TypedDataMessage{
+		"holder":  common.HexToAddress(params.Holder).Hex(),
+		"spender": common.HexToAddress(params.Spender).Hex(),
+		"nonce":   params.Nonce.String(),
+		"expiry":  expiry.String(),
+		"allowed": params.Allowed,
+	})
+}
+
+// VerifyDomainSeparator checks that the typed data's domain hashes to the expected separator,
+// e.g. the value returned by the token's DOMAIN_SEPARATOR()
+func VerifyDomainSeparator(typedData *apitypes.TypedData, expected common.Hash) error {
+	separator, err := typedData.HashStruct("EIP712Domain", typedData.Domain.Map())
+	if err != nil {
+		return fmt.Errorf("failed to create domain separator: %v", err)
+	}
+	if common.BytesToHash(separator) != expected {
+		return fmt.Errorf("domain separator mismatch: computed %s, expected %s", common.BytesToHash(separator).Hex(), expected.Hex())
+	}
+	return nil
+}
+
+// PermitTokenReader reads the permit domain and nonces of a token with eth_call
+type PermitTokenReader struct {
+	Client *OrbyClient
+	RpcUrl string
+}
+
+// NewPermitTokenReader creates a reader that calls tokens through the given RPC URL
+func NewPermitTokenReader(client *OrbyClient, rpcUrl string) *PermitTokenReader {
+	return &PermitTokenReader{Client: client, RpcUrl: rpcUrl}
+}
+
+// DomainSeparator calls DOMAIN_SEPARATOR() on the token
+func (r *PermitTokenReader) DomainSeparator(token string) (common.Hash, error) {
+	values, err := r.call(token, "DOMAIN_SEPARATOR")
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.Hash(values[0].([32]byte)), nil
+}
+
+// Nonce calls nonces(owner) on the token. EIP-2612 and DAI tokens share the same selector.
+func (r *PermitTokenReader) Nonce(token string, owner string) (*big.Int, error) {
+	values, err := r.call(token, "nonces", common.HexToAddress(owner))
+	if err != nil {
+		return nil, err
+	}
+	return values[0].(*big.Int), nil
+}
+
+// Domain reads the token's EIP-712 domain and checks it against DOMAIN_SEPARATOR().
+// The domain is taken from eip712Domain() (EIP-5267) if the token implements it. Otherwise it is
+// rebuilt from name() and version(), trying version "1" and a domain without version for tokens
+// that have no version() method, and the candidate matching the on-chain separator is returned.
+func (r *PermitTokenReader) Domain(token string, chainId *big.Int) (*TokenPermitDomain, error) {
+	// 1. Read the on-chain domain separator
+	expected, err := r.DomainSeparator(token)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Collect candidate domains
+	var candidates []TokenPermitDomain
+	if domain, err := r.eip5267Domain(token); err == nil {
+		candidates = append(candidates, *domain)
+	}
+
+	if len(candidates) == 0 {
+		values, err := r.call(token, "name")
+		if err != nil {
+			return nil, err
+		}
+		name := values[0].(string)
+
+		if values, err := r.call(token, "version"); err == nil {
+			candidates = append(candidates, NewTokenPermitDomain(name, values[0].(string), chainId, token))
+		} else {
+			candidates = append(candidates,
+				NewTokenPermitDomain(name, "1", chainId, token),
+				NewTokenPermitDomain(name, "", chainId, token))
+		}
+	}
+
+	// 3. Return the candidate whose separator matches the chain
+	var computed []string
+	for _, candidate := range candidates {
+		separator, err := candidate.Separator()
+		if err != nil {
+			return nil, err
+		}
+		if separator == expected {
+			return &candidate, nil
+		}
+		computed = append(computed, separator.Hex())
+	}
+
+	return nil, fmt.Errorf("domain separator of %s does not match the token's name and version: on-chain %s, computed %v",
+		token, expected.Hex(), computed)
+}
+
+// BuildERC2612Permit reads the token's domain and the owner's nonce and builds an EIP-2612 permit
+func (r *PermitTokenReader) BuildERC2612Permit(
+	chainId *big.Int,
+	token string,
+	owner string,
+	spender string,
+	value *big.Int,
+	deadline *big.Int) (*apitypes.TypedData, error) {
+	domain, err := r.Domain(token, chainId)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := r.Nonce(token, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	return BuildERC2612Permit(ERC2612PermitParams{
+		Domain:   *domain,
+		Owner:    owner,
+		Spender:  spender,
+		Value:    value,
+		Nonce:    nonce,
+		Deadline: deadline,
+	})
+}
+
+// BuildDAIPermit reads the token's domain and the holder's nonce and builds a DAI-style permit
+func (r *PermitTokenReader) BuildDAIPermit(
+	chainId *big.Int,
+	token string,
+	holder string,
+	spender string,
+	expiry *big.Int,
+	allowed bool) (*apitypes.TypedData, error) {
+	domain, err := r.Domain(token, chainId)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := r.Nonce(token, holder)
+	if err != nil {
+		return nil, err
+	}
+
+	return BuildDAIPermit(DAIPermitParams{
+		Domain:  *domain,
+		Holder:  holder,
+		Spender: spender,
+		Nonce:   nonce,
+		Expiry:  expiry,
+		Allowed: allowed,
+	})
+}
+
+// eip5267Domain reads the domain from eip712Domain(), keeping only the fields it flags as used
+func (r *PermitTokenReader) eip5267Domain(token string) (*TokenPermitDomain, error) {
+	values, err := r.call(token, "eip712Domain")
+	if err != nil {
+		return nil, err
+	}
+
+	flags := values[0].([1]byte)[0]
+	name := values[1].(string)
+	version := values[2].(string)
+	chainId := values[3].(*big.Int)
+	verifyingContract := values[4].(common.Address)
+	salt := values[5].([32]byte)
+
+	// Bits 0-4 flag name, version, chainId, verifyingContract and salt
+	domain := &TokenPermitDomain{}
+	if flags&0x01 != 0 {
+		domain.Domain.Name = name
+		domain.Fields = append(domain.Fields, apitypes.Type{Name: "name", Type: "string"})
+	}
+	if flags&0x02 != 0 {
+		domain.Domain.Version = version
+		domain.Fields = append(domain.Fields, apitypes.Type{Name: "version", Type: "string"})
+	}
+	if flags&0x04 != 0 {
+		domain.Domain.ChainId = (*math.HexOrDecimal256)(chainId)
+		domain.Fields = append(domain.Fields, apitypes.Type{Name: "chainId", Type: "uint256"})
+	}
+	if flags&0x08 != 0 {
+		domain.Domain.VerifyingContract = verifyingContract.Hex()
+		domain.Fields = append(domain.Fields, apitypes.Type{Name: "verifyingContract", Type: "address"})
+	}
+	if flags&0x10 != 0 {
+		domain.Domain.Salt = hexutil.Encode(salt[:])
+		domain.Fields = append(domain.Fields, apitypes.Type{Name: "salt", Type: "bytes32"})
+	}
+	if len(domain.Fields) == 0 {
+		return nil, fmt.Errorf("eip712Domain() of %s flags no fields", token)
+	}
+	return domain, nil
+}
+
+func (r *PermitTokenReader) call(token string, method string, args ...any) ([]any, error) {
+	registry, err := DefaultABIRegistry()
+	if err != nil {
+		return nil, err
+	}
+	permitAbi, err := registry.Get(ABINameERC20Permit)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := permitAbi.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.Client.EthCall(r.RpcUrl, token, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s() on %s: %v", method, token, err)
+	}
+	values, err := unpackOutputs(permitAbi, method, result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s() result from %s: %v", method, token, err)
+	}
+	return values, nil
+}
+
+func unpackOutputs(contractAbi abi.ABI, method string, result []byte) ([]any, error) {
+	values, err := contractAbi.Unpack(method, result)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("empty result")
+	}
+	return values, nil
+}
+
+func buildTokenPermitTypedData(
+	domain TokenPermitDomain,
+	permitType []apitypes.Type,
+	message apitypes.TypedDataMessage) (*apitypes.TypedData, error) {
+	if len(domain.Fields) == 0 {
+		return nil, fmt.Errorf("token domain is required")
+	}
+
+	typedData := &apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": domain.Fields,
+			"Permit":       permitType,
+		},
+		PrimaryType: "Permit",
+		Domain:      domain.Domain,
+		Message:     message,
+	}
+
+	// Make sure the domain and message can be hashed
+	if _, err := TypedDataHash(typedData); err != nil {
+		return nil, fmt.Errorf("invalid permit: %v", err)
+	}
+
+	return typedData, nil
+}
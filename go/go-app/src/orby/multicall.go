@@ -0,0 +1,229 @@
+// multicall.go bundles several contract calls into a single call to execute through Orby
+package orby
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Multicall3Address is the address Multicall3 is deployed at on every chain
+const Multicall3Address = "0xcA11bde05977b3631167028862bE2a173976CA11"
+
+// Names of the embedded batching ABIs
+const (
+	ABINameMulticall3   = "multicall3"
+	ABINameBatchAccount = "batch_account"
+)
+
+// BatchMode selects how several calls are bundled into one transaction
+type BatchMode string
+
+const (
+	// BatchModeMulticall3 sends the calls through Multicall3's aggregate3Value.
+	// The inner calls are made by Multicall3, so anything that depends on msg.sender would apply
+	// to Multicall3: only calls without value to multicall3SafeMethods are accepted.
+	BatchModeMulticall3 BatchMode = "multicall3"
+	// BatchModeAccount calls executeBatch on a smart account, which makes each call as the account.
+	// It is the default batch mode.
+	BatchModeAccount BatchMode = "account"
+)
+
+// multicall3SafeMethods are the state-changing methods whose effect does not depend on msg.sender,
+// because they are authorized by the owner's signature rather than by the caller. Every other method
+// may move or credit the caller's tokens (transfer, approve, WETH or ERC-4626 deposit, ...), which
+// would be Multicall3's.
+var multicall3SafeMethods = map[string]bool{
+	// EIP-2612 and DAI permits
+	"permit(address,address,uint256,uint256,uint8,bytes32,bytes32)":      true,
+	"permit(address,address,uint256,uint256,bool,uint8,bytes32,bytes32)": true,
+	// Permit2 AllowanceTransfer permits, single and batch
+	"permit(address,((address,uint160,uint48,uint48),address,uint256),bytes)":   true,
+	"permit(address,((address,uint160,uint48,uint48)[],address,uint256),bytes)": true,
+}
+
+// CheckMulticall3Safe returns an error unless the call can be made by Multicall3 on the account's
+// behalf: it must not send value, which Multicall3 would be credited with, and must be a view method
+// or one of multicall3SafeMethods
+func (c *ContractCall) CheckMulticall3Safe() error {
+	method, ok := c.ABI.Methods[c.Method]
+	if !ok {
+		return fmt.Errorf("method %s not found in ABI", c.Method)
+	}
+	if !c.Value.IsZero() {
+		return fmt.Errorf("%s sends value, which Multicall3 would be credited with", method.Sig)
+	}
+	if !method.IsConstant() && !multicall3SafeMethods[method.Sig] {
+		return fmt.Errorf("%s may depend on msg.sender, which would be Multicall3", method.Sig)
+	}
+	return nil
+}
+
+// ContractCallSpec describes a contract call in JSON, e.g. from a file or the command line
+type ContractCallSpec struct {
+	// To is the contract address
+	To string `json:"to"`
+	// ABI is a registered ABI name or a path to an ABI file. If empty, Method must be a signature.
+	ABI string `json:"abi,omitempty"`
+	// Method is a method name in ABI or a human-readable signature such as "approve(address,uint256)"
+	Method string `json:"method"`
+	// Args are the method arguments as JSON values or strings
+	Args []any `json:"args,omitempty"`
+	// Value is the native amount to send, in wei or in ETH with a decimal point (e.g. "0.1")
+	Value string `json:"value,omitempty"`
+}
+
+// Build resolves the spec's ABI and converts its arguments into a ContractCall
+func (s ContractCallSpec) Build(registry *ABIRegistry) (*ContractCall, error) {
+	// 1. Load the ABI and resolve the method
+	var contractAbi abi.ABI
+	method := s.Method
+	var err error
+	if s.ABI != "" {
+		contractAbi, err = registry.Resolve(s.ABI)
+	} else {
+		contractAbi, method, err = ParseMethodSignature(s.Method)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Parse the native value
+	var value Amount
+	if s.Value != "" {
+		value, _, err = ParseAmountInput(s.Value, 18)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value: %v", err)
+		}
+	}
+
+	// 3. Convert the arguments to the method's input types
+	args := s.Args
+	if args == nil {
+		args = []any{}
+	}
+	return NewContractCall(s.To, contractAbi, method, args, value)
+}
+
+// ParseContractCallSpecs parses a JSON array of call specs, or reads it from a file if input is a path
+func ParseContractCallSpecs(input string) ([]ContractCallSpec, error) {
+	data := []byte(input)
+	if !strings.HasPrefix(strings.TrimSpace(input), "[") {
+		fileData, err := os.ReadFile(input)
+		if err != nil {
+			return nil, fmt.Errorf("calls must be a JSON array or a path to a JSON file: %v", err)
+		}
+		data = fileData
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+
+	var specs []ContractCallSpec
+	if err := decoder.Decode(&specs); err != nil {
+		return nil, fmt.Errorf("failed to parse calls: %v", err)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no calls given")
+	}
+	return specs, nil
+}
+
+// BundleCalls combines several calls into a single call. With BatchModeAccount (the default) it calls
+// executeBatch on the given account, and with BatchModeMulticall3 it calls Multicall3, refusing calls
+// that fail CheckMulticall3Safe. The bundled call's value is the sum of the inner call values.
+func BundleCalls(calls []*ContractCall, mode BatchMode, account string, registry *ABIRegistry) (*ContractCall, error) {
+	if len(calls) == 0 {
+		return nil, fmt.Errorf("no calls to bundle")
+	}
+
+	totalValue := new(big.Int)
+	for _, call := range calls {
+		totalValue.Add(totalValue, call.Value.BigInt())
+	}
+
+	switch mode {
+	case BatchModeMulticall3:
+		for i, call := range calls {
+			if err := call.CheckMulticall3Safe(); err != nil {
+				return nil, fmt.Errorf("call %d cannot be made in batch mode %s: %v; use batch mode %s with the smart account",
+					i+1, BatchModeMulticall3, err, BatchModeAccount)
+			}
+		}
+
+		multicallAbi, err := registry.Get(ABINameMulticall3)
+		if err != nil {
+			return nil, err
+		}
+
+		type call3Value struct {
+			Target       common.Address
+			AllowFailure bool
+			Value        *big.Int
+			CallData     []byte
+		}
+		multicalls := make([]call3Value, len(calls))
+		for i, call := range calls {
+			data, err := call.ABI.Pack(call.Method, call.Args...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode call %d: %v", i+1, err)
+			}
+			multicalls[i] = call3Value{
+				Target:   common.HexToAddress(call.To),
+				Value:    call.Value.BigInt(),
+				CallData: data,
+			}
+		}
+
+		return &ContractCall{
+			To:     Multicall3Address,
+			ABI:    multicallAbi,
+			Method: "aggregate3Value",
+			Args:   []any{multicalls},
+			Value:  NewAmount(totalValue),
+		}, nil
+
+	case BatchModeAccount, "":
+		if !common.IsHexAddress(account) {
+			return nil, fmt.Errorf("batch mode %s requires a smart account address, got %q", BatchModeAccount, account)
+		}
+
+		batchAbi, err := registry.Get(ABINameBatchAccount)
+		if err != nil {
+			return nil, err
+		}
+
+		type batchCall struct {
+			Target common.Address
+			Value  *big.Int
+			Data   []byte
+		}
+		batchCalls := make([]batchCall, len(calls))
+		for i, call := range calls {
+			data, err := call.ABI.Pack(call.Method, call.Args...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode call %d: %v", i+1, err)
+			}
+			batchCalls[i] = batchCall{
+				Target: common.HexToAddress(call.To),
+				Value:  call.Value.BigInt(),
+				Data:   data,
+			}
+		}
+
+		return &ContractCall{
+			To:     account,
+			ABI:    batchAbi,
+			Method: "executeBatch",
+			Args:   []any{batchCalls},
+			Value:  NewAmount(totalValue),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown batch mode %q (expected %s or %s)", mode, BatchModeMulticall3, BatchModeAccount)
+}
@@ -0,0 +1,580 @@
+// operation_executor.go signs and sends operation sets, refreshing stale quotes before sending
+package orby
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"go-app/src/caip"
+)
+
+// OperationSetFetcher requests a fresh operation set from Orby
+type OperationSetFetcher func() (*OperationSet, error)
+
+// OperationSetPolicy checks a quote before its operations are signed and again before they are sent
+type OperationSetPolicy func(quote Quote) error
+
+// ErrQuoteExpired is returned by ExpiryPolicy for quotes older than their max age; the executor
+// re-requests the operation set instead of failing
+var ErrQuoteExpired = errors.New("quote expired")
+
+// ErrSendFailed wraps errors of orby_sendSignedOperations. The signed operations may have reached
+// Orby, so signatures and nonces used by them must not be reused.
+var ErrSendFailed = errors.New("failed to send signed operations")
+
+// QuoteOptions controls when a quote is considered stale
+type QuoteOptions struct {
+	// MaxAge is how long a quote may be used after it was fetched
+	MaxAge time.Duration
+	// MaxPriceMovementBps is the largest change in input or output amounts (in basis points)
+	// accepted when a quote is refreshed
+	MaxPriceMovementBps int64
+	// MaxRefreshes is how many times a quote is re-requested before giving up
+	MaxRefreshes int
+}
+
+// DefaultQuoteOptions returns the quote options used when none are configured
+func DefaultQuoteOptions() QuoteOptions {
+	return QuoteOptions{
+		MaxAge:              30 * time.Second,
+		MaxPriceMovementBps: 50,
+		MaxRefreshes:        3,
+	}
+}
+
+// QuoteOptionsFromEnv reads quote options from QUOTE_MAX_AGE_SECONDS, QUOTE_MAX_PRICE_MOVEMENT_BPS
+// and QUOTE_MAX_REFRESHES, falling back to DefaultQuoteOptions
+func QuoteOptionsFromEnv() (QuoteOptions, error) {
+	options := DefaultQuoteOptions()
+
+	if value := GetEnvWithDefault("QUOTE_MAX_AGE_SECONDS", ""); value != "" {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds <= 0 {
+			return options, fmt.Errorf("invalid QUOTE_MAX_AGE_SECONDS: %s", value)
+		}
+		options.MaxAge = time.Duration(seconds * float64(time.Second))
+	}
+
+	if value := GetEnvWithDefault("QUOTE_MAX_PRICE_MOVEMENT_BPS", ""); value != "" {
+		bps, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || bps < 0 {
+			return options, fmt.Errorf("invalid QUOTE_MAX_PRICE_MOVEMENT_BPS: %s", value)
+		}
+		options.MaxPriceMovementBps = bps
+	}
+
+	if value := GetEnvWithDefault("QUOTE_MAX_REFRESHES", ""); value != "" {
+		refreshes, err := strconv.Atoi(value)
+		if err != nil || refreshes < 0 {
+			return options, fmt.Errorf("invalid QUOTE_MAX_REFRESHES: %s", value)
+		}
+		options.MaxRefreshes = refreshes
+	}
+
+	return options, nil
+}
+
+// Quote is an operation set along with the time it was fetched and, if watch-only accounts signed
+// some of its operations, the time their signatures were collected
+type Quote struct {
+	OperationSet *OperationSet
+	FetchedAt    time.Time
+	CollectedAt  time.Time
+}
+
+// Age returns how long ago the quote was fetched, or its signatures were collected. Out-of-band
+// signatures may take up to WATCH_ONLY_SIGNATURE_TIMEOUT_SECONDS, so the time spent collecting them
+// does not count towards the max age; otherwise no quote signed by a person would be sent.
+func (q Quote) Age(now time.Time) time.Duration {
+	if q.CollectedAt.After(q.FetchedAt) {
+		return now.Sub(q.CollectedAt)
+	}
+	return now.Sub(q.FetchedAt)
+}
+
+// OperationExecutor fetches an operation set, runs policy checks, signs its operations and sends them,
+// re-requesting the operation set when the quote expires before it is sent. Every quote is checked by
+// ExpiryPolicy and PriceMovementPolicy, built from QuoteOptions for each Execute, and then by Policies.
+// Operations are routed to their signer by Router, or DefaultSignerRouter if it is nil. Chain reads go
+// through the virtual node of the chain in VirtualNodes, or the client's URL if it is nil.
+type OperationExecutor struct {
+	Client           *OrbyClient
+	AccountClusterId string
+	Policies         []OperationSetPolicy
+	QuoteOptions     QuoteOptions
+	Signers          *SignerRegistry
+	Router           *SignerRouter
+	VirtualNodes     *VirtualNodePool
+	Now              func() time.Time
+
+	// SignedOperations are the operations of the last quote sent, e.g. to return their signatures
+	SignedOperations []SignedOperation
+}
+
+// NewOperationExecutor creates a new OperationExecutor with the default quote options and signers
+func NewOperationExecutor(client *OrbyClient, accountClusterId string) *OperationExecutor {
+	return &OperationExecutor{
+		Client:           client,
+		AccountClusterId: accountClusterId,
+		QuoteOptions:     DefaultQuoteOptions(),
+		Signers:          DefaultSignerRegistry(),
+		Now:              time.Now,
+	}
+}
+
+// AddPolicy adds a policy check that runs on every quote, including refreshed ones
+func (e *OperationExecutor) AddPolicy(policy OperationSetPolicy) {
+	e.Policies = append(e.Policies, policy)
+}
+
+// ExpiryPolicy rejects quotes fetched more than maxAge ago with ErrQuoteExpired
+func ExpiryPolicy(maxAge time.Duration, now func() time.Time) OperationSetPolicy {
+	return func(quote Quote) error {
+		if age := quote.Age(now()); age > maxAge {
+			return fmt.Errorf("%w after %s (max age %s)", ErrQuoteExpired, age.Round(time.Millisecond), maxAge)
+		}
+		return nil
+	}
+}
+
+// PriceMovementPolicy remembers the first quote it checks and rejects later quotes whose input or
+// output amounts moved more than maxBps from it, so refreshes cannot drift step by step
+func PriceMovementPolicy(maxBps int64) OperationSetPolicy {
+	var first, last *OperationSet
+	return func(quote Quote) error {
+		if first == nil {
+			first = quote.OperationSet
+		}
+		if quote.OperationSet == first {
+			return nil
+		}
+		movementBps := PriceMovementBps(first, quote.OperationSet)
+		if movementBps == math.MaxInt64 {
+			return fmt.Errorf("the quote's input or output tokens changed since the first quote")
+		}
+		if quote.OperationSet != last {
+			fmt.Printf("[INFO] Price movement since the first quote: %d bps\n", movementBps)
+			last = quote.OperationSet
+		}
+		if movementBps > maxBps {
+			return fmt.Errorf("price moved %d bps since the first quote (max %d bps)", movementBps, maxBps)
+		}
+		return nil
+	}
+}
+
+// Execute fetches an operation set, then signs and sends its operations. It fails if an operation
+// cannot be signed, so a partial operation set is never sent.
+func (e *OperationExecutor) Execute(fetch OperationSetFetcher) (json.RawMessage, error) {
+	policies := append([]OperationSetPolicy{
+		ExpiryPolicy(e.QuoteOptions.MaxAge, e.Now),
+		PriceMovementPolicy(e.QuoteOptions.MaxPriceMovementBps),
+	}, e.Policies...)
+
+	quote, err := e.fetchQuote(fetch)
+	if err != nil {
+		return nil, err
+	}
+
+	for refreshes := 0; ; refreshes++ {
+		// 1. Check, sign and send the quote, unless it expired before it could be sent
+		result, err := e.sendQuote(policies, quote)
+		if !errors.Is(err, ErrQuoteExpired) {
+			return result, err
+		}
+
+		fmt.Printf("\n[WARN] %v\n", err)
+		if refreshes >= e.QuoteOptions.MaxRefreshes {
+			return nil, fmt.Errorf("quote expired and was refreshed %d times without being sent", refreshes)
+		}
+
+		// 2. Re-request the operations; the policies check the price has not moved too much
+		fmt.Println("\n[INFO] Refreshing quote...")
+		if quote, err = e.fetchQuote(fetch); err != nil {
+			return nil, fmt.Errorf("failed to refresh quote: %v", err)
+		}
+	}
+}
+
+// sendQuote checks a quote against the policies, signs its operations, checks the policies again (so
+// a quote that expired while signing is not sent) and sends the signed operations
+func (e *OperationExecutor) sendQuote(policies []OperationSetPolicy, quote Quote) (json.RawMessage, error) {
+	// 1. Check the quote against the policies
+	if err := checkPolicies(policies, quote); err != nil {
+		return nil, err
+	}
+
+	// 2. Sign the operations, failing if one has no signer or cannot be signed
+	PrintOperationSet(quote.OperationSet)
+	signedOperations, collected, err := e.sign(quote.OperationSet)
+	if err != nil {
+		return nil, err
+	}
+	if collected {
+		quote.CollectedAt = e.Now()
+	}
+	if err := e.verifySmartAccountSignatures(quote.OperationSet, signedOperations); err != nil {
+		return nil, err
+	}
+
+	// 3. Send the operations if the quote still passes the policies, such as its expiry
+	if err := checkPolicies(policies, quote); err != nil {
+		return nil, err
+	}
+	e.SignedOperations = signedOperations
+	return e.send(signedOperations)
+}
+
+// chainRpcUrl returns the URL that reads a chain's state
+func (e *OperationExecutor) chainRpcUrl(chainId caip.ChainID) (string, error) {
+	if e.VirtualNodes == nil {
+		return e.Client.OrbyURL, nil
+	}
+	client, err := e.VirtualNodes.ForChain(e.AccountClusterId, chainId)
+	if err != nil {
+		return "", err
+	}
+	return client.OrbyURL, nil
+}
+
+// router returns the executor's signer router, or the default one
+func (e *OperationExecutor) router() (*SignerRouter, error) {
+	if e.Router != nil {
+		return e.Router, nil
+	}
+	return DefaultSignerRouter()
+}
+
+// sign routes every operation to its signer, then signs them. It also reports whether signatures
+// were collected from watch-only accounts.
+func (e *OperationExecutor) sign(operationSet *OperationSet) ([]SignedOperation, bool, error) {
+	router, err := e.router()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := router.CheckOperationSet(operationSet); err != nil {
+		return nil, false, err
+	}
+	signedOperations, err := SignOperationSetRouted(operationSet, e.Signers, router)
+	return signedOperations, router.HasWatchOnly(operationSet), err
+}
+
+func (e *OperationExecutor) fetchQuote(fetch OperationSetFetcher) (Quote, error) {
+	operationSet, err := fetch()
+	if err != nil {
+		return Quote{}, err
+	}
+
+	// Cache the metadata of the quoted tokens; the quote is usable even if this fails
+	if registry, err := DefaultTokenRegistry(); err == nil {
+		if err := registry.RememberOperationSetTokens(operationSet); err != nil {
+			log.Printf("[ERROR] Error caching quoted tokens: %v", err)
+		}
+	}
+	return Quote{OperationSet: operationSet, FetchedAt: e.Now()}, nil
+}
+
+func checkPolicies(policies []OperationSetPolicy, quote Quote) error {
+	for _, policy := range policies {
+		if err := policy(quote); err != nil {
+			return fmt.Errorf("operation set rejected by policy: %w", err)
+		}
+	}
+	return nil
+}
+
+func (e *OperationExecutor) send(signedOperations []SignedOperation) (json.RawMessage, error) {
+	fmt.Printf("\nSending %d signed operations to orby_sendSignedOperations...\n", len(signedOperations))
+
+	sendResult, err := e.Client.SendSignedOperations(signedOperations, e.AccountClusterId)
+	if err != nil {
+		log.Printf("[ERROR] Error sending signed operations: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrSendFailed, err)
+	}
+
+	// Parse and display the send result
+	var sendResponse any
+	if jsonErr := json.Unmarshal(sendResult, &sendResponse); jsonErr != nil {
+		log.Printf("[ERROR] Error parsing orby_sendSignedOperations response: %v", jsonErr)
+		// Try to display raw response
+		fmt.Printf("[INFO] Raw response: %s\n", string(sendResult))
+	} else {
+		jsonFormatted, _ := json.MarshalIndent(sendResponse, "", "  ")
+		fmt.Printf("\n[INFO] Signed operations sent successfully:\n%s\n", string(jsonFormatted))
+	}
+
+	return sendResult, nil
+}
+
+// ParseOperationSet parses the response of an orby_getOperations* call into an OperationSet
+func ParseOperationSet(result json.RawMessage, method string) (*OperationSet, error) {
+	var response OperationSet
+	if err := json.Unmarshal(result, &response); err != nil {
+		log.Printf("[ERROR] Error parsing %s response: %v", method, err)
+		// Try to display raw response
+		var rawResponse any
+		if json.Unmarshal(result, &rawResponse) == nil {
+			fmt.Printf("          Raw response: %v\n", rawResponse)
+		}
+		return nil, err
+	}
+
+	if response.Status == "" {
+		var errorResponse ErrorResponse
+		if err := json.Unmarshal(result, &errorResponse); err == nil {
+			fmt.Printf("\n[ERROR] Failed to call %s:", method)
+			fmt.Printf("\n          Code: %v", errorResponse.Code)
+			fmt.Printf("\n          Message: %s\n", errorResponse.Message)
+			return nil, fmt.Errorf("%s failed: %s (code: %d)", method, errorResponse.Message, errorResponse.Code)
+		}
+		return nil, fmt.Errorf("%s returned an operation set without a status", method)
+	}
+
+	return &response, nil
+}
+
+// PrintOperationSet displays an operation set and its operations
+func PrintOperationSet(operationSet *OperationSet) {
+	fmt.Printf("\n[INFO] Operation Set:\n")
+	fmt.Printf("        Status: %s\n", operationSet.Status)
+	fmt.Printf("        Estimated Time: %d ms\n", operationSet.AggregateEstimatedTimeInMs)
+
+	if len(operationSet.Intents) == 0 {
+		return
+	}
+
+	fmt.Printf("        Number of Operations: %d\n", len(operationSet.Intents[0].IntentOperations))
+	for i, op := range operationSet.Intents[0].IntentOperations {
+		fmt.Printf("\n        Operation %d:\n", i+1)
+		fmt.Printf("          Type: %s\n", op.Type)
+		fmt.Printf("          Format: %s\n", op.Format)
+		fmt.Printf("          From: %s\n", op.From)
+		fmt.Printf("          To: %s\n", op.To)
+		fmt.Printf("          Chain: %s\n", ChainDisplayName(op.ChainId))
+		if rpcUrl, err := OperationRpcUrl(op); err == nil {
+			fmt.Printf("          TX RPC URL: %s\n", rpcUrl)
+		}
+		if op.EstimatedNetworkFees != nil {
+			fmt.Printf("          Estimated Network Fees: %s\n", op.EstimatedNetworkFees.Format())
+		}
+		if op.Format == TransactionFormat && !op.ChainId.IsSolana() {
+			printDecodedCall(op)
+		}
+	}
+}
+
+// printDecodedCall displays the operation's calldata decoded with the default ABI registry, if possible
+func printDecodedCall(op Operation) {
+	data := OperationCalldata(op)
+	if len(data) < 4 {
+		return
+	}
+
+	registry, err := DefaultABIRegistry()
+	if err != nil {
+		log.Printf("[WARN] Unable to load ABI registry: %v", err)
+		return
+	}
+
+	decoded, err := registry.DecodeCalldata(op.To, data)
+	if err != nil {
+		fmt.Printf("          Call: unknown (%v)\n", err)
+		return
+	}
+	fmt.Printf("          Call: %s [%s]\n", decoded.String(), decoded.ABIName)
+	for i, innerCall := range decoded.InnerCalls {
+		call := "unknown"
+		if innerCall.Decoded != nil {
+			call = innerCall.Decoded.String()
+		}
+		fmt.Printf("            Inner Call %d: %s -> %s (value: %s)\n", i+1, innerCall.Target.Hex(), call, innerCall.Value)
+	}
+}
+
+// verifySmartAccountSignatures checks the typed data and message signatures made for smart accounts by simulating
+// isValidSignature on the operation's account through SMART_ACCOUNT_RPC_URL (by default the virtual node of the
+// operation's chain, or the executor's client URL without VirtualNodes)
+func (e *OperationExecutor) verifySmartAccountSignatures(operationSet *OperationSet, signedOperations []SignedOperation) error {
+	if len(operationSet.Intents) == 0 {
+		return nil
+	}
+	router, err := e.router()
+	if err != nil {
+		return err
+	}
+	for _, signed := range signedOperations {
+		for _, op := range operationSet.Intents[0].IntentOperations {
+			if (op.Format != TypedDataFormat && op.Format != MessageFormat) || op.Data != signed.Data ||
+				op.ChainId != signed.ChainId || op.From != signed.From {
+				continue
+			}
+			account, err := router.OperationSmartAccount(op)
+			if err != nil {
+				return err
+			}
+			if account == nil {
+				break
+			}
+
+			hash, err := OperationSigningHash(op)
+			if err != nil {
+				return err
+			}
+			rpcUrl, err := e.chainRpcUrl(op.ChainId)
+			if err != nil {
+				return err
+			}
+			rpcUrl = GetEnvWithDefault("SMART_ACCOUNT_RPC_URL", rpcUrl)
+			valid, err := e.Client.IsValidSignature(rpcUrl, account.Address, hash, common.FromHex(signed.Signature))
+			if err != nil {
+				return err
+			}
+			if !valid {
+				return fmt.Errorf("smart account %s rejected the signature of %s", account.Address.Hex(), hash.Hex())
+			}
+			fmt.Printf("\n[INFO] isValidSignature accepted the signature of %s for %s\n", hash.Hex(), account.Address.Hex())
+			break
+		}
+	}
+	return nil
+}
+
+// SignOperationSet signs the operations of the first intent with the default signer registry.
+// It fails if any operation cannot be signed or has an unknown format.
+func SignOperationSet(operationSet *OperationSet) ([]SignedOperation, error) {
+	return SignOperationSetWith(operationSet, DefaultSignerRegistry())
+}
+
+// SignOperationSetWith signs the operations of the first intent with the signer registered for
+// their format. It fails if any operation cannot be signed or has an unknown format.
+func SignOperationSetWith(operationSet *OperationSet, signers *SignerRegistry) ([]SignedOperation, error) {
+	return SignOperationSetRouted(operationSet, signers, nil)
+}
+
+// SignOperationSetRouted signs the operations of the first intent with the signer registered for
+// their format, except operations from watch-only accounts of the router, whose signatures are
+// collected out-of-band. It fails if any operation cannot be signed, since the operations of an
+// intent only work together, or if there is nothing to sign.
+func SignOperationSetRouted(operationSet *OperationSet, signers *SignerRegistry, router *SignerRouter) ([]SignedOperation, error) {
+	if len(operationSet.Intents) == 0 || len(operationSet.Intents[0].IntentOperations) == 0 {
+		return nil, fmt.Errorf("the operation set has no operations to sign (status: %s)", operationSet.Status)
+	}
+
+	// Collection of signed operations to send
+	var signedOperations []SignedOperation
+	for i, op := range operationSet.Intents[0].IntentOperations {
+		fmt.Printf("\n[INFO] Signing operation %d (%s)...\n", i+1, op.Format)
+
+		// Sign the operation based on its account and format
+		var signature string
+		var err error
+		if route, routeErr := routeOperation(router, op); routeErr == nil && route.WatchOnly {
+			if router.Collector == nil {
+				return nil, fmt.Errorf("operation %d: %s is watch-only and no signature collector is configured", i+1, op.From)
+			}
+			signature, err = router.Collector.Collect(op)
+		} else {
+			if routeErr == nil {
+				fmt.Printf("          Signer: %s\n", route.Describe())
+			}
+			signer, ok := signers.Get(op.Format)
+			if !ok {
+				return nil, fmt.Errorf("operation %d: no signer for format %s", i+1, op.Format)
+			}
+			signature, err = signer(op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign operation %d (%s): %v", i+1, op.Format, err)
+		}
+		fmt.Printf("          Signed %s: %s\n", op.Format, signature)
+
+		// Create a signed operation for sending
+		signedOperations = append(signedOperations, SignedOperation{
+			Type:      op.Type,
+			Signature: signature,
+			Data:      op.Data,
+			ChainId:   op.ChainId,
+			From:      op.From,
+		})
+	}
+
+	return signedOperations, nil
+}
+
+// routeOperation routes an operation with the router, if there is one
+func routeOperation(router *SignerRouter, operation Operation) (SignerRoute, error) {
+	if router == nil {
+		return SignerRoute{}, fmt.Errorf("no signer router")
+	}
+	return router.Route(operation)
+}
+
+// PriceMovementBps returns the largest relative change, in basis points, between the input and
+// output token amounts of two quotes. Tokens are matched by chain ID and address; a token that one
+// quote has and the other does not counts as math.MaxInt64, so a refresh cannot change the tokens.
+func PriceMovementBps(previous *OperationSet, current *OperationSet) int64 {
+	inputMovement := stateMovementBps(previous.InputState, current.InputState)
+	outputMovement := stateMovementBps(previous.OutputState, current.OutputState)
+	if inputMovement > outputMovement {
+		return inputMovement
+	}
+	return outputMovement
+}
+
+func stateMovementBps(previous State, current State) int64 {
+	currentAmounts := make(map[string]Amount)
+	for _, tokenAmount := range current.FungibleTokenAmounts {
+		currentAmounts[tokenAmountKey(tokenAmount)] = tokenAmount.Amount
+	}
+
+	previousKeys := make(map[string]bool)
+	for _, tokenAmount := range previous.FungibleTokenAmounts {
+		previousKeys[tokenAmountKey(tokenAmount)] = true
+	}
+	for key := range currentAmounts {
+		if !previousKeys[key] {
+			return math.MaxInt64
+		}
+	}
+
+	var maxMovement int64
+	for _, tokenAmount := range previous.FungibleTokenAmounts {
+		currentAmount, ok := currentAmounts[tokenAmountKey(tokenAmount)]
+		if !ok {
+			return math.MaxInt64
+		}
+
+		previousValue := tokenAmount.Amount.BigInt()
+		if previousValue.Sign() == 0 {
+			continue
+		}
+
+		// movement = |current - previous| * 10000 / previous
+		difference := new(big.Int).Sub(currentAmount.BigInt(), previousValue)
+		difference.Abs(difference)
+		movement := difference.Mul(difference, big.NewInt(10_000))
+		movement.Quo(movement, previousValue)
+
+		if !movement.IsInt64() {
+			return math.MaxInt64
+		}
+		if movement.Int64() > maxMovement {
+			maxMovement = movement.Int64()
+		}
+	}
+
+	return maxMovement
+}
+
+func tokenAmountKey(tokenAmount TokenAmount) string {
+	return tokenAmount.Token.ChainId.String() + ":" + strings.ToLower(tokenAmount.Token.Address)
+}
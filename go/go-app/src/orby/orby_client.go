@@ -7,7 +7,17 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/big"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"go-app/src/caip"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
 
 // Define OrbyClient struct to interact with Orby Engine API
@@ -89,6 +99,79 @@ func (c *OrbyClient) CreateOrbyInstance(name string) (*OrbyInstanceResponse, err
 	return &response, nil
 }
 
+// The engine admin methods other than orby_createInstance. Their names and parameters are assumed from
+// orby_createInstance's and have not been confirmed against the engine admin API, so a method that does
+// not exist fails with the engine's JSON-RPC error.
+const (
+	adminListInstances      = "orby_listInstances"
+	adminGetInstance        = "orby_getInstance"
+	adminRotateInstanceUrls = "orby_rotateInstanceUrls"
+	adminDeleteInstance     = "orby_deleteInstance"
+	adminConfigureInstance  = "orby_configureInstance"
+)
+
+// ListOrbyInstances lists the instances of the Orby engine
+func (c *OrbyClient) ListOrbyInstances() ([]OrbyInstance, error) {
+	var response ListOrbyInstancesResponse
+	if err := c.adminRequest(adminListInstances, []any{}, &response); err != nil {
+		return nil, err
+	}
+	return response.Instances, nil
+}
+
+// GetOrbyInstance describes the instance with the given name
+func (c *OrbyClient) GetOrbyInstance(name string) (*OrbyInstance, error) {
+	var response OrbyInstance
+	if err := c.adminRequest(adminGetInstance, []any{OrbyInstanceNameParams{Name: name}}, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// RotateOrbyInstanceUrls replaces the private and public URLs of an instance. The previous URLs
+// stop working, so clients using them must switch to the returned ones.
+func (c *OrbyClient) RotateOrbyInstanceUrls(name string) (*OrbyInstanceResponse, error) {
+	var response OrbyInstanceResponse
+	if err := c.adminRequest(adminRotateInstanceUrls, []any{OrbyInstanceNameParams{Name: name}}, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// DeleteOrbyInstance deletes an instance along with its account clusters
+func (c *OrbyClient) DeleteOrbyInstance(name string) error {
+	var response DeleteOrbyInstanceResponse
+	if err := c.adminRequest(adminDeleteInstance, []any{OrbyInstanceNameParams{Name: name}}, &response); err != nil {
+		return err
+	}
+	if !response.Success {
+		return fmt.Errorf("%s did not delete instance %s", adminDeleteInstance, name)
+	}
+	return nil
+}
+
+// ConfigureOrbyInstance updates the settings of an instance and returns the updated instance
+func (c *OrbyClient) ConfigureOrbyInstance(name string, config OrbyInstanceConfig) (*OrbyInstance, error) {
+	var response OrbyInstance
+	params := []any{ConfigureOrbyInstanceParams{Name: name, Config: config}}
+	if err := c.adminRequest(adminConfigureInstance, params, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// adminRequest calls an engine admin method and parses its result into response
+func (c *OrbyClient) adminRequest(method string, params []any, response any) error {
+	resultBytes, err := c.SendJSONRPCRequest(c.EngineAdminURL, method, params)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(resultBytes, response); err != nil {
+		return fmt.Errorf("failed to parse %s response: %v", method, err)
+	}
+	return nil
+}
+
 // CreateAccountCluster creates an account cluster with the given accounts
 func (c *OrbyClient) CreateAccountCluster(accounts []AccountParams) (json.RawMessage, error) {
 	params := []interface{}{
@@ -100,8 +183,32 @@ func (c *OrbyClient) CreateAccountCluster(accounts []AccountParams) (json.RawMes
 	return c.SendJSONRPCRequest(c.OrbyURL, "orby_createAccountCluster", params)
 }
 
+// AddAccountsToCluster adds accounts to an existing account cluster
+func (c *OrbyClient) AddAccountsToCluster(accountClusterId string, accounts []AccountParams) (json.RawMessage, error) {
+	params := []any{
+		UpdateAccountClusterParams{
+			AccountClusterId: accountClusterId,
+			Accounts:         accounts,
+		},
+	}
+
+	return c.SendJSONRPCRequest(c.OrbyURL, "orby_addAccountsToCluster", params)
+}
+
+// RemoveAccountsFromCluster removes accounts from an existing account cluster
+func (c *OrbyClient) RemoveAccountsFromCluster(accountClusterId string, accounts []AccountParams) (json.RawMessage, error) {
+	params := []any{
+		UpdateAccountClusterParams{
+			AccountClusterId: accountClusterId,
+			Accounts:         accounts,
+		},
+	}
+
+	return c.SendJSONRPCRequest(c.OrbyURL, "orby_removeAccountsFromCluster", params)
+}
+
 // GetVirtualNodeRpcUrl gets the virtual node RPC URL for the given parameters
-func (c *OrbyClient) GetVirtualNodeRpcUrl(accountClusterId string, chainId string, entrypointAccountAddress string) (json.RawMessage, error) {
+func (c *OrbyClient) GetVirtualNodeRpcUrl(accountClusterId string, chainId caip.ChainID, entrypointAccountAddress string) (json.RawMessage, error) {
 	params := []any{
 		GetVirtualNodeRpcUrlParams{
 			AccountClusterId:         accountClusterId,
@@ -128,9 +235,9 @@ func (c *OrbyClient) GetStandardizedTokenIds(tokens []TokenParams) (json.RawMess
 func (c *OrbyClient) GetOperationsToSwap(
 	accountClusterId string,
 	standardizedTokenIds []string,
-	amount string,
-	externalInputTokenChainId string,
-	externalOutputTokenChainId string) (json.RawMessage, error) {
+	amount Amount,
+	inputTokenChainId caip.ChainID,
+	outputTokenChainId caip.ChainID) (json.RawMessage, error) {
 
 	// Check if standardizedTokenIds is empty
 	if len(standardizedTokenIds) == 0 {
@@ -143,17 +250,17 @@ func (c *OrbyClient) GetOperationsToSwap(
 		SwapType:         "EXACT_INPUT",
 		Input: InputSwapParam{
 			StandardizedTokenId: standardizedTokenIds[0],
-			Amount:              amount,
+			Amount:              &amount,
 			TokenSources: []TokenSource{
 				{
-					ChainID: externalInputTokenChainId,
+					ChainID: inputTokenChainId,
 				},
 			},
 		},
 		Output: OutputSwapParam{
 			StandardizedTokenId: standardizedTokenIds[len(standardizedTokenIds)-1],
 			TokenDestination: TokenSource{
-				ChainID: externalOutputTokenChainId,
+				ChainID: outputTokenChainId,
 			},
 		},
 	}
@@ -172,26 +279,39 @@ func (c *OrbyClient) GetOperationsToSwap(
 func (c *OrbyClient) GetOperationsToExecuteTransaction(
 	accountClusterId string,
 	data string,
-	to string) (json.RawMessage, error) {
-	params := []interface{}{
-		GetOperationsToExecuteTransactionParams{
-			AccountClusterId: accountClusterId,
-			Data:             data,
-			To:               to,
-		},
+	to string,
+	value Amount) (json.RawMessage, error) {
+	executeParams := GetOperationsToExecuteTransactionParams{
+		AccountClusterId: accountClusterId,
+		Data:             data,
+		To:               to,
+	}
+	if !value.IsZero() {
+		executeParams.Value = &value
 	}
+	params := []interface{}{executeParams}
 
 	return c.SendJSONRPCRequest(c.OrbyURL, "orby_getOperationsToExecuteTransaction", params)
 }
 
-// Call orby_getOperationsToSignTypedData with the params
+// Call orby_getOperationsToSignTypedData with the params. The typed data is validated before it is sent.
 func (c *OrbyClient) GetOperationsToSignTypedData(
 	accountClusterId string,
-	data string) (json.RawMessage, error) {
+	typedData *apitypes.TypedData) (json.RawMessage, error) {
+	AddEIP712DomainTypeToTypedData(typedData)
+	if err := ValidateTypedData(typedData); err != nil {
+		return nil, fmt.Errorf("invalid typed data: %v", err)
+	}
+
+	data, err := json.Marshal(typedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal typed data: %v", err)
+	}
+
 	params := []interface{}{
 		GetOperationsToSignTypedDataParams{
 			AccountClusterId: accountClusterId,
-			Data:             data,
+			Data:             string(data),
 		},
 	}
 
@@ -210,6 +330,18 @@ func (c *OrbyClient) GetFungibleTokenPortfolio(
 	return c.SendJSONRPCRequest(c.OrbyURL, "orby_getFungibleTokenPortfolio", params)
 }
 
+// Call orby_getOperationsToExecuteTransaction for a contract call
+func (c *OrbyClient) GetOperationsToExecuteContractCall(
+	accountClusterId string,
+	call *ContractCall) (json.RawMessage, error) {
+	data, err := call.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetOperationsToExecuteTransaction(accountClusterId, data, call.To, call.Value)
+}
+
 // SendOperationSet sends signed operations to the virtual node
 func (c *OrbyClient) SendSignedOperations(signedOperations []SignedOperation, accountClusterId string) (json.RawMessage, error) {
 	params := []interface{}{
@@ -221,3 +353,103 @@ func (c *OrbyClient) SendSignedOperations(signedOperations []SignedOperation, ac
 
 	return c.SendJSONRPCRequest(c.OrbyURL, "orby_sendSignedOperations", params)
 }
+
+// EthCall makes a read-only eth_call to a contract at the given RPC URL and returns the result
+func (c *OrbyClient) EthCall(rpcUrl string, to string, data []byte) ([]byte, error) {
+	params := []any{
+		map[string]string{
+			"to":   to,
+			"data": hexutil.Encode(data),
+		},
+		"latest",
+	}
+
+	resultBytes, err := c.SendJSONRPCRequest(rpcUrl, "eth_call", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var resultHex string
+	if err := json.Unmarshal(resultBytes, &resultHex); err != nil {
+		return nil, fmt.Errorf("failed to parse eth_call response: %v", err)
+	}
+
+	return common.FromHex(resultHex), nil
+}
+
+// GetTokenDecimals calls decimals() on an ERC-20 token through the client's RPC URL
+func (c *OrbyClient) GetTokenDecimals(tokenAddress string) (int, error) {
+	result, err := c.EthCall(c.OrbyURL, tokenAddress, common.FromHex("0x313ce567")) // decimals()
+	if err != nil {
+		return 0, err
+	}
+
+	decimals := new(big.Int).SetBytes(result)
+	if len(result) == 0 || !decimals.IsInt64() || decimals.Int64() > 255 {
+		return 0, fmt.Errorf("invalid decimals() result for token %s: %s", tokenAddress, hexutil.Encode(result))
+	}
+
+	return int(decimals.Int64()), nil
+}
+
+// GetTokenSymbol calls symbol() on an ERC-20 token through the client's RPC URL. Tokens such as MKR
+// that return the symbol as bytes32 are supported.
+func (c *OrbyClient) GetTokenSymbol(tokenAddress string) (string, error) {
+	result, err := c.EthCall(c.OrbyURL, tokenAddress, common.FromHex("0x95d89b41")) // symbol()
+	if err != nil {
+		return "", err
+	}
+
+	if len(result) == 32 {
+		return strings.TrimRight(string(result), "\x00"), nil
+	}
+	stringType, _ := abi.NewType("string", "", nil)
+	values, err := abi.Arguments{{Type: stringType}}.Unpack(result)
+	if err != nil || len(values) != 1 {
+		return "", fmt.Errorf("invalid symbol() result for token %s: %s", tokenAddress, hexutil.Encode(result))
+	}
+	return values[0].(string), nil
+}
+
+// ParseTokenAmount parses a user-provided amount for the given token.
+// Decimal amounts ("1.5", "1.5 USDC") are converted to base units using decimalsOverride if it is
+// not empty, otherwise the token's decimals are fetched on-chain. A unit suffix must be the token's
+// symbol, which is fetched on-chain if symbol is empty.
+func (c *OrbyClient) ParseTokenAmount(input string, tokenAddress string, symbol string, decimalsOverride string) (Amount, int, error) {
+	if !IsDecimalAmountInput(input) && decimalsOverride == "" {
+		amount, err := ParseRawAmount(input)
+		return amount, -1, err
+	}
+
+	var decimals int
+	if decimalsOverride != "" {
+		parsed, err := strconv.Atoi(decimalsOverride)
+		if err != nil {
+			return Amount{}, 0, fmt.Errorf("invalid token decimals %q: %v", decimalsOverride, err)
+		}
+		decimals = parsed
+	} else {
+		fetched, err := c.GetTokenDecimals(tokenAddress)
+		if err != nil {
+			return Amount{}, 0, fmt.Errorf("failed to get decimals for token %s: %v", tokenAddress, err)
+		}
+		decimals = fetched
+	}
+
+	amount, unit, err := ParseAmountInput(input, decimals)
+	if err != nil || unit == "" {
+		return amount, decimals, err
+	}
+
+	if symbol == "" {
+		fetched, err := c.GetTokenSymbol(tokenAddress)
+		if err != nil {
+			return Amount{}, 0, fmt.Errorf("failed to get the symbol of token %s to check amount %q: %v", tokenAddress, input, err)
+		}
+		symbol = fetched
+	}
+	if err := CheckAmountUnit(unit, symbol); err != nil {
+		return Amount{}, 0, fmt.Errorf("%v (token %s)", err, tokenAddress)
+	}
+	return amount, decimals, nil
+}
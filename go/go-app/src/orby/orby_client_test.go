@@ -0,0 +1,205 @@
+package orby
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go-app/src/caip"
+)
+
+// adminRequestRecord is a JSON-RPC request received by the test admin server
+type adminRequestRecord struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// newAdminServer serves one JSON-RPC response body for every request and records the requests
+func newAdminServer(t *testing.T, response string) (*OrbyClient, *[]adminRequestRecord) {
+	t.Helper()
+	var requests []adminRequestRecord
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request adminRequestRecord
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("invalid JSON-RPC request: %v", err)
+		}
+		requests = append(requests, request)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return NewOrbyClient(server.URL, ""), &requests
+}
+
+func result(value string) string {
+	return `{"jsonrpc":"2.0","id":1,"result":` + value + `}`
+}
+
+// assertRequest checks the method and params of the only request the server received
+func assertRequest(t *testing.T, requests []adminRequestRecord, method string, params string) {
+	t.Helper()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	if requests[0].Method != method {
+		t.Errorf("method = %s, want %s", requests[0].Method, method)
+	}
+	var got, want any
+	if err := json.Unmarshal(requests[0].Params, &got); err != nil {
+		t.Fatalf("invalid params %s: %v", requests[0].Params, err)
+	}
+	if err := json.Unmarshal([]byte(params), &want); err != nil {
+		t.Fatalf("invalid expected params %s: %v", params, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("params = %s, want %s", requests[0].Params, params)
+	}
+}
+
+const testInstanceJSON = `{
+	"name": "test",
+	"orbyInstancePrivateUrl": "https://private.example/test",
+	"orbyInstancePublicUrl": "https://public.example/test",
+	"createdAt": "2026-01-01T00:00:00Z",
+	"config": {"allowedOrigins": ["https://app.example"], "rateLimitPerMinute": 600, "chainIds": ["eip155-1"]}
+}`
+
+func testInstance() OrbyInstance {
+	rateLimit := 600
+	return OrbyInstance{
+		Name:                   "test",
+		OrbyInstancePrivateUrl: "https://private.example/test",
+		OrbyInstancePublicUrl:  "https://public.example/test",
+		CreatedAt:              "2026-01-01T00:00:00Z",
+		Config: &OrbyInstanceConfig{
+			AllowedOrigins:     []string{"https://app.example"},
+			RateLimitPerMinute: &rateLimit,
+			ChainIds:           []caip.ChainID{{Namespace: "eip155", Reference: "1"}},
+		},
+	}
+}
+
+func TestListOrbyInstances(t *testing.T) {
+	client, requests := newAdminServer(t, result(`{"instances": [`+testInstanceJSON+`]}`))
+
+	instances, err := client.ListOrbyInstances()
+	if err != nil {
+		t.Fatalf("ListOrbyInstances: %v", err)
+	}
+	assertRequest(t, *requests, "orby_listInstances", `[]`)
+	if want := []OrbyInstance{testInstance()}; !reflect.DeepEqual(instances, want) {
+		t.Errorf("instances = %+v, want %+v", instances, want)
+	}
+}
+
+func TestGetOrbyInstance(t *testing.T) {
+	client, requests := newAdminServer(t, result(testInstanceJSON))
+
+	instance, err := client.GetOrbyInstance("test")
+	if err != nil {
+		t.Fatalf("GetOrbyInstance: %v", err)
+	}
+	assertRequest(t, *requests, "orby_getInstance", `[{"name": "test"}]`)
+	if want := testInstance(); !reflect.DeepEqual(*instance, want) {
+		t.Errorf("instance = %+v, want %+v", *instance, want)
+	}
+}
+
+func TestRotateOrbyInstanceUrls(t *testing.T) {
+	client, requests := newAdminServer(t, result(`{
+		"success": true,
+		"orbyInstancePrivateUrl": "https://private.example/rotated",
+		"orbyInstancePublicUrl": "https://public.example/rotated"
+	}`))
+
+	response, err := client.RotateOrbyInstanceUrls("test")
+	if err != nil {
+		t.Fatalf("RotateOrbyInstanceUrls: %v", err)
+	}
+	assertRequest(t, *requests, "orby_rotateInstanceUrls", `[{"name": "test"}]`)
+	want := OrbyInstanceResponse{
+		Success:                true,
+		OrbyInstancePrivateUrl: "https://private.example/rotated",
+		OrbyInstancePublicUrl:  "https://public.example/rotated",
+	}
+	if *response != want {
+		t.Errorf("response = %+v, want %+v", *response, want)
+	}
+}
+
+func TestDeleteOrbyInstance(t *testing.T) {
+	client, requests := newAdminServer(t, result(`{"success": true}`))
+
+	if err := client.DeleteOrbyInstance("test"); err != nil {
+		t.Fatalf("DeleteOrbyInstance: %v", err)
+	}
+	assertRequest(t, *requests, "orby_deleteInstance", `[{"name": "test"}]`)
+}
+
+func TestDeleteOrbyInstanceNotDeleted(t *testing.T) {
+	client, _ := newAdminServer(t, result(`{"success": false}`))
+
+	err := client.DeleteOrbyInstance("test")
+	if err == nil || !strings.Contains(err.Error(), "did not delete instance test") {
+		t.Errorf("DeleteOrbyInstance with success false: got error %v", err)
+	}
+}
+
+func TestConfigureOrbyInstance(t *testing.T) {
+	client, requests := newAdminServer(t, result(testInstanceJSON))
+
+	instance, err := client.ConfigureOrbyInstance("test", *testInstance().Config)
+	if err != nil {
+		t.Fatalf("ConfigureOrbyInstance: %v", err)
+	}
+	assertRequest(t, *requests, "orby_configureInstance", `[{
+		"name": "test",
+		"config": {"allowedOrigins": ["https://app.example"], "rateLimitPerMinute": 600, "chainIds": ["eip155-1"]}
+	}]`)
+	if want := testInstance(); !reflect.DeepEqual(*instance, want) {
+		t.Errorf("instance = %+v, want %+v", *instance, want)
+	}
+}
+
+func TestAdminRequestErrors(t *testing.T) {
+	calls := map[string]func(client *OrbyClient) error{
+		"ListOrbyInstances": func(client *OrbyClient) error {
+			_, err := client.ListOrbyInstances()
+			return err
+		},
+		"GetOrbyInstance": func(client *OrbyClient) error {
+			_, err := client.GetOrbyInstance("test")
+			return err
+		},
+		"RotateOrbyInstanceUrls": func(client *OrbyClient) error {
+			_, err := client.RotateOrbyInstanceUrls("test")
+			return err
+		},
+		"DeleteOrbyInstance": func(client *OrbyClient) error {
+			return client.DeleteOrbyInstance("test")
+		},
+		"ConfigureOrbyInstance": func(client *OrbyClient) error {
+			_, err := client.ConfigureOrbyInstance("test", OrbyInstanceConfig{})
+			return err
+		},
+	}
+
+	for name, call := range calls {
+		// JSON-RPC errors are returned with their message and code
+		client, _ := newAdminServer(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32602,"message":"instance not found"}}`)
+		err := call(client)
+		if err == nil || !strings.Contains(err.Error(), "instance not found (code: -32602)") {
+			t.Errorf("%s with an RPC error: got error %v", name, err)
+		}
+
+		// Results that do not parse into the response type are errors
+		client, _ = newAdminServer(t, result(`"unexpected"`))
+		err = call(client)
+		if err == nil || !strings.Contains(err.Error(), "failed to parse") {
+			t.Errorf("%s with an invalid result: got error %v", name, err)
+		}
+	}
+}
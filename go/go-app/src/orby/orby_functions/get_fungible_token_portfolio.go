@@ -9,21 +9,25 @@ import (
 )
 
 type GetFungibleTokenPortfolio struct {
-	VirtualNodeProvider orby.OrbyClient
-	AccountClusterId    string
+	VirtualNodes     *orby.VirtualNodePool
+	AccountClusterId string
 }
 
-func NewGetFungibleTokenPortfolio(client orby.OrbyClient, accountClusterId string) *GetFungibleTokenPortfolio {
+func NewGetFungibleTokenPortfolio(virtualNodes *orby.VirtualNodePool, accountClusterId string) *GetFungibleTokenPortfolio {
 	return &GetFungibleTokenPortfolio{
-		VirtualNodeProvider: client,
-		AccountClusterId:    accountClusterId,
+		VirtualNodes:     virtualNodes,
+		AccountClusterId: accountClusterId,
 	}
 }
 
 func (g *GetFungibleTokenPortfolio) Run() error {
-	// 1. Call operation
+	// 1. Call operation on the default virtual node, since the portfolio spans every chain
+	node, err := g.VirtualNodes.Default(g.AccountClusterId)
+	if err != nil {
+		return err
+	}
 	fmt.Println("\n[INFO] calling GetFungibleTokenPortfolio...")
-	result, err := g.VirtualNodeProvider.GetFungibleTokenPortfolio(
+	result, err := node.GetFungibleTokenPortfolio(
 		g.AccountClusterId)
 	if err != nil {
 		log.Printf("[ERROR] Error getting fungible token portfolio: %v", err)
@@ -41,22 +45,37 @@ func (g *GetFungibleTokenPortfolio) Run() error {
 		return err
 	}
 
-	// 3. Print result
+	// 3. Cache the tokens and their standardized IDs for later runs
+	registry, err := orby.DefaultTokenRegistry()
+	if err != nil {
+		return err
+	}
+	var tokens []orby.TokenInfo
+	for _, balance := range response.FungibleTokenBalances {
+		for _, tokenBalance := range append(balance.TokenBalances, balance.TokenBalancesOnChains...) {
+			tokens = append(tokens, orby.TokenInfoFromToken(tokenBalance.Token, balance.StandardizedTokenId))
+		}
+	}
+	if err := registry.Put(tokens...); err != nil {
+		log.Printf("[ERROR] Error caching portfolio tokens: %v", err)
+	}
+
+	// 4. Print result
 	fmt.Printf("\n[INFO] Fungible Token Portfolio Response:\n")
 	for _, balance := range response.FungibleTokenBalances {
 		fmt.Println("\nStandardized Token ID:", balance.StandardizedTokenId)
-		fmt.Println("Total:", balance.Total.Amount)
+		fmt.Println("Total:", balance.Total.Format())
 
 		// Loop through nested token balances
 		for i, tokenBalance := range balance.TokenBalances {
 			fmt.Printf("\n  Token Balance %d:\n", i)
 			fmt.Println("    Token Address:", tokenBalance.Token.Address)
-			fmt.Println("    Amount:", tokenBalance.Amount)
+			fmt.Println("    Amount:", tokenBalance.Format())
 		}
 		for i, tokenBalance := range balance.TokenBalancesOnChains {
 			fmt.Printf("\n  Token Balance On Chain %d:\n", i)
 			fmt.Println("    Token Address:", tokenBalance.Token.Address)
-			fmt.Println("    Amount:", tokenBalance.Amount)
+			fmt.Println("    Amount:", tokenBalance.Format())
 		}
 	}
 
@@ -1,209 +1,257 @@
 package orbyfunctions
 
 import (
-	"bytes"
 	"crypto/ecdsa"
-	"encoding/json"
 	"fmt"
 	"log"
 	"math/big"
-	"os"
+	"strings"
 
 	"go-app/src/orby"
 
-	"github.com/ethereum/go-ethereum/accounts/abi"
-	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
 type GetOperationsToExecuteTransaction struct {
-	VirtualNodeProvider orby.OrbyClient
-	AccountClusterId    string
+	VirtualNodes     *orby.VirtualNodePool
+	AccountClusterId string
 }
 
-func NewGetOperationsToExecuteTransaction(client orby.OrbyClient, accountClusterId string) *GetOperationsToExecuteTransaction {
+func NewGetOperationsToExecuteTransaction(virtualNodes *orby.VirtualNodePool, accountClusterId string) *GetOperationsToExecuteTransaction {
 	return &GetOperationsToExecuteTransaction{
-		VirtualNodeProvider: client,
-		AccountClusterId:    accountClusterId,
+		VirtualNodes:     virtualNodes,
+		AccountClusterId: accountClusterId,
 	}
 }
 
 func (g *GetOperationsToExecuteTransaction) Run() error {
-	// 0. Check for env variables
-	inputTokenAddress := orby.GetEnvWithDefault("INPUT_TOKEN_ADDRESS", "")
-	amount := orby.GetEnvWithDefault("AMOUNT", "0")
-
-	// 1. Format operation request
-	data, err := g.GetParams(amount)
+	// 0. Check for env variables. The input token is given by address, or by symbol if it is in the token registry.
+	inputTokenChainId, err := orby.GetChainIdFromEnv("INPUT_TOKEN_CHAIN_ID")
 	if err != nil {
 		return err
 	}
-
-	// 2. Call operation
-	fmt.Println("\n[INFO] calling GetOperationsToExecuteTransaction...")
-	result, err := g.VirtualNodeProvider.GetOperationsToExecuteTransaction(
-		g.AccountClusterId,
-		data,
-		inputTokenAddress)
+	inputToken, err := orby.ResolveTokenFromEnv("INPUT_TOKEN_ADDRESS", inputTokenChainId)
 	if err != nil {
-		log.Printf("[ERROR] Error getting operations to execute transaction: %v", err)
+		return err
 	}
+	inputTokenAddress := inputToken.Address
+	contractMethod := orby.GetEnvWithDefault("CONTRACT_METHOD", "")
+	contractCalls := orby.GetEnvWithDefault("CONTRACT_CALLS", "")
 
-	// Parse the response into our structured type
-	var response orby.OperationSet
-	if err := json.Unmarshal(result, &response); err != nil {
-		log.Printf("[ERROR] Error parsing orby_GetOperationsToExecuteTransaction response: %v", err)
-		// Try to display raw response
-		var rawResponse any
-		if json.Unmarshal(result, &rawResponse) == nil {
-			fmt.Printf("          Raw response: %v\n", rawResponse)
-		}
+	// The transaction is requested through the virtual node of the input token's chain
+	node, err := g.VirtualNodes.ForChain(g.AccountClusterId, inputTokenChainId)
+	if err != nil {
 		return err
 	}
 
-	if response.Status == "" {
-		var errorResponse orby.ErrorResponse
-		if err := json.Unmarshal(result, &errorResponse); err == nil {
-			fmt.Printf("\n[ERROR] Failed to get operations to execute transaction:")
-			fmt.Printf("\n          Code: %v", errorResponse.Code)
-			fmt.Printf("\n          Message: %s", errorResponse.Message)
+	// 1. Format operation request
+	var call *orby.ContractCall
+	if invalidateNonces := orby.GetEnvWithDefault("PERMIT2_INVALIDATE_NONCES", ""); invalidateNonces != "" {
+		call, err = g.GetInvalidateNoncesParams(invalidateNonces)
+		if err != nil {
 			return err
 		}
-		return err
-	}
-
-	fmt.Printf("\n[INFO] Operations To Execute Transaction Response:\n")
-	fmt.Printf("        Status: %s\n", response.Status)
-	fmt.Printf("        Estimated Time: %d ms\n", response.AggregateEstimatedTimeInMs)
-
-	// 3. Sign and send the operations
-	if len(response.Intents) > 0 {
-		fmt.Printf("        Number of Operations: %d\n", len(response.Intents[0].IntentOperations))
-
-		// Collection of signed operations to send
-		var signedOperations []orby.SignedOperation
-
-		// Display each operation and sign based on format
-		for i, op := range response.Intents[0].IntentOperations {
-			fmt.Printf("\n        Operation %d:\n", i+1)
-			fmt.Printf("          Type: %s\n", op.Type)
-			fmt.Printf("          Format: %s\n", op.Format)
-			fmt.Printf("          From: %s\n", op.From)
-			fmt.Printf("          To: %s\n", op.To)
-			fmt.Printf("          Chain ID: %s\n", op.ChainId)
-			fmt.Printf("          TX RPC URL: %s\n", op.TxRpcUrl)
-
-			// Sign the operation based on its format
-			var signature string
-			var signErr error
-
-			if op.Format == "TYPED_DATA" {
-				// For TYPED_DATA operations, use signTypedData
-				signature, signErr = orby.SignTypedData(op)
-				if signErr != nil {
-					log.Printf("[ERROR] Error signing typed data: %v", signErr)
-					continue
-				}
-				fmt.Printf("          Signed TYPED_DATA: %s\n", signature)
-			} else if op.Format == "TRANSACTION" {
-				// For TRANSACTION operations, use signTransaction
-				signature, signErr = orby.SignTransaction(op)
-				if signErr != nil {
-					log.Printf("[ERROR] Error signing transaction: %v", signErr)
-					continue
-				}
-				fmt.Printf("          Signed TRANSACTION: %s\n", signature)
-			} else {
-				fmt.Printf("          Unknown format, no signature generated\n")
-				continue
-			}
-
-			// Create a signed operation for sending
-			signedOp := orby.SignedOperation{
-				Type:      op.Type,
-				Signature: signature,
-				Data:      op.Data,
-				ChainId:   op.ChainId,
-				From:      op.From,
-			}
-
-			signedOperations = append(signedOperations, signedOp)
+	} else if contractCalls != "" {
+		call, err = g.GetBatchParams(
+			contractCalls,
+			orby.GetEnvWithDefault("BATCH_MODE", string(orby.BatchModeAccount)),
+			orby.GetEnvWithDefault("BATCH_ACCOUNT_ADDRESS", orby.GetEnvWithDefault("SMART_ACCOUNT_ADDRESS", "")))
+		if err != nil {
+			return err
+		}
+	} else if contractMethod == "" {
+		// Default to an ERC20 transfer of AMOUNT to ourselves
+		amount, decimals, err := node.ParseTokenAmount(
+			orby.GetEnvWithDefault("AMOUNT", "0"),
+			inputTokenAddress,
+			inputToken.Symbol,
+			orby.GetEnvWithDefault("INPUT_TOKEN_DECIMALS", inputToken.DecimalsString()))
+		if err != nil {
+			return err
+		}
+		if decimals >= 0 {
+			fmt.Printf("\n[INFO] Amount: %s (%s base units)\n", amount.Format(decimals), amount.String())
 		}
 
-		// If we have signed operations, send them
-		if len(signedOperations) > 0 {
-			fmt.Printf("\nSending %d signed operations to orby_sendSignedOperations...\n", len(signedOperations))
-
-			// Send the signed operations
-			sendResult, sendErr := g.VirtualNodeProvider.SendSignedOperations(signedOperations, g.AccountClusterId)
-			if sendErr != nil {
-				log.Printf("[ERROR] Error sending signed operations: %v", sendErr)
-			} else {
-				// Parse and display the send result
-				var sendResponse any
-				if jsonErr := json.Unmarshal(sendResult, &sendResponse); jsonErr != nil {
-					log.Printf("[ERROR] Error parsing orby_sendSignedOperations response: %v", jsonErr)
-					// Try to display raw response
-					fmt.Printf("[INFO] Raw response: %s\n", string(sendResult))
-				} else {
-					jsonFormatted, _ := json.MarshalIndent(sendResponse, "", "  ")
-					fmt.Printf("\n[INFO] Signed operations sent successfully:\n%s\n", string(jsonFormatted))
-				}
-			}
+		call, err = g.GetParams(inputTokenAddress, amount)
+		if err != nil {
+			return err
+		}
+	} else {
+		call, err = g.GetContractCallParams(
+			orby.GetEnvWithDefault("CONTRACT_ADDRESS", inputTokenAddress),
+			orby.GetEnvWithDefault("CONTRACT_ABI", ""),
+			contractMethod,
+			orby.GetEnvWithDefault("CONTRACT_ARGS", ""),
+			orby.GetEnvWithDefault("CONTRACT_VALUE", ""))
+		if err != nil {
+			return err
 		}
 	}
 
-	return nil
-}
-
-func (g *GetOperationsToExecuteTransaction) GetParams(amount string) (string, error) {
-	// 1. Get ERC20 abi
-	cwd, err := os.Getwd()
+	data, err := call.Pack()
 	if err != nil {
-		fmt.Println("Error getting current directory:", err)
+		return err
 	}
-	fmt.Println("Current working directory:", cwd)
+	fmt.Printf("\n[INFO] Contract call:\n")
+	fmt.Printf("        To: %s\n", call.To)
+	fmt.Printf("        Method: %s\n", call.ABI.Methods[call.Method].Sig)
+	fmt.Printf("        Value: %s\n", call.Value.String())
+	fmt.Printf("        Data: %s\n", data)
 
-	erc20AbiJson, err := os.ReadFile("src/abi/erc20.json")
+	// 2. Sign and send the operations, refreshing the quote if it expires before sending
+	quoteOptions, err := orby.QuoteOptionsFromEnv()
 	if err != nil {
-		log.Fatalf("Failed to read ABI file: %v", err)
+		return err
 	}
+	executor := orby.NewOperationExecutor(node, g.AccountClusterId)
+	executor.QuoteOptions = quoteOptions
+	executor.VirtualNodes = g.VirtualNodes
+
+	_, err = executor.Execute(func() (*orby.OperationSet, error) {
+		// Call operation
+		fmt.Println("\n[INFO] calling GetOperationsToExecuteTransaction...")
+		result, err := node.GetOperationsToExecuteContractCall(g.AccountClusterId, call)
+		if err != nil {
+			log.Printf("[ERROR] Error getting operations to execute transaction: %v", err)
+			return nil, err
+		}
+
+		return orby.ParseOperationSet(result, "orby_getOperationsToExecuteTransaction")
+	})
 
-	erc20Abi, err := abi.JSON(bytes.NewReader(erc20AbiJson))
+	return err
+}
+
+// GetParams builds an ERC20 transfer of amount to the address derived from the private key
+func (g *GetOperationsToExecuteTransaction) GetParams(tokenAddress string, amount orby.Amount) (*orby.ContractCall, error) {
+	// 1. Get ERC20 abi
+	registry, err := orby.DefaultABIRegistry()
 	if err != nil {
-		log.Fatalf("Failed to parse ABI: %v", err)
+		return nil, err
+	}
+	erc20Abi, err := registry.Get(orby.ABINameERC20)
+	if err != nil {
+		return nil, err
 	}
 
 	// 2. Get private key from environment variable to derive address
 	privateKey := orby.GetPrivateKey()
 	if privateKey == nil {
-		log.Fatalf("[ERROR] Failed to get private key: %v", err)
+		return nil, fmt.Errorf("failed to get private key")
 	}
 
 	// 3. Get public key
 	publicKey := privateKey.Public()
 	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
 	if !ok {
-		log.Fatal("[ERROR] Error casting public key to ECDSA")
+		return nil, fmt.Errorf("error casting public key to ECDSA")
 	}
 
 	// 4. Get recipient address from public key
 	address := crypto.PubkeyToAddress(*publicKeyECDSA)
 	fmt.Printf("\n[INFO] Derived address from private key: %s\n", address)
 
-	// 5. Convert amount to bigint
-	bigIntValue := new(big.Int)
-	bigIntValue, ok = bigIntValue.SetString(amount, 10) // Base 10 for decimal numbers
-	if !ok {
-		log.Fatal("Error converting amount to big.Int")
+	// 5. Build the transfer call
+	return &orby.ContractCall{
+		To:     tokenAddress,
+		ABI:    erc20Abi,
+		Method: "transfer",
+		Args:   []any{address, amount.BigInt()},
+	}, nil
+}
+
+// GetContractCallParams builds a call to any contract method.
+// method is either a method name in the ABI named abiName (a registered ABI such as "erc20", or a
+// path to an ABI file) or, if abiName is empty, a human-readable signature such as "approve(address,uint256)".
+// args is a JSON array of arguments and value is the native amount to send, in wei or in ETH with a
+// decimal point (e.g. "0.1").
+func (g *GetOperationsToExecuteTransaction) GetContractCallParams(
+	to string,
+	abiName string,
+	method string,
+	args string,
+	value string) (*orby.ContractCall, error) {
+	// 1. Get the ABI registry
+	registry, err := orby.DefaultABIRegistry()
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Parse the arguments
+	parsedArgs, err := orby.ParseJSONArguments(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. Build the call
+	spec := orby.ContractCallSpec{
+		To:     to,
+		ABI:    abiName,
+		Method: method,
+		Args:   parsedArgs,
+		Value:  value,
+	}
+	return spec.Build(registry)
+}
+
+// GetBatchParams bundles several calls into a single call.
+// calls is a JSON array of orby.ContractCallSpec, or a path to a file containing one.
+func (g *GetOperationsToExecuteTransaction) GetBatchParams(
+	calls string,
+	batchMode string,
+	batchAccount string) (*orby.ContractCall, error) {
+	// 1. Get the ABI registry
+	registry, err := orby.DefaultABIRegistry()
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Parse and build each call
+	specs, err := orby.ParseContractCallSpecs(calls)
+	if err != nil {
+		return nil, err
+	}
+
+	contractCalls := make([]*orby.ContractCall, len(specs))
+	for i, spec := range specs {
+		contractCalls[i], err = spec.Build(registry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid call %d: %v", i+1, err)
+		}
+		fmt.Printf("\n[INFO] Call %d: %s on %s\n", i+1, contractCalls[i].ABI.Methods[contractCalls[i].Method].Sig, spec.To)
 	}
 
-	// 6. Encode transaction data
-	data, err := erc20Abi.Pack("transfer", address, bigIntValue)
+	// 3. Bundle the calls
+	if orby.BatchMode(batchMode) == orby.BatchModeAccount && batchAccount == "" {
+		return nil, fmt.Errorf("batch mode %s requires BATCH_ACCOUNT_ADDRESS or SMART_ACCOUNT_ADDRESS; use BATCH_MODE=%s only for permits signed by their owner",
+			orby.BatchModeAccount, orby.BatchModeMulticall3)
+	}
+	return orby.BundleCalls(contractCalls, orby.BatchMode(batchMode), batchAccount, registry)
+}
+
+// GetInvalidateNoncesParams builds a call invalidating a comma separated list of Permit2 nonces.
+// Permit2 invalidates nonces of msg.sender, so the nonces must share a bitmap word (nonce >> 8)
+// and cannot be bundled through Multicall3.
+func (g *GetOperationsToExecuteTransaction) GetInvalidateNoncesParams(nonceList string) (*orby.ContractCall, error) {
+	// 1. Parse the nonces
+	var nonces []*big.Int
+	for _, nonceStr := range strings.Split(nonceList, ",") {
+		nonce, ok := new(big.Int).SetString(strings.TrimSpace(nonceStr), 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid Permit2 nonce: %s", nonceStr)
+		}
+		nonces = append(nonces, nonce)
+	}
+
+	// 2. Build one invalidateUnorderedNonces call per bitmap word
+	calls, err := orby.InvalidateNoncesCalls(nonces)
 	if err != nil {
-		log.Fatalf("Failed to encode transfer data: %v", err)
+		return nil, err
+	}
+	if len(calls) > 1 {
+		return nil, fmt.Errorf("nonces span %d Permit2 bitmap words; invalidate the nonces of one word (nonce >> 8) at a time", len(calls))
 	}
-	dataHex := hexutil.Encode(data)
 
-	return dataHex, nil
+	return calls[0], nil
 }
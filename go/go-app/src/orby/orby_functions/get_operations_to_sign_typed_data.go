@@ -2,206 +2,369 @@ package orbyfunctions
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"strconv"
+	"time"
 
+	"go-app/src/caip"
 	"go-app/src/orby"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
 
 type GetOperationsToSignTypedData struct {
-	VirtualNodeProvider orby.OrbyClient
-	AccountClusterId    string
+	VirtualNodes     *orby.VirtualNodePool
+	AccountClusterId string
+
+	// The Permit2 nonce reserved for this run, released if nothing is sent
+	nonceManager  *orby.Permit2NonceManager
+	nonceOwner    common.Address
+	reservedNonce *big.Int
 }
 
-func NewGetOperationsToSignTypedData(client orby.OrbyClient, accountClusterId string) *GetOperationsToSignTypedData {
+func NewGetOperationsToSignTypedData(virtualNodes *orby.VirtualNodePool, accountClusterId string) *GetOperationsToSignTypedData {
 	return &GetOperationsToSignTypedData{
-		VirtualNodeProvider: client,
-		AccountClusterId:    accountClusterId,
+		VirtualNodes:     virtualNodes,
+		AccountClusterId: accountClusterId,
 	}
 }
 
-func (g *GetOperationsToSignTypedData) Run() error {
-	// 0. Check for env variables
-	inputTokenAddress := orby.GetEnvWithDefault("INPUT_TOKEN_ADDRESS", "")
-	inputTokenChainId, err := strconv.ParseInt(orby.GetEnvWithDefault("INPUT_TOKEN_CHAIN_ID", ""), 10, 64)
+func (g *GetOperationsToSignTypedData) Run() (err error) {
+	// A reserved nonce that was not sent can be handed out again. Once the signed operations may
+	// have reached Orby, the nonce stays reserved until it is used on-chain.
+	defer func() {
+		if err != nil && !errors.Is(err, orby.ErrSendFailed) {
+			g.releaseNonce()
+		}
+	}()
+
+	// 1. Format operation request: arbitrary typed data from TYPED_DATA, or a permit for the input token
+	var data *apitypes.TypedData
+	if typedDataInput := orby.GetEnvWithDefault("TYPED_DATA", ""); typedDataInput != "" {
+		data, err = g.GetTypedDataParams(typedDataInput)
+	} else {
+		data, err = g.GetPermitParams()
+	}
 	if err != nil {
 		return err
 	}
-	amount, err := strconv.ParseInt(orby.GetEnvWithDefault("AMOUNT", "0"), 10, 64)
+
+	// The operations are requested through the virtual node of the typed data's chain, if it has one
+	node, err := g.virtualNode(data)
 	if err != nil {
 		return err
 	}
 
-	// 1. Format operation request
-	data, err := g.GetParams(inputTokenAddress, inputTokenChainId, amount)
+	// 2. Sign and send the operations, refreshing the quote if it expires before sending
+	quoteOptions, err := orby.QuoteOptionsFromEnv()
 	if err != nil {
 		return err
 	}
+	executor := orby.NewOperationExecutor(node, g.AccountClusterId)
+	executor.QuoteOptions = quoteOptions
+	executor.VirtualNodes = g.VirtualNodes
 
-	// 2. Call operation
-	fmt.Println("\n[INFO] calling GetOperationsToSignTypedData...")
-	result, err := g.VirtualNodeProvider.GetOperationsToSignTypedData(
-		g.AccountClusterId,
-		data)
+	_, err = executor.Execute(func() (*orby.OperationSet, error) {
+		// Call operation
+		fmt.Println("\n[INFO] calling GetOperationsToSignTypedData...")
+		result, err := node.GetOperationsToSignTypedData(
+			g.AccountClusterId,
+			data)
+		if err != nil {
+			log.Printf("[ERROR] Error getting operations to sign typed data: %v", err)
+			return nil, err
+		}
+
+		return orby.ParseOperationSet(result, "orby_getOperationsToSignTypedData")
+	})
+
+	return err
+}
+
+// virtualNode returns the client of the virtual node on the typed data's domain chain, or the default one
+func (g *GetOperationsToSignTypedData) virtualNode(data *apitypes.TypedData) (*orby.OrbyClient, error) {
+	if data.Domain.ChainId == nil {
+		return g.VirtualNodes.Default(g.AccountClusterId)
+	}
+	chainId, err := caip.ParseChainID((*big.Int)(data.Domain.ChainId).String())
 	if err != nil {
-		log.Printf("[ERROR] Error getting operations to sign typed data: %v", err)
+		return nil, err
 	}
+	return g.VirtualNodes.ForChain(g.AccountClusterId, chainId)
+}
 
-	// Parse the response into our structured type
-	var response orby.OperationSet
-	if err := json.Unmarshal(result, &response); err != nil {
-		log.Printf("[ERROR] Error parsing orby_GetOperationsToSignTypedData response: %v", err)
-		// Try to display raw response
-		var rawResponse any
-		if json.Unmarshal(result, &rawResponse) == nil {
-			fmt.Printf("          Raw response: %v\n", rawResponse)
-		}
-		return err
+// GetTypedDataParams loads and validates typed data given as JSON or a path to a JSON file
+func (g *GetOperationsToSignTypedData) GetTypedDataParams(input string) (*apitypes.TypedData, error) {
+	typedData, err := orby.LoadTypedData(input)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := orby.TypedDataHash(typedData)
+	if err != nil {
+		return nil, err
+	}
+	domainJson, err := json.Marshal(typedData.Domain)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("\n[INFO] Typed data %s:\n", typedData.PrimaryType)
+	fmt.Printf("        Domain: %s\n", domainJson)
+	fmt.Printf("        EIP-712 Hash: %s\n", hash.Hex())
+
+	return typedData, nil
+}
+
+// GetPermitParams builds the permit selected by PERMIT_TYPE (permit2, erc2612 or dai) for the input token
+func (g *GetOperationsToSignTypedData) GetPermitParams() (*apitypes.TypedData, error) {
+	// 1. Check for env variables
+	inputTokenChainId, err := orby.GetChainIdFromEnv("INPUT_TOKEN_CHAIN_ID")
+	if err != nil {
+		return nil, err
+	}
+	inputToken, err := orby.ResolveTokenFromEnv("INPUT_TOKEN_ADDRESS", inputTokenChainId)
+	if err != nil {
+		return nil, err
+	}
+	inputTokenAddress := inputToken.Address
+	node, err := g.VirtualNodes.ForChain(g.AccountClusterId, inputTokenChainId)
+	if err != nil {
+		return nil, err
+	}
+	amount, decimals, err := node.ParseTokenAmount(
+		orby.GetEnvWithDefault("AMOUNT", "0"),
+		inputTokenAddress,
+		inputToken.Symbol,
+		orby.GetEnvWithDefault("INPUT_TOKEN_DECIMALS", inputToken.DecimalsString()))
+	if err != nil {
+		return nil, err
+	}
+	if decimals >= 0 {
+		fmt.Printf("\n[INFO] Amount: %s (%s base units)\n", amount.Format(decimals), amount.String())
 	}
 
-	if response.Status == "" {
-		var errorResponse orby.ErrorResponse
-		if err := json.Unmarshal(result, &errorResponse); err == nil {
-			fmt.Printf("\n[ERROR] Failed to get operations to sign typed data")
-			fmt.Printf("\n          Code: %v", errorResponse.Code)
-			fmt.Printf("\n          Message: %s", errorResponse.Message)
-			return err
+	// 2. Build the permit
+	switch permitType := orby.GetEnvWithDefault("PERMIT_TYPE", "permit2"); permitType {
+	case "permit2":
+		return g.GetParams(inputTokenAddress, inputTokenChainId, amount)
+	case string(orby.PermitKindERC2612), string(orby.PermitKindDAI):
+		return g.GetTokenPermitParams(orby.PermitKind(permitType), inputTokenAddress, inputTokenChainId, amount)
+	default:
+		return nil, fmt.Errorf("unknown PERMIT_TYPE %q (expected permit2, %s or %s)", permitType, orby.PermitKindERC2612, orby.PermitKindDAI)
+	}
+}
+
+// GetParams builds a Permit2 PermitTransferFrom (or PermitWitnessTransferFrom) for the input token.
+// The spender comes from PERMIT2_SPENDER, the deadline from PERMIT2_DEADLINE (a unix timestamp,
+// defaulting to 30 minutes from now), the nonce from PERMIT2_NONCE (reserved from the nonce manager
+// if unset) and an optional witness from PERMIT2_WITNESS (JSON or a path to a JSON file).
+func (g *GetOperationsToSignTypedData) GetParams(
+	inputTokenAddress string,
+	inputTokenChainId caip.ChainID,
+	amount orby.Amount) (*apitypes.TypedData, error) {
+	// 1. Get Permit2 inputs
+	spender := orby.GetEnvWithDefault("PERMIT2_SPENDER", "")
+	if spender == "" {
+		return nil, fmt.Errorf("PERMIT2_SPENDER is required: set it to the address allowed to transfer the tokens")
+	}
+
+	deadline := big.NewInt(time.Now().Add(30 * time.Minute).Unix())
+	if deadlineStr := orby.GetEnvWithDefault("PERMIT2_DEADLINE", ""); deadlineStr != "" {
+		var ok bool
+		deadline, ok = new(big.Int).SetString(deadlineStr, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid PERMIT2_DEADLINE: %s", deadlineStr)
 		}
-		return err
 	}
 
-	fmt.Printf("\n[INFO] Operations To Sign Typed Data Response:\n")
-	fmt.Printf("        Status: %s\n", response.Status)
-	fmt.Printf("        Estimated Time: %d ms\n", response.AggregateEstimatedTimeInMs)
-
-	// 3. Sign and send the operations
-	if len(response.Intents) > 0 {
-		fmt.Printf("        Number of Operations: %d\n", len(response.Intents[0].IntentOperations))
-
-		// Collection of signed operations to send
-		var signedOperations []orby.SignedOperation
-
-		// Display each operation and sign based on format
-		for i, op := range response.Intents[0].IntentOperations {
-			fmt.Printf("\n        Operation %d:\n", i+1)
-			fmt.Printf("          Type: %s\n", op.Type)
-			fmt.Printf("          Format: %s\n", op.Format)
-			fmt.Printf("          From: %s\n", op.From)
-			fmt.Printf("          To: %s\n", op.To)
-			fmt.Printf("          Chain ID: %s\n", op.ChainId)
-			fmt.Printf("          TX RPC URL: %s\n", op.TxRpcUrl)
-
-			// Sign the operation based on its format
-			var signature string
-			var signErr error
-
-			if op.Format == "TYPED_DATA" {
-				// For TYPED_DATA operations, use signTypedData
-				signature, signErr = orby.SignTypedData(op)
-				if signErr != nil {
-					log.Printf("[ERROR] Error signing typed data: %v", signErr)
-					continue
-				}
-				fmt.Printf("          Signed TYPED_DATA: %s\n", signature)
-			} else if op.Format == "TRANSACTION" {
-				// For TRANSACTION operations, use signTransaction
-				signature, signErr = orby.SignTransaction(op)
-				if signErr != nil {
-					log.Printf("[ERROR] Error signing transaction: %v", signErr)
-					continue
-				}
-				fmt.Printf("          Signed TRANSACTION: %s\n", signature)
-			} else {
-				fmt.Printf("          Unknown format, no signature generated\n")
-				continue
-			}
-
-			// Create a signed operation for sending
-			signedOp := orby.SignedOperation{
-				Type:      op.Type,
-				Signature: signature,
-				Data:      op.Data,
-				ChainId:   op.ChainId,
-				From:      op.From,
-			}
-
-			signedOperations = append(signedOperations, signedOp)
+	var nonce *big.Int
+	if nonceStr := orby.GetEnvWithDefault("PERMIT2_NONCE", ""); nonceStr != "" {
+		var ok bool
+		nonce, ok = new(big.Int).SetString(nonceStr, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid PERMIT2_NONCE: %s", nonceStr)
 		}
+	} else {
+		// Reserve the lowest nonce that is unused on-chain and not handed out to another run
+		var err error
+		nonce, err = g.ReserveNonce(inputTokenChainId)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		// If we have signed operations, send them
-		if len(signedOperations) > 0 {
-			fmt.Printf("\nSending %d signed operations to orby_sendSignedOperations...\n", len(signedOperations))
-
-			// Send the signed operations
-			sendResult, sendErr := g.VirtualNodeProvider.SendSignedOperations(signedOperations, g.AccountClusterId)
-			if sendErr != nil {
-				log.Printf("[ERROR] Error sending signed operations: %v", sendErr)
-			} else {
-				// Parse and display the send result
-				var sendResponse any
-				if jsonErr := json.Unmarshal(sendResult, &sendResponse); jsonErr != nil {
-					log.Printf("[ERROR] Error parsing orby_sendSignedOperations response: %v", jsonErr)
-					// Try to display raw response
-					fmt.Printf("[INFO] Raw response: %s\n", string(sendResult))
-				} else {
-					jsonFormatted, _ := json.MarshalIndent(sendResponse, "", "  ")
-					fmt.Printf("\n[INFO] Signed operations sent successfully:\n%s\n", string(jsonFormatted))
-				}
-			}
+	var witness *orby.Permit2Witness
+	if witnessInput := orby.GetEnvWithDefault("PERMIT2_WITNESS", ""); witnessInput != "" {
+		var err error
+		witness, err = orby.LoadPermit2Witness(witnessInput)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	chainId, err := inputTokenChainId.BigInt()
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Format Permit2 structure
+	permit, err := orby.BuildPermitTransferFrom(orby.PermitTransferFromParams{
+		ChainId: chainId,
+		Permitted: orby.Permit2TokenPermission{
+			Token:  inputTokenAddress,
+			Amount: amount.BigInt(),
+		},
+		Spender:  spender,
+		Nonce:    nonce,
+		Deadline: deadline,
+		Witness:  witness,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := orby.TypedDataHash(permit)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("\n[INFO] Permit2 %s:\n", permit.PrimaryType)
+	fmt.Printf("        Spender: %s\n", spender)
+	fmt.Printf("        Nonce: %s\n", nonce.String())
+	fmt.Printf("        Deadline: %s\n", deadline.String())
+	fmt.Printf("        EIP-712 Hash: %s\n", hash.Hex())
+
+	return permit, nil
 }
 
-func (g *GetOperationsToSignTypedData) GetParams(
+// GetTokenPermitParams builds an EIP-2612 or DAI-style permit for the input token. The token's domain
+// and the owner's nonce are read through PERMIT_RPC_URL (the virtual node of the token's chain by default) and the domain
+// is checked against the token's DOMAIN_SEPARATOR(). The spender comes from PERMIT_SPENDER and the
+// deadline from PERMIT_DEADLINE (a unix timestamp, defaulting to 30 minutes from now). DAI permits
+// approve the maximum amount, or revoke the allowance if PERMIT_ALLOWED is false.
+func (g *GetOperationsToSignTypedData) GetTokenPermitParams(
+	kind orby.PermitKind,
 	inputTokenAddress string,
-	inputTokenChainId int64,
-	amount int64) (string, error) {
-	// 1. Format Permit2 structure
-	permit2 := orby.Permit2Object{
-		Types: map[string][]orby.EIP712Type{
-			"PermitTransferFrom": {
-				{Name: "permitted", Type: "TokenPermissions"},
-				{Name: "spender", Type: "address"},
-				{Name: "nonce", Type: "uint256"},
-				{Name: "deadline", Type: "uint256"},
-				{Name: "witness", Type: "ExclusiveDutchOrder"},
-			},
-			"TokenPermissions": {
-				{Name: "token", Type: "address"},
-				{Name: "amount", Type: "uint256"},
-			},
-			"EIP712Domain": {
-				{Name: "name", Type: "string"},
-				{Name: "chainId", Type: "uint256"},
-				{Name: "verifyingContract", Type: "address"},
-			},
-		},
-		Domain: orby.EIP712Domain{
-			Name:              "Permit2",
-			ChainId:           big.NewInt(inputTokenChainId),
-			VerifyingContract: "0x000000000022d473030f116ddee9f6b43ac78ba3",
-		},
-		PrimaryType: "PermitTransferFrom",
-		Message: orby.PermitTransferFrom{
-			Permitted: orby.TokenPermissions{
-				Token:  inputTokenAddress,
-				Amount: big.NewInt(amount),
-			},
-			Spender:  "0xSpenderAddressHere",
-			Nonce:    big.NewInt(10),
-			Deadline: big.NewInt(100),
-		},
+	inputTokenChainId caip.ChainID,
+	amount orby.Amount) (*apitypes.TypedData, error) {
+	// 1. Get permit inputs
+	spender := orby.GetEnvWithDefault("PERMIT_SPENDER", "")
+	if spender == "" {
+		return nil, fmt.Errorf("PERMIT_SPENDER is required: set it to the address allowed to transfer the tokens")
+	}
+
+	deadline := big.NewInt(time.Now().Add(30 * time.Minute).Unix())
+	if deadlineStr := orby.GetEnvWithDefault("PERMIT_DEADLINE", ""); deadlineStr != "" {
+		var ok bool
+		deadline, ok = new(big.Int).SetString(deadlineStr, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid PERMIT_DEADLINE: %s", deadlineStr)
+		}
+	}
+
+	allowed, err := strconv.ParseBool(orby.GetEnvWithDefault("PERMIT_ALLOWED", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PERMIT_ALLOWED: %v", err)
+	}
+
+	privateKey := orby.GetPrivateKey()
+	owner := crypto.PubkeyToAddress(privateKey.PublicKey).Hex()
+
+	// 2. Read the token's domain and nonce through the token chain's virtual node and format the permit
+	node, err := g.VirtualNodes.ForChain(g.AccountClusterId, inputTokenChainId)
+	if err != nil {
+		return nil, err
+	}
+	reader := orby.NewPermitTokenReader(
+		node,
+		orby.GetEnvWithDefault("PERMIT_RPC_URL", node.OrbyURL))
+	chainId, err := inputTokenChainId.BigInt()
+	if err != nil {
+		return nil, err
 	}
 
-	jsonBytes, err := json.Marshal(permit2)
+	var permit *apitypes.TypedData
+	if kind == orby.PermitKindDAI {
+		permit, err = reader.BuildDAIPermit(chainId, inputTokenAddress, owner, spender, deadline, allowed)
+	} else {
+		permit, err = reader.BuildERC2612Permit(chainId, inputTokenAddress, owner, spender, amount.BigInt(), deadline)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := orby.TypedDataHash(permit)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("\n[INFO] %s permit:\n", kind)
+	fmt.Printf("        Token: %s (%s, version %q)\n", inputTokenAddress, permit.Domain.Name, permit.Domain.Version)
+	fmt.Printf("        Spender: %s\n", spender)
+	fmt.Printf("        Nonce: %v\n", permit.Message["nonce"])
+	fmt.Printf("        Deadline: %s\n", deadline.String())
+	fmt.Printf("        EIP-712 Hash: %s\n", hash.Hex())
+
+	return permit, nil
+}
+
+// ReserveNonce reserves an unused Permit2 nonce for the signer. The nonce bitmap is read through
+// PERMIT2_RPC_URL (the virtual node of the token's chain by default) and reservations are stored in PERMIT2_NONCE_STORE.
+func (g *GetOperationsToSignTypedData) ReserveNonce(chainId caip.ChainID) (*big.Int, error) {
+	// 1. Get the owner address from the private key
+	privateKey := orby.GetPrivateKey()
+	owner := crypto.PubkeyToAddress(privateKey.PublicKey)
+
+	// 2. Create the nonce manager, reading the bitmap through the chain's virtual node
+	node, err := g.VirtualNodes.ForChain(g.AccountClusterId, chainId)
+	if err != nil {
+		return nil, err
+	}
+	reader := orby.NewRPCNonceBitmapReader(
+		node,
+		orby.GetEnvWithDefault("PERMIT2_RPC_URL", node.OrbyURL))
+	manager, err := orby.NewPermit2NonceManager(
+		reader,
+		chainId,
+		orby.GetEnvWithDefault("PERMIT2_NONCE_STORE", ".orby/permit2-nonces.json"))
 	if err != nil {
-		fmt.Println("Error marshaling permit2 JSON:", err)
-		return "", err
+		return nil, err
 	}
 
-	return string(jsonBytes), nil
+	// 3. Drop reservations whose nonces have been used on-chain since they were reserved
+	pruned, err := manager.Prune(owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prune Permit2 nonce reservations: %v", err)
+	}
+	if pruned > 0 {
+		fmt.Printf("\n[INFO] Pruned %d Permit2 nonce reservations used on-chain\n", pruned)
+	}
+
+	// 4. Reserve a nonce
+	nonce, err := manager.Reserve(owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve Permit2 nonce: %v", err)
+	}
+	fmt.Printf("\n[INFO] Reserved Permit2 nonce %s for %s\n", nonce.String(), owner.Hex())
+
+	g.nonceManager = manager
+	g.nonceOwner = owner
+	g.reservedNonce = nonce
+	return nonce, nil
+}
+
+// releaseNonce releases the nonce reserved by ReserveNonce, if any
+func (g *GetOperationsToSignTypedData) releaseNonce() {
+	if g.reservedNonce == nil {
+		return
+	}
+	if err := g.nonceManager.Release(g.nonceOwner, g.reservedNonce); err != nil {
+		log.Printf("[ERROR] Error releasing Permit2 nonce %s: %v", g.reservedNonce.String(), err)
+	} else {
+		fmt.Printf("\n[INFO] Released Permit2 nonce %s\n", g.reservedNonce.String())
+	}
+	g.reservedNonce = nil
 }
@@ -0,0 +1,435 @@
+// permit2.go builds Permit2 EIP-712 typed data for signature transfers and allowance permits
+package orby
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// Permit2 primary types
+const (
+	Permit2PermitTransferFrom             = "PermitTransferFrom"
+	Permit2PermitBatchTransferFrom        = "PermitBatchTransferFrom"
+	Permit2PermitWitnessTransferFrom      = "PermitWitnessTransferFrom"
+	Permit2PermitBatchWitnessTransferFrom = "PermitBatchWitnessTransferFrom"
+	Permit2PermitSingle                   = "PermitSingle"
+	Permit2PermitBatch                    = "PermitBatch"
+)
+
+// permit2TypeNames are the type names Permit2 defines, which a witness may not use
+var permit2TypeNames = map[string]bool{
+	"EIP712Domain":                        true,
+	"TokenPermissions":                    true,
+	"PermitDetails":                       true,
+	Permit2PermitTransferFrom:             true,
+	Permit2PermitBatchTransferFrom:        true,
+	Permit2PermitWitnessTransferFrom:      true,
+	Permit2PermitBatchWitnessTransferFrom: true,
+	Permit2PermitSingle:                   true,
+	Permit2PermitBatch:                    true,
+}
+
+// Permit2 field type definitions shared by the permit types
+var (
+	permit2DomainType = []apitypes.Type{
+		{Name: "name", Type: "string"},
+		{Name: "chainId", Type: "uint256"},
+		{Name: "verifyingContract", Type: "address"},
+	}
+	permit2TokenPermissionsType = []apitypes.Type{
+		{Name: "token", Type: "address"},
+		{Name: "amount", Type: "uint256"},
+	}
+	permit2PermitDetailsType = []apitypes.Type{
+		{Name: "token", Type: "address"},
+		{Name: "amount", Type: "uint160"},
+		{Name: "expiration", Type: "uint48"},
+		{Name: "nonce", Type: "uint48"},
+	}
+)
+
+// Permit2TokenPermission is a token and amount the spender may transfer
+type Permit2TokenPermission struct {
+	Token  string
+	Amount *big.Int
+}
+
+// Permit2Witness is extra data signed along with a signature transfer, such as an order
+type Permit2Witness struct {
+	// FieldName is the name of the witness field, "witness" if empty
+	FieldName string `json:"fieldName,omitempty"`
+	// TypeName is the EIP-712 type of the witness, e.g. "ExclusiveDutchOrder"
+	TypeName string `json:"typeName"`
+	// Types defines TypeName and any struct types it references
+	Types map[string][]apitypes.Type `json:"types"`
+	// Value is the witness message
+	Value apitypes.TypedDataMessage `json:"value"`
+}
+
+// LoadPermit2Witness parses a witness from JSON, or reads it from a file if input is a path
+func LoadPermit2Witness(input string) (*Permit2Witness, error) {
+	data := []byte(input)
+	if !strings.HasPrefix(strings.TrimSpace(input), "{") {
+		fileData, err := os.ReadFile(input)
+		if err != nil {
+			return nil, fmt.Errorf("witness must be a JSON object or a path to a JSON file: %v", err)
+		}
+		data = fileData
+	}
+
+	var witness Permit2Witness
+	if err := json.Unmarshal(data, &witness); err != nil {
+		return nil, fmt.Errorf("failed to parse witness: %v", err)
+	}
+	if witness.TypeName == "" {
+		return nil, fmt.Errorf("witness is missing typeName")
+	}
+	if _, ok := witness.Types[witness.TypeName]; !ok {
+		return nil, fmt.Errorf("witness types do not define %s", witness.TypeName)
+	}
+	return &witness, nil
+}
+
+func (w *Permit2Witness) fieldName() string {
+	if w.FieldName == "" {
+		return "witness"
+	}
+	return w.FieldName
+}
+
+// PermitTransferFromParams are the inputs of a single-token signature transfer
+type PermitTransferFromParams struct {
+	ChainId   *big.Int
+	Permitted Permit2TokenPermission
+	Spender   string
+	Nonce     *big.Int
+	Deadline  *big.Int
+	Witness   *Permit2Witness
+}
+
+// PermitBatchTransferFromParams are the inputs of a multi-token signature transfer
+type PermitBatchTransferFromParams struct {
+	ChainId   *big.Int
+	Permitted []Permit2TokenPermission
+	Spender   string
+	Nonce     *big.Int
+	Deadline  *big.Int
+	Witness   *Permit2Witness
+}
+
+// Permit2PermitDetails are the allowance details of a single token
+type Permit2PermitDetails struct {
+	Token      string
+	Amount     *big.Int
+	Expiration uint64
+	Nonce      uint64
+}
+
+// PermitSingleParams are the inputs of a single-token allowance permit
+type PermitSingleParams struct {
+	ChainId     *big.Int
+	Details     Permit2PermitDetails
+	Spender     string
+	SigDeadline *big.Int
+}
+
+// PermitBatchParams are the inputs of a multi-token allowance permit
+type PermitBatchParams struct {
+	ChainId     *big.Int
+	Details     []Permit2PermitDetails
+	Spender     string
+	SigDeadline *big.Int
+}
+
+// Permit2Domain returns the Permit2 EIP-712 domain for a chain
+func Permit2Domain(chainId *big.Int) apitypes.TypedDataDomain {
+	return apitypes.TypedDataDomain{
+		Name:              "Permit2",
+		ChainId:           (*math.HexOrDecimal256)(new(big.Int).Set(chainId)),
+		VerifyingContract: common.HexToAddress(Permit2Address).Hex(),
+	}
+}
+
+// BuildPermitTransferFrom builds typed data for SignatureTransfer.permitTransferFrom, or
+// permitWitnessTransferFrom if a witness is given
+func BuildPermitTransferFrom(params PermitTransferFromParams) (*apitypes.TypedData, error) {
+	if err := validatePermit2Common(params.ChainId, params.Spender, params.Nonce, params.Deadline, "deadline"); err != nil {
+		return nil, err
+	}
+	if err := validateTokenPermission(params.Permitted); err != nil {
+		return nil, err
+	}
+
+	primaryType := Permit2PermitTransferFrom
+	if params.Witness != nil {
+		primaryType = Permit2PermitWitnessTransferFrom
+	}
+
+	fields := []apitypes.Type{
+		{Name: "permitted", Type: "TokenPermissions"},
+		{Name: "spender", Type: "address"},
+		{Name: "nonce", Type: "uint256"},
+		{Name: "deadline", Type: "uint256"},
+	}
+	message := apitypes.TypedDataMessage{
+		"permitted": tokenPermissionMessage(params.Permitted),
+		"spender":   common.HexToAddress(params.Spender).Hex(),
+		"nonce":     params.Nonce.String(),
+		"deadline":  params.Deadline.String(),
+	}
+
+	return buildPermit2TypedData(params.ChainId, primaryType, fields, message, params.Witness)
+}
+
+// BuildPermitBatchTransferFrom builds typed data for SignatureTransfer.permitTransferFrom with
+// several tokens, or permitWitnessTransferFrom if a witness is given
+func BuildPermitBatchTransferFrom(params PermitBatchTransferFromParams) (*apitypes.TypedData, error) {
+	if err := validatePermit2Common(params.ChainId, params.Spender, params.Nonce, params.Deadline, "deadline"); err != nil {
+		return nil, err
+	}
+	if len(params.Permitted) == 0 {
+		return nil, fmt.Errorf("batch transfer requires at least one token")
+	}
+
+	permitted := make([]interface{}, len(params.Permitted))
+	for i, permission := range params.Permitted {
+		if err := validateTokenPermission(permission); err != nil {
+			return nil, fmt.Errorf("token %d: %v", i+1, err)
+		}
+		permitted[i] = tokenPermissionMessage(permission)
+	}
+
+	primaryType := Permit2PermitBatchTransferFrom
+	if params.Witness != nil {
+		primaryType = Permit2PermitBatchWitnessTransferFrom
+	}
+
+	fields := []apitypes.Type{
+		{Name: "permitted", Type: "TokenPermissions[]"},
+		{Name: "spender", Type: "address"},
+		{Name: "nonce", Type: "uint256"},
+		{Name: "deadline", Type: "uint256"},
+	}
+	message := apitypes.TypedDataMessage{
+		"permitted": permitted,
+		"spender":   common.HexToAddress(params.Spender).Hex(),
+		"nonce":     params.Nonce.String(),
+		"deadline":  params.Deadline.String(),
+	}
+
+	return buildPermit2TypedData(params.ChainId, primaryType, fields, message, params.Witness)
+}
+
+// BuildPermitSingle builds typed data for AllowanceTransfer.permit with a single token
+func BuildPermitSingle(params PermitSingleParams) (*apitypes.TypedData, error) {
+	if err := validatePermit2Common(params.ChainId, params.Spender, big.NewInt(0), params.SigDeadline, "sigDeadline"); err != nil {
+		return nil, err
+	}
+	if err := validatePermitDetails(params.Details); err != nil {
+		return nil, err
+	}
+
+	typedData := &apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain":  permit2DomainType,
+			"PermitDetails": permit2PermitDetailsType,
+			Permit2PermitSingle: {
+				{Name: "details", Type: "PermitDetails"},
+				{Name: "spender", Type: "address"},
+				{Name: "sigDeadline", Type: "uint256"},
+			},
+		},
+		PrimaryType: Permit2PermitSingle,
+		Domain:      Permit2Domain(params.ChainId),
+		Message: apitypes.TypedDataMessage{
+			"details":     permitDetailsMessage(params.Details),
+			"spender":     common.HexToAddress(params.Spender).Hex(),
+			"sigDeadline": params.SigDeadline.String(),
+		},
+	}
+	return typedData, nil
+}
+
+// BuildPermitBatch builds typed data for AllowanceTransfer.permit with several tokens
+func BuildPermitBatch(params PermitBatchParams) (*apitypes.TypedData, error) {
+	if err := validatePermit2Common(params.ChainId, params.Spender, big.NewInt(0), params.SigDeadline, "sigDeadline"); err != nil {
+		return nil, err
+	}
+	if len(params.Details) == 0 {
+		return nil, fmt.Errorf("batch permit requires at least one token")
+	}
+
+	details := make([]interface{}, len(params.Details))
+	for i, detail := range params.Details {
+		if err := validatePermitDetails(detail); err != nil {
+			return nil, fmt.Errorf("token %d: %v", i+1, err)
+		}
+		details[i] = permitDetailsMessage(detail)
+	}
+
+	typedData := &apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain":  permit2DomainType,
+			"PermitDetails": permit2PermitDetailsType,
+			Permit2PermitBatch: {
+				{Name: "details", Type: "PermitDetails[]"},
+				{Name: "spender", Type: "address"},
+				{Name: "sigDeadline", Type: "uint256"},
+			},
+		},
+		PrimaryType: Permit2PermitBatch,
+		Domain:      Permit2Domain(params.ChainId),
+		Message: apitypes.TypedDataMessage{
+			"details":     details,
+			"spender":     common.HexToAddress(params.Spender).Hex(),
+			"sigDeadline": params.SigDeadline.String(),
+		},
+	}
+	return typedData, nil
+}
+
+// Permit2WitnessTypeString returns the witnessTypeString argument Permit2's permitWitnessTransferFrom
+// expects for typed data built with a witness, e.g.
+// "ExclusiveDutchOrder witness)ExclusiveDutchOrder(...)TokenPermissions(address token,uint256 amount)"
+func Permit2WitnessTypeString(typedData *apitypes.TypedData) (string, error) {
+	primaryType := typedData.PrimaryType
+	if primaryType != Permit2PermitWitnessTransferFrom && primaryType != Permit2PermitBatchWitnessTransferFrom {
+		return "", fmt.Errorf("typed data has no witness (primary type %s)", primaryType)
+	}
+
+	fields := typedData.Types[primaryType]
+	if len(fields) != 5 {
+		return "", fmt.Errorf("unexpected %s fields", primaryType)
+	}
+
+	// The full type is "<primaryType>(<permit fields>,<witness type> <witness name>)<referenced types>",
+	// and Permit2 hashes a fixed stub for everything up to the witness field
+	stub := primaryType + "("
+	for _, field := range fields[:4] {
+		stub += field.Type + " " + field.Name + ","
+	}
+
+	encodedType := string(typedData.EncodeType(primaryType))
+	if !strings.HasPrefix(encodedType, stub) {
+		return "", fmt.Errorf("unexpected encoded type %s", encodedType)
+	}
+	return strings.TrimPrefix(encodedType, stub), nil
+}
+
+// TypedDataHash returns the EIP-712 digest of the typed data, i.e. the hash that is signed
+func TypedDataHash(typedData *apitypes.TypedData) (common.Hash, error) {
+	domainSeparator, err := typedData.HashStruct("EIP712Domain", typedData.Domain.Map())
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to create domain separator: %v", err)
+	}
+
+	typedDataHash, err := typedData.HashStruct(typedData.PrimaryType, typedData.Message)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to hash struct data: %v", err)
+	}
+
+	rawData := []byte(fmt.Sprintf("\x19\x01%s%s", string(domainSeparator), string(typedDataHash)))
+	return crypto.Keccak256Hash(rawData), nil
+}
+
+func buildPermit2TypedData(
+	chainId *big.Int,
+	primaryType string,
+	fields []apitypes.Type,
+	message apitypes.TypedDataMessage,
+	witness *Permit2Witness) (*apitypes.TypedData, error) {
+	types := apitypes.Types{
+		"EIP712Domain":     permit2DomainType,
+		"TokenPermissions": permit2TokenPermissionsType,
+	}
+
+	if witness != nil {
+		// Permit2 hashes its own types, so the witness may not define or redefine any of them
+		for name, typeFields := range witness.Types {
+			if permit2TypeNames[name] {
+				return nil, fmt.Errorf("witness type %s conflicts with a Permit2 type", name)
+			}
+			types[name] = typeFields
+		}
+		fields = append(fields, apitypes.Type{Name: witness.fieldName(), Type: witness.TypeName})
+		message[witness.fieldName()] = map[string]interface{}(witness.Value)
+	}
+	types[primaryType] = fields
+
+	typedData := &apitypes.TypedData{
+		Types:       types,
+		PrimaryType: primaryType,
+		Domain:      Permit2Domain(chainId),
+		Message:     message,
+	}
+
+	// Make sure the message, including the witness, can be hashed
+	if _, err := typedData.HashStruct(primaryType, message); err != nil {
+		return nil, fmt.Errorf("invalid %s message: %v", primaryType, err)
+	}
+
+	return typedData, nil
+}
+
+func validatePermit2Common(chainId *big.Int, spender string, nonce *big.Int, deadline *big.Int, deadlineName string) error {
+	if chainId == nil || chainId.Sign() <= 0 {
+		return fmt.Errorf("chain ID is required")
+	}
+	if !common.IsHexAddress(spender) {
+		return fmt.Errorf("invalid spender address: %q", spender)
+	}
+	if nonce == nil || nonce.Sign() < 0 {
+		return fmt.Errorf("nonce is required")
+	}
+	if deadline == nil || deadline.Sign() <= 0 {
+		return fmt.Errorf("%s is required", deadlineName)
+	}
+	return nil
+}
+
+func validateTokenPermission(permission Permit2TokenPermission) error {
+	if !common.IsHexAddress(permission.Token) {
+		return fmt.Errorf("invalid token address: %q", permission.Token)
+	}
+	if permission.Amount == nil || permission.Amount.Sign() < 0 {
+		return fmt.Errorf("invalid amount for token %s", permission.Token)
+	}
+	return nil
+}
+
+func validatePermitDetails(details Permit2PermitDetails) error {
+	if !common.IsHexAddress(details.Token) {
+		return fmt.Errorf("invalid token address: %q", details.Token)
+	}
+	if details.Amount == nil || details.Amount.Sign() < 0 || details.Amount.BitLen() > 160 {
+		return fmt.Errorf("amount for token %s must fit in uint160", details.Token)
+	}
+	if details.Expiration >= 1<<48 || details.Nonce >= 1<<48 {
+		return fmt.Errorf("expiration and nonce for token %s must fit in uint48", details.Token)
+	}
+	return nil
+}
+
+func tokenPermissionMessage(permission Permit2TokenPermission) map[string]interface{} {
+	return map[string]interface{}{
+		"token":  common.HexToAddress(permission.Token).Hex(),
+		"amount": permission.Amount.String(),
+	}
+}
+
+func permitDetailsMessage(details Permit2PermitDetails) map[string]interface{} {
+	return map[string]interface{}{
+		"token":      common.HexToAddress(details.Token).Hex(),
+		"amount":     details.Amount.String(),
+		"expiration": new(big.Int).SetUint64(details.Expiration).String(),
+		"nonce":      new(big.Int).SetUint64(details.Nonce).String(),
+	}
+}
@@ -0,0 +1,480 @@
+// permit2_nonces.go allocates Permit2 unordered nonces based on the on-chain nonce bitmap
+package orby
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-app/src/caip"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Permit2 nonces are split into a 248-bit word position and an 8-bit bit position.
+// Bit n of nonceBitmap(owner, wordPos) is set once nonce (wordPos << 8 | n) is used.
+const permit2NonceBitsPerWord = 256
+
+// maxNonceWordScan bounds how many bitmap words Reserve reads before giving up
+const maxNonceWordScan = 1024
+
+// The nonce store lock is a lock file next to the store. Waiting for it times out after
+// nonceStoreLockTimeout. Its holder touches it every nonceStoreLockRefresh, however long its bitmap
+// reads take, so a lock file older than nonceStoreLockStale is left by a process that died and is removed.
+const (
+	nonceStoreLockTimeout = 30 * time.Second
+	nonceStoreLockStale   = 2 * time.Minute
+	nonceStoreLockRefresh = 15 * time.Second
+	nonceStoreLockRetry   = 20 * time.Millisecond
+)
+
+// NonceBitmapReader reads Permit2's nonceBitmap(owner, wordPos)
+type NonceBitmapReader interface {
+	NonceBitmap(owner common.Address, wordPos *big.Int) (*big.Int, error)
+}
+
+// RPCNonceBitmapReader reads the nonce bitmap from Permit2 with eth_call
+type RPCNonceBitmapReader struct {
+	Client *OrbyClient
+	RpcUrl string
+}
+
+// NewRPCNonceBitmapReader creates a reader that calls Permit2 through the given RPC URL
+func NewRPCNonceBitmapReader(client *OrbyClient, rpcUrl string) *RPCNonceBitmapReader {
+	return &RPCNonceBitmapReader{Client: client, RpcUrl: rpcUrl}
+}
+
+// NonceBitmap calls nonceBitmap(owner, wordPos) on Permit2
+func (r *RPCNonceBitmapReader) NonceBitmap(owner common.Address, wordPos *big.Int) (*big.Int, error) {
+	registry, err := DefaultABIRegistry()
+	if err != nil {
+		return nil, err
+	}
+	permit2Abi, err := registry.Get(ABINamePermit2)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := permit2Abi.Pack("nonceBitmap", owner, wordPos)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.Client.EthCall(r.RpcUrl, Permit2Address, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Permit2 nonce bitmap: %v", err)
+	}
+	return new(big.Int).SetBytes(result), nil
+}
+
+// LocalNonceBitmap is an in-memory stand-in for Permit2's nonce bitmap, for tests and dry runs
+type LocalNonceBitmap struct {
+	mu      sync.Mutex
+	bitmaps map[string]*big.Int
+}
+
+// NewLocalNonceBitmap creates an empty in-memory nonce bitmap
+func NewLocalNonceBitmap() *LocalNonceBitmap {
+	return &LocalNonceBitmap{bitmaps: make(map[string]*big.Int)}
+}
+
+// NonceBitmap returns the bitmap word for owner at wordPos
+func (l *LocalNonceBitmap) NonceBitmap(owner common.Address, wordPos *big.Int) (*big.Int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if bitmap, ok := l.bitmaps[bitmapKey(owner, wordPos)]; ok {
+		return new(big.Int).Set(bitmap), nil
+	}
+	return new(big.Int), nil
+}
+
+// UseNonce marks a nonce as used, like a successful permitTransferFrom would
+func (l *LocalNonceBitmap) UseNonce(owner common.Address, nonce *big.Int) {
+	wordPos, bitPos := SplitPermit2Nonce(nonce)
+	l.InvalidateUnorderedNonces(owner, wordPos, new(big.Int).Lsh(big.NewInt(1), bitPos))
+}
+
+// InvalidateUnorderedNonces sets the mask bits of the bitmap word, like Permit2's invalidateUnorderedNonces
+func (l *LocalNonceBitmap) InvalidateUnorderedNonces(owner common.Address, wordPos *big.Int, mask *big.Int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := bitmapKey(owner, wordPos)
+	bitmap, ok := l.bitmaps[key]
+	if !ok {
+		bitmap = new(big.Int)
+		l.bitmaps[key] = bitmap
+	}
+	bitmap.Or(bitmap, mask)
+}
+
+func bitmapKey(owner common.Address, wordPos *big.Int) string {
+	return strings.ToLower(owner.Hex()) + ":" + wordPos.String()
+}
+
+// SplitPermit2Nonce returns the bitmap word position and bit position of a nonce
+func SplitPermit2Nonce(nonce *big.Int) (*big.Int, uint) {
+	wordPos := new(big.Int).Rsh(nonce, 8)
+	bitPos := uint(new(big.Int).And(nonce, big.NewInt(0xff)).Uint64())
+	return wordPos, bitPos
+}
+
+// JoinPermit2Nonce returns the nonce for a bitmap word position and bit position
+func JoinPermit2Nonce(wordPos *big.Int, bitPos uint) *big.Int {
+	nonce := new(big.Int).Lsh(wordPos, 8)
+	return nonce.Or(nonce, big.NewInt(int64(bitPos)))
+}
+
+// Permit2NonceReservation is a nonce handed out by the nonce manager that has not been released
+type Permit2NonceReservation struct {
+	Owner      string       `json:"owner"`
+	ChainId    caip.ChainID `json:"chainId"`
+	Nonce      string       `json:"nonce"`
+	ReservedAt time.Time    `json:"reservedAt"`
+}
+
+// Permit2NonceManager hands out unused Permit2 nonces. A nonce is unused if its bit is not set in
+// the on-chain bitmap and it is not already reserved. Reservations are safe for concurrent use and
+// persisted to a file. Every reload-modify-save cycle of the file holds an exclusive lock file, so
+// restarts and other processes sharing the file do not hand out the same nonce.
+type Permit2NonceManager struct {
+	mu           sync.Mutex
+	reader       NonceBitmapReader
+	chainId      caip.ChainID
+	storePath    string
+	reservations map[string]Permit2NonceReservation
+}
+
+// NewPermit2NonceManager creates a nonce manager for a chain. If storePath is not empty, reservations
+// are loaded from and saved to that file.
+func NewPermit2NonceManager(reader NonceBitmapReader, chainId caip.ChainID, storePath string) (*Permit2NonceManager, error) {
+	manager := &Permit2NonceManager{
+		reader:       reader,
+		chainId:      chainId,
+		storePath:    storePath,
+		reservations: make(map[string]Permit2NonceReservation),
+	}
+
+	if err := manager.load(); err != nil {
+		return nil, err
+	}
+	return manager, nil
+}
+
+// Reserve returns the lowest nonce that is neither used on-chain nor reserved, and reserves it
+func (m *Permit2NonceManager) Reserve(owner common.Address) (*big.Int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Lock the store and pick up reservations made by other processes sharing it
+	unlock, err := m.lockStore()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	for word := int64(0); word < maxNonceWordScan; word++ {
+		wordPos := big.NewInt(word)
+		bitmap, err := m.reader.NonceBitmap(owner, wordPos)
+		if err != nil {
+			return nil, err
+		}
+
+		for bitPos := uint(0); bitPos < permit2NonceBitsPerWord; bitPos++ {
+			if bitmap.Bit(int(bitPos)) == 1 {
+				continue
+			}
+
+			nonce := JoinPermit2Nonce(wordPos, bitPos)
+			key := m.reservationKey(owner, nonce)
+			if _, reserved := m.reservations[key]; reserved {
+				continue
+			}
+
+			m.reservations[key] = Permit2NonceReservation{
+				Owner:      owner.Hex(),
+				ChainId:    m.chainId,
+				Nonce:      nonce.String(),
+				ReservedAt: time.Now().UTC(),
+			}
+			if err := m.save(); err != nil {
+				delete(m.reservations, key)
+				return nil, err
+			}
+			return nonce, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no unused Permit2 nonce found for %s in the first %d bitmap words", owner.Hex(), maxNonceWordScan)
+}
+
+// Release returns a reserved nonce that was never signed so it can be handed out again
+func (m *Permit2NonceManager) Release(owner common.Address, nonce *big.Int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	unlock, err := m.lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := m.load(); err != nil {
+		return err
+	}
+	delete(m.reservations, m.reservationKey(owner, nonce))
+	return m.save()
+}
+
+// Reservations returns the owner's outstanding reservations on this chain, ordered by nonce
+func (m *Permit2NonceManager) Reservations(owner common.Address) []Permit2NonceReservation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var reservations []Permit2NonceReservation
+	for _, reservation := range m.reservations {
+		if strings.EqualFold(reservation.Owner, owner.Hex()) && reservation.ChainId == m.chainId {
+			reservations = append(reservations, reservation)
+		}
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		a, _ := new(big.Int).SetString(reservations[i].Nonce, 10)
+		b, _ := new(big.Int).SetString(reservations[j].Nonce, 10)
+		return a.Cmp(b) < 0
+	})
+	return reservations
+}
+
+// Prune drops reservations whose nonces have been used or invalidated on-chain
+func (m *Permit2NonceManager) Prune(owner common.Address) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	unlock, err := m.lockStore()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	if err := m.load(); err != nil {
+		return 0, err
+	}
+
+	bitmaps := make(map[string]*big.Int)
+	pruned := 0
+	for key, reservation := range m.reservations {
+		if !strings.EqualFold(reservation.Owner, owner.Hex()) || reservation.ChainId != m.chainId {
+			continue
+		}
+
+		nonce, ok := new(big.Int).SetString(reservation.Nonce, 10)
+		if !ok {
+			continue
+		}
+		wordPos, bitPos := SplitPermit2Nonce(nonce)
+
+		bitmap, ok := bitmaps[wordPos.String()]
+		if !ok {
+			var err error
+			bitmap, err = m.reader.NonceBitmap(owner, wordPos)
+			if err != nil {
+				return pruned, err
+			}
+			bitmaps[wordPos.String()] = bitmap
+		}
+
+		if bitmap.Bit(int(bitPos)) == 1 {
+			delete(m.reservations, key)
+			pruned++
+		}
+	}
+
+	return pruned, m.save()
+}
+
+// InvalidateNoncesCalls builds Permit2 invalidateUnorderedNonces calls, one per bitmap word, that
+// invalidate the given nonces on-chain. Execute them with OrbyClient.GetOperationsToExecuteContractCall.
+func InvalidateNoncesCalls(nonces []*big.Int) ([]*ContractCall, error) {
+	registry, err := DefaultABIRegistry()
+	if err != nil {
+		return nil, err
+	}
+	permit2Abi, err := registry.Get(ABINamePermit2)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group the nonces into one mask per word
+	masks := make(map[string]*big.Int)
+	var wordPositions []*big.Int
+	for _, nonce := range nonces {
+		wordPos, bitPos := SplitPermit2Nonce(nonce)
+		mask, ok := masks[wordPos.String()]
+		if !ok {
+			mask = new(big.Int)
+			masks[wordPos.String()] = mask
+			wordPositions = append(wordPositions, wordPos)
+		}
+		mask.SetBit(mask, int(bitPos), 1)
+	}
+
+	calls := make([]*ContractCall, len(wordPositions))
+	for i, wordPos := range wordPositions {
+		calls[i] = &ContractCall{
+			To:     Permit2Address,
+			ABI:    permit2Abi,
+			Method: "invalidateUnorderedNonces",
+			Args:   []any{wordPos, masks[wordPos.String()]},
+		}
+	}
+	return calls, nil
+}
+
+func (m *Permit2NonceManager) reservationKey(owner common.Address, nonce *big.Int) string {
+	return m.chainId.String() + ":" + strings.ToLower(owner.Hex()) + ":" + nonce.String()
+}
+
+// load reads reservations from the store file
+func (m *Permit2NonceManager) load() error {
+	if m.storePath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(m.storePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read nonce store %s: %v", m.storePath, err)
+	}
+
+	var reservations []Permit2NonceReservation
+	if err := json.Unmarshal(data, &reservations); err != nil {
+		return fmt.Errorf("failed to parse nonce store %s: %v", m.storePath, err)
+	}
+
+	// The store is the source of truth, since every change is saved to it
+	m.reservations = make(map[string]Permit2NonceReservation, len(reservations))
+	for _, reservation := range reservations {
+		nonce, ok := new(big.Int).SetString(reservation.Nonce, 10)
+		if !ok {
+			continue
+		}
+		key := reservation.ChainId.String() + ":" + strings.ToLower(common.HexToAddress(reservation.Owner).Hex()) + ":" + nonce.String()
+		m.reservations[key] = reservation
+	}
+	return nil
+}
+
+// save writes all reservations to the store file, replacing it atomically
+func (m *Permit2NonceManager) save() error {
+	if m.storePath == "" {
+		return nil
+	}
+
+	reservations := make([]Permit2NonceReservation, 0, len(m.reservations))
+	for _, reservation := range m.reservations {
+		reservations = append(reservations, reservation)
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		if reservations[i].ChainId != reservations[j].ChainId {
+			return reservations[i].ChainId.String() < reservations[j].ChainId.String()
+		}
+		if reservations[i].Owner != reservations[j].Owner {
+			return reservations[i].Owner < reservations[j].Owner
+		}
+		a, _ := new(big.Int).SetString(reservations[i].Nonce, 10)
+		b, _ := new(big.Int).SetString(reservations[j].Nonce, 10)
+		return a.Cmp(b) < 0
+	})
+
+	if err := WriteJSONFile(m.storePath, reservations); err != nil {
+		return fmt.Errorf("failed to write nonce store %s: %v", m.storePath, err)
+	}
+	return nil
+}
+
+// lockStore takes the exclusive lock on the store file, creating the lock file with O_EXCL, and returns
+// the function that releases it. The lock file is kept fresh until then.
+func (m *Permit2NonceManager) lockStore() (func(), error) {
+	if m.storePath == "" {
+		return func() {}, nil
+	}
+	if dir := filepath.Dir(m.storePath); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create directory %s: %v", dir, err)
+		}
+	}
+
+	lockPath := m.storePath + ".lock"
+	owner := fmt.Sprintf("%d %d\n", os.Getpid(), time.Now().UnixNano())
+	deadline := time.Now().Add(nonceStoreLockTimeout)
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			file.WriteString(owner)
+			file.Close()
+			return holdStoreLock(lockPath, owner), nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock nonce store %s: %v", m.storePath, err)
+		}
+
+		// Remove a lock left by a process that died while holding it
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > nonceStoreLockStale {
+			log.Printf("[WARN] Removing stale nonce store lock %s", lockPath)
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("nonce store %s is locked by another process; remove %s if no other process is using it", m.storePath, lockPath)
+		}
+		time.Sleep(nonceStoreLockRetry)
+	}
+}
+
+// holdStoreLock touches the lock file every nonceStoreLockRefresh so other processes do not take it
+// for stale, and returns the function that stops doing so and removes it, if it is still ours
+func holdStoreLock(lockPath string, owner string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(nonceStoreLockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if err := os.Chtimes(lockPath, now, now); err != nil {
+					log.Printf("[WARN] Failed to refresh nonce store lock %s: %v", lockPath, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		data, err := os.ReadFile(lockPath)
+		if err != nil {
+			return
+		}
+		if string(data) != owner {
+			log.Printf("[WARN] Nonce store lock %s was taken over by another process while held", lockPath)
+			return
+		}
+		os.Remove(lockPath)
+	}
+}
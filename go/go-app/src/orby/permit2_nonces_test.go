@@ -0,0 +1,142 @@
+package orby
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"go-app/src/caip"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	testNonceOwner   = common.HexToAddress("0x00000000000000000000000000000000000000e5")
+	testNonceChainId = caip.ChainID{Namespace: "eip155", Reference: "1"}
+)
+
+func newTestNonceManager(t *testing.T, bitmap *LocalNonceBitmap, storePath string) *Permit2NonceManager {
+	t.Helper()
+	manager, err := NewPermit2NonceManager(bitmap, testNonceChainId, storePath)
+	if err != nil {
+		t.Fatalf("NewPermit2NonceManager: %v", err)
+	}
+	return manager
+}
+
+func reserve(t *testing.T, manager *Permit2NonceManager) *big.Int {
+	t.Helper()
+	nonce, err := manager.Reserve(testNonceOwner)
+	if err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	return nonce
+}
+
+func TestPermit2NonceManagerSkipsUsedAndReservedNonces(t *testing.T) {
+	bitmap := NewLocalNonceBitmap()
+	bitmap.UseNonce(testNonceOwner, big.NewInt(0))
+	bitmap.UseNonce(testNonceOwner, big.NewInt(2))
+	manager := newTestNonceManager(t, bitmap, "")
+
+	for _, want := range []int64{1, 3, 4} {
+		if nonce := reserve(t, manager); nonce.Int64() != want {
+			t.Errorf("Reserve = %s, want %d", nonce, want)
+		}
+	}
+
+	// A full bitmap word moves on to the next word
+	bitmap.InvalidateUnorderedNonces(testNonceOwner, big.NewInt(0), new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1)))
+	if nonce := reserve(t, manager); nonce.Int64() != 256 {
+		t.Errorf("Reserve after a full word = %s, want 256", nonce)
+	}
+}
+
+func TestPermit2NonceManagerReleaseAndPrune(t *testing.T) {
+	bitmap := NewLocalNonceBitmap()
+	manager := newTestNonceManager(t, bitmap, filepath.Join(t.TempDir(), "nonces.json"))
+
+	first := reserve(t, manager)
+	second := reserve(t, manager)
+
+	// A released nonce is handed out again
+	if err := manager.Release(testNonceOwner, first); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if nonce := reserve(t, manager); nonce.Cmp(first) != 0 {
+		t.Errorf("Reserve after Release = %s, want %s", nonce, first)
+	}
+
+	// Reservations used on-chain are pruned, the others are kept
+	bitmap.UseNonce(testNonceOwner, second)
+	pruned, err := manager.Prune(testNonceOwner)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if pruned != 1 {
+		t.Errorf("Prune = %d, want 1", pruned)
+	}
+	reservations := manager.Reservations(testNonceOwner)
+	if len(reservations) != 1 || reservations[0].Nonce != first.String() {
+		t.Errorf("Reservations after Prune = %+v, want only nonce %s", reservations, first)
+	}
+}
+
+func TestPermit2NonceManagerSharedStore(t *testing.T) {
+	bitmap := NewLocalNonceBitmap()
+	storePath := filepath.Join(t.TempDir(), "nonces.json")
+
+	// Managers sharing a store stand in for separate processes
+	const managers, reservationsEach = 4, 5
+	nonces := make(chan *big.Int, managers*reservationsEach)
+	var wg sync.WaitGroup
+	for i := 0; i < managers; i++ {
+		manager := newTestNonceManager(t, bitmap, storePath)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < reservationsEach; j++ {
+				nonce, err := manager.Reserve(testNonceOwner)
+				if err != nil {
+					t.Errorf("Reserve: %v", err)
+					return
+				}
+				nonces <- nonce
+			}
+		}()
+	}
+	wg.Wait()
+	close(nonces)
+
+	seen := make(map[string]bool)
+	for nonce := range nonces {
+		if seen[nonce.String()] {
+			t.Errorf("nonce %s was reserved twice", nonce)
+		}
+		seen[nonce.String()] = true
+	}
+	if len(seen) != managers*reservationsEach {
+		t.Errorf("reserved %d nonces, want %d", len(seen), managers*reservationsEach)
+	}
+	if _, err := os.Stat(storePath + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+
+	// The store is ordered by nonce, numerically
+	data, err := os.ReadFile(storePath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var stored []Permit2NonceReservation
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	for i, reservation := range stored {
+		if want := big.NewInt(int64(i)).String(); reservation.Nonce != want {
+			t.Fatalf("stored reservation %d has nonce %s, want %s", i, reservation.Nonce, want)
+		}
+	}
+}
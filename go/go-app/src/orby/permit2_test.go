@@ -0,0 +1,278 @@
+package orby
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// Type hashes declared in Permit2's PermitHash.sol
+var (
+	permit2TokenPermissionsTypeHash        = common.HexToHash("0x618358ac3db8dc274f0cd8829da7e234bd48cd73c4a740aede1adec9846d06a1")
+	permit2PermitTransferFromTypeHash      = common.HexToHash("0x939c21a48a8dbe3a9a2404a1d46691e4d39f6583d6ec6b35714604c986d80106")
+	permit2PermitBatchTransferFromTypeHash = common.HexToHash("0xfcf35f5ac6a2c28868dc44c302166470266239195f02b0ee408334829333b766")
+	permit2PermitDetailsTypeHash           = common.HexToHash("0x65626cad6cb96493bf6f5ebea28756c966f023ab9e8a83a7101849d5573b3678")
+	permit2PermitSingleTypeHash            = common.HexToHash("0xf3841cd1ff0085026a6327b620b67997ce40f282c88a8e905a7a5626e310f3d0")
+	permit2PermitBatchTypeHash             = common.HexToHash("0xaf1b0d30d2cab0380e68f0689007e3254993c596f2fdd0aaa7f4d04f79440863")
+)
+
+// The witness used by Permit2's own tests, and the witnessTypeString they pass to permitWitnessTransferFrom
+const (
+	mockWitnessType       = "MockWitness(uint256 value,address person,bool test)"
+	mockWitnessTypeString = "MockWitness witness)MockWitness(uint256 value,address person,bool test)TokenPermissions(address token,uint256 amount)"
+)
+
+var (
+	testChainId  = big.NewInt(1)
+	testSpender  = "0x00000000000000000000000000000000000000a1"
+	testToken    = "0x00000000000000000000000000000000000000b2"
+	testToken2   = "0x00000000000000000000000000000000000000c3"
+	testPerson   = "0x00000000000000000000000000000000000000d4"
+	testNonce    = big.NewInt(7)
+	testDeadline = big.NewInt(1700000000)
+)
+
+// word left-pads an integer or an address to a 32 byte ABI word
+func word(value interface{}) []byte {
+	switch v := value.(type) {
+	case *big.Int:
+		return common.LeftPadBytes(v.Bytes(), 32)
+	case int64:
+		return common.LeftPadBytes(big.NewInt(v).Bytes(), 32)
+	case string:
+		return common.LeftPadBytes(common.HexToAddress(v).Bytes(), 32)
+	case common.Hash:
+		return v.Bytes()
+	case bool:
+		if v {
+			return word(int64(1))
+		}
+		return word(int64(0))
+	}
+	panic("unsupported word type")
+}
+
+// encode is abi.encode of static values
+func encode(values ...interface{}) []byte {
+	var data []byte
+	for _, value := range values {
+		data = append(data, word(value)...)
+	}
+	return data
+}
+
+// permit2Digest computes the digest the way Permit2 does on chain, from its domain separator and a struct hash
+func permit2Digest(structHash common.Hash) common.Hash {
+	domainSeparator := crypto.Keccak256Hash(encode(
+		crypto.Keccak256Hash([]byte("EIP712Domain(string name,uint256 chainId,address verifyingContract)")),
+		crypto.Keccak256Hash([]byte("Permit2")),
+		testChainId,
+		Permit2Address))
+	return crypto.Keccak256Hash([]byte("\x19\x01"), domainSeparator.Bytes(), structHash.Bytes())
+}
+
+func tokenPermissionsHash(token string, amount int64) common.Hash {
+	return crypto.Keccak256Hash(encode(permit2TokenPermissionsTypeHash, token, amount))
+}
+
+func permitDetailsHash(details Permit2PermitDetails) common.Hash {
+	return crypto.Keccak256Hash(encode(permit2PermitDetailsTypeHash, details.Token, details.Amount,
+		int64(details.Expiration), int64(details.Nonce)))
+}
+
+func mockWitness() *Permit2Witness {
+	return &Permit2Witness{
+		TypeName: "MockWitness",
+		Types: map[string][]apitypes.Type{
+			"MockWitness": {
+				{Name: "value", Type: "uint256"},
+				{Name: "person", Type: "address"},
+				{Name: "test", Type: "bool"},
+			},
+		},
+		Value: apitypes.TypedDataMessage{
+			"value":  "10000000",
+			"person": testPerson,
+			"test":   true,
+		},
+	}
+}
+
+func mockWitnessHash() common.Hash {
+	return crypto.Keccak256Hash(encode(crypto.Keccak256Hash([]byte(mockWitnessType)), int64(10000000), testPerson, true))
+}
+
+func assertDigest(t *testing.T, typedData *apitypes.TypedData, typeHash common.Hash, expected common.Hash) {
+	t.Helper()
+	if got := crypto.Keccak256Hash(typedData.EncodeType(typedData.PrimaryType)); got != typeHash {
+		t.Errorf("%s type hash = %s, want %s", typedData.PrimaryType, got.Hex(), typeHash.Hex())
+	}
+	digest, err := TypedDataHash(typedData)
+	if err != nil {
+		t.Fatalf("TypedDataHash: %v", err)
+	}
+	if digest != expected {
+		t.Errorf("%s digest = %s, want %s", typedData.PrimaryType, digest.Hex(), expected.Hex())
+	}
+}
+
+func TestBuildPermitTransferFrom(t *testing.T) {
+	typedData, err := BuildPermitTransferFrom(PermitTransferFromParams{
+		ChainId:   testChainId,
+		Permitted: Permit2TokenPermission{Token: testToken, Amount: big.NewInt(1000)},
+		Spender:   testSpender,
+		Nonce:     testNonce,
+		Deadline:  testDeadline,
+	})
+	if err != nil {
+		t.Fatalf("BuildPermitTransferFrom: %v", err)
+	}
+
+	structHash := crypto.Keccak256Hash(encode(permit2PermitTransferFromTypeHash,
+		tokenPermissionsHash(testToken, 1000), testSpender, testNonce, testDeadline))
+	assertDigest(t, typedData, permit2PermitTransferFromTypeHash, permit2Digest(structHash))
+
+	if _, err := Permit2WitnessTypeString(typedData); err == nil {
+		t.Errorf("Permit2WitnessTypeString accepted typed data without a witness")
+	}
+}
+
+func TestBuildPermitWitnessTransferFrom(t *testing.T) {
+	typedData, err := BuildPermitTransferFrom(PermitTransferFromParams{
+		ChainId:   testChainId,
+		Permitted: Permit2TokenPermission{Token: testToken, Amount: big.NewInt(1000)},
+		Spender:   testSpender,
+		Nonce:     testNonce,
+		Deadline:  testDeadline,
+		Witness:   mockWitness(),
+	})
+	if err != nil {
+		t.Fatalf("BuildPermitTransferFrom: %v", err)
+	}
+
+	witnessTypeString, err := Permit2WitnessTypeString(typedData)
+	if err != nil {
+		t.Fatalf("Permit2WitnessTypeString: %v", err)
+	}
+	if witnessTypeString != mockWitnessTypeString {
+		t.Errorf("witness type string = %q, want %q", witnessTypeString, mockWitnessTypeString)
+	}
+
+	// Permit2 hashes a fixed stub followed by the witnessTypeString given by the caller
+	typeHash := crypto.Keccak256Hash([]byte(
+		"PermitWitnessTransferFrom(TokenPermissions permitted,address spender,uint256 nonce,uint256 deadline," + mockWitnessTypeString))
+	structHash := crypto.Keccak256Hash(encode(typeHash,
+		tokenPermissionsHash(testToken, 1000), testSpender, testNonce, testDeadline, mockWitnessHash()))
+	assertDigest(t, typedData, typeHash, permit2Digest(structHash))
+}
+
+func TestBuildPermitBatchTransferFrom(t *testing.T) {
+	permitted := []Permit2TokenPermission{
+		{Token: testToken, Amount: big.NewInt(1000)},
+		{Token: testToken2, Amount: big.NewInt(2000)},
+	}
+	permissionsHash := crypto.Keccak256Hash(
+		tokenPermissionsHash(testToken, 1000).Bytes(),
+		tokenPermissionsHash(testToken2, 2000).Bytes())
+
+	typedData, err := BuildPermitBatchTransferFrom(PermitBatchTransferFromParams{
+		ChainId:   testChainId,
+		Permitted: permitted,
+		Spender:   testSpender,
+		Nonce:     testNonce,
+		Deadline:  testDeadline,
+	})
+	if err != nil {
+		t.Fatalf("BuildPermitBatchTransferFrom: %v", err)
+	}
+	structHash := crypto.Keccak256Hash(encode(permit2PermitBatchTransferFromTypeHash,
+		permissionsHash, testSpender, testNonce, testDeadline))
+	assertDigest(t, typedData, permit2PermitBatchTransferFromTypeHash, permit2Digest(structHash))
+
+	// With a witness
+	typedData, err = BuildPermitBatchTransferFrom(PermitBatchTransferFromParams{
+		ChainId:   testChainId,
+		Permitted: permitted,
+		Spender:   testSpender,
+		Nonce:     testNonce,
+		Deadline:  testDeadline,
+		Witness:   mockWitness(),
+	})
+	if err != nil {
+		t.Fatalf("BuildPermitBatchTransferFrom with witness: %v", err)
+	}
+	witnessTypeString, err := Permit2WitnessTypeString(typedData)
+	if err != nil {
+		t.Fatalf("Permit2WitnessTypeString: %v", err)
+	}
+	if witnessTypeString != mockWitnessTypeString {
+		t.Errorf("witness type string = %q, want %q", witnessTypeString, mockWitnessTypeString)
+	}
+	typeHash := crypto.Keccak256Hash([]byte(
+		"PermitBatchWitnessTransferFrom(TokenPermissions[] permitted,address spender,uint256 nonce,uint256 deadline," + mockWitnessTypeString))
+	structHash = crypto.Keccak256Hash(encode(typeHash,
+		permissionsHash, testSpender, testNonce, testDeadline, mockWitnessHash()))
+	assertDigest(t, typedData, typeHash, permit2Digest(structHash))
+}
+
+func TestBuildPermitSingle(t *testing.T) {
+	details := Permit2PermitDetails{Token: testToken, Amount: big.NewInt(5000), Expiration: 1800000000, Nonce: 3}
+	typedData, err := BuildPermitSingle(PermitSingleParams{
+		ChainId:     testChainId,
+		Details:     details,
+		Spender:     testSpender,
+		SigDeadline: testDeadline,
+	})
+	if err != nil {
+		t.Fatalf("BuildPermitSingle: %v", err)
+	}
+
+	structHash := crypto.Keccak256Hash(encode(permit2PermitSingleTypeHash,
+		permitDetailsHash(details), testSpender, testDeadline))
+	assertDigest(t, typedData, permit2PermitSingleTypeHash, permit2Digest(structHash))
+}
+
+func TestBuildPermitBatch(t *testing.T) {
+	details := []Permit2PermitDetails{
+		{Token: testToken, Amount: big.NewInt(5000), Expiration: 1800000000, Nonce: 3},
+		{Token: testToken2, Amount: big.NewInt(6000), Expiration: 1900000000, Nonce: 0},
+	}
+	typedData, err := BuildPermitBatch(PermitBatchParams{
+		ChainId:     testChainId,
+		Details:     details,
+		Spender:     testSpender,
+		SigDeadline: testDeadline,
+	})
+	if err != nil {
+		t.Fatalf("BuildPermitBatch: %v", err)
+	}
+
+	detailsHash := crypto.Keccak256Hash(permitDetailsHash(details[0]).Bytes(), permitDetailsHash(details[1]).Bytes())
+	structHash := crypto.Keccak256Hash(encode(permit2PermitBatchTypeHash, detailsHash, testSpender, testDeadline))
+	assertDigest(t, typedData, permit2PermitBatchTypeHash, permit2Digest(structHash))
+}
+
+func TestBuildPermitTransferFromRejectsPermit2Types(t *testing.T) {
+	for _, name := range []string{"TokenPermissions", "EIP712Domain", Permit2PermitWitnessTransferFrom} {
+		witness := mockWitness()
+		witness.Types[name] = []apitypes.Type{
+			{Name: "token", Type: "address"},
+			{Name: "amount", Type: "uint128"},
+		}
+		_, err := BuildPermitTransferFrom(PermitTransferFromParams{
+			ChainId:   testChainId,
+			Permitted: Permit2TokenPermission{Token: testToken, Amount: big.NewInt(1000)},
+			Spender:   testSpender,
+			Nonce:     testNonce,
+			Deadline:  testDeadline,
+			Witness:   witness,
+		})
+		if err == nil || !strings.Contains(err.Error(), "conflicts with a Permit2 type") {
+			t.Errorf("witness redefining %s: got error %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+// rpc_proxy.go serves a local Ethereum JSON-RPC endpoint that turns eth_sendTransaction and
+// eth_signTypedData_v4 into Orby operation sets, so existing tools can use chain abstraction unmodified
+package orby
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"go-app/src/caip"
+)
+
+// JSON-RPC error codes returned by the proxy
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+	// rpcSentWithoutHash is returned when the operations were sent but no transaction hash came back
+	rpcSentWithoutHash = -32001
+)
+
+// rpcMessage is a JSON-RPC 2.0 request or response
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// SendTransactionArgs are the eth_sendTransaction fields the proxy uses; gas, fees and nonce are
+// chosen by Orby
+type SendTransactionArgs struct {
+	From  string          `json:"from"`
+	To    *common.Address `json:"to"`
+	Value *hexutil.Big    `json:"value"`
+	Data  *hexutil.Bytes  `json:"data"`
+	Input *hexutil.Bytes  `json:"input"`
+}
+
+// RPCProxy answers eth_sendTransaction and eth_signTypedData_v4 by running the sign-and-send flow of
+// the account cluster, answers eth_accounts and eth_chainId itself, and forwards every other method to
+// the virtual node of its chain. Browser requests, which carry an Origin header, are only answered for
+// AllowedOrigins, so web pages cannot spend from the cluster through a proxy on localhost.
+type RPCProxy struct {
+	VirtualNodes     *VirtualNodePool
+	AccountClusterId string
+	ChainId          caip.ChainID
+	AllowedOrigins   []string
+
+	// Operation sets are signed and sent one at a time, so prompts and nonces do not interleave
+	mu sync.Mutex
+}
+
+// NewRPCProxy creates a proxy for an account cluster on a chain
+func NewRPCProxy(virtualNodes *VirtualNodePool, accountClusterId string, chainId caip.ChainID) *RPCProxy {
+	return &RPCProxy{
+		VirtualNodes:     virtualNodes,
+		AccountClusterId: accountClusterId,
+		ChainId:          chainId,
+	}
+}
+
+// ServeHTTP handles single and batch JSON-RPC requests
+func (p *RPCProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 1. Only answer browsers on an allowed origin, and their CORS preflight requests
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if !p.originAllowed(origin) {
+			log.Printf("[WARN] Proxy rejected a request from origin %s", origin)
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Vary", "Origin")
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "POST")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	// 2. Accept JSON POSTs only; browsers can send other content types without a preflight
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var requests []rpcMessage
+		if err := json.Unmarshal(body, &requests); err != nil {
+			writeJSON(w, errorResponse(nil, rpcParseError, err.Error()))
+			return
+		}
+		responses := make([]rpcMessage, len(requests))
+		for i, request := range requests {
+			responses[i] = p.handle(request)
+		}
+		writeJSON(w, responses)
+		return
+	}
+
+	var request rpcMessage
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeJSON(w, errorResponse(nil, rpcParseError, err.Error()))
+		return
+	}
+	writeJSON(w, p.handle(request))
+}
+
+// originAllowed reports whether a browser origin is in AllowedOrigins
+func (p *RPCProxy) originAllowed(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// handle answers one request
+func (p *RPCProxy) handle(request rpcMessage) rpcMessage {
+	if request.Method == "" {
+		return errorResponse(request.Id, rpcInvalidRequest, "missing method")
+	}
+	fmt.Printf("[INFO] Proxy request: %s\n", request.Method)
+
+	var result any
+	var err error
+	switch request.Method {
+	case "eth_chainId":
+		result, err = p.chainIdHex()
+	case "eth_accounts", "eth_requestAccounts":
+		result, err = p.accounts()
+	case "eth_sendTransaction":
+		result, err = p.sendTransaction(request.Params)
+	case "eth_signTypedData_v4":
+		result, err = p.signTypedData(request.Params)
+	default:
+		return p.forward(request)
+	}
+
+	if err != nil {
+		log.Printf("[ERROR] Proxy %s failed: %v", request.Method, err)
+		return methodErrorResponse(request.Id, err)
+	}
+	return rpcMessage{JSONRPC: "2.0", Id: request.Id, Result: result}
+}
+
+// methodErrorResponse returns the error response of a method that failed, with its error code
+func methodErrorResponse(id json.RawMessage, err error) rpcMessage {
+	response := errorResponse(id, rpcServerError, err.Error())
+	switch err := err.(type) {
+	case invalidParamsError:
+		response.Error.Code = rpcInvalidParams
+	case sentWithoutHashError:
+		response.Error.Code = rpcSentWithoutHash
+		if err.OperationSetId != "" {
+			response.Error.Data = map[string]string{"operationSetId": err.OperationSetId}
+		}
+	}
+	return response
+}
+
+// invalidParamsError is returned for requests with malformed parameters
+type invalidParamsError struct{ error }
+
+// sentWithoutHashError is returned when the operations were sent but no transaction hash can be
+// returned. Clients expect a transaction hash, so the operation set ID goes in the error's data.
+type sentWithoutHashError struct {
+	OperationSetId string
+	Reason         string
+}
+
+func (e sentWithoutHashError) Error() string {
+	sent := "the operations were sent"
+	if e.OperationSetId != "" {
+		sent += " as operation set " + e.OperationSetId
+	}
+	return fmt.Sprintf("%s, but %s; do not retry, or they may be executed twice", sent, e.Reason)
+}
+
+// chainIdHex returns the proxy's chain ID as a hex quantity
+func (p *RPCProxy) chainIdHex() (string, error) {
+	chainId, err := p.ChainId.BigInt()
+	if err != nil {
+		return "", err
+	}
+	return hexutil.EncodeBig(chainId), nil
+}
+
+// accounts returns the cluster's EVM accounts
+func (p *RPCProxy) accounts() ([]string, error) {
+	cluster, err := p.VirtualNodes.Cluster(p.AccountClusterId)
+	if err != nil {
+		return nil, err
+	}
+	accounts := []string{}
+	for _, account := range cluster.Accounts {
+		if account.VMType == VMTypeEVM {
+			accounts = append(accounts, strings.ToLower(account.Address))
+		}
+	}
+	return accounts, nil
+}
+
+// checkAccount makes sure an address is one of the cluster's EVM accounts
+func (p *RPCProxy) checkAccount(address string) error {
+	accounts, err := p.accounts()
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		if strings.EqualFold(account, address) {
+			return nil
+		}
+	}
+	return invalidParamsError{fmt.Errorf("%s is not an account of cluster %s", address, p.AccountClusterId)}
+}
+
+// sendTransaction requests the transaction with orby_getOperationsToExecuteTransaction, then signs and
+// sends the operations and returns the hash of the transaction on the proxy's chain
+func (p *RPCProxy) sendTransaction(params json.RawMessage) (string, error) {
+	// 1. Parse the transaction
+	var args []SendTransactionArgs
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 {
+		return "", invalidParamsError{fmt.Errorf("expected [transaction]: %v", err)}
+	}
+	tx := args[0]
+	if tx.To == nil {
+		return "", invalidParamsError{fmt.Errorf("contract deployment is not supported")}
+	}
+	if err := p.checkAccount(tx.From); err != nil {
+		return "", err
+	}
+	data := hexutil.Bytes{}
+	if tx.Input != nil {
+		data = *tx.Input
+	} else if tx.Data != nil {
+		data = *tx.Data
+	}
+	value := Amount{}
+	if tx.Value != nil {
+		value = NewAmount(tx.Value.ToInt())
+	}
+
+	// 2. Request, sign and send the operations
+	node, err := p.VirtualNodes.ForChain(p.AccountClusterId, p.ChainId)
+	if err != nil {
+		return "", err
+	}
+	result, _, err := p.execute(node, func() (*OperationSet, error) {
+		response, err := node.GetOperationsToExecuteTransaction(p.AccountClusterId, data.String(), tx.To.Hex(), value)
+		if err != nil {
+			return nil, err
+		}
+		return ParseOperationSet(response, "orby_getOperationsToExecuteTransaction")
+	})
+	if err != nil {
+		return "", err
+	}
+	return p.transactionHash(result)
+}
+
+// signTypedData requests the typed data with orby_getOperationsToSignTypedData, then signs and sends
+// the operations and returns the account's signature of the typed data, as eth_signTypedData_v4 does
+func (p *RPCProxy) signTypedData(params json.RawMessage) (string, error) {
+	// 1. Parse the address and typed data, given as a JSON string or an object
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || len(args) < 2 {
+		return "", invalidParamsError{fmt.Errorf("expected [address, typedData]: %v", err)}
+	}
+	var address string
+	if err := json.Unmarshal(args[0], &address); err != nil {
+		return "", invalidParamsError{fmt.Errorf("invalid address: %v", err)}
+	}
+	if err := p.checkAccount(address); err != nil {
+		return "", err
+	}
+	typedDataJson := args[1]
+	var encoded string
+	if json.Unmarshal(typedDataJson, &encoded) == nil {
+		typedDataJson = json.RawMessage(encoded)
+	}
+	typedData, err := LoadTypedData(string(typedDataJson))
+	if err != nil {
+		return "", invalidParamsError{fmt.Errorf("invalid typed data: %v", err)}
+	}
+	hash, err := TypedDataHash(typedData)
+	if err != nil {
+		return "", invalidParamsError{fmt.Errorf("invalid typed data: %v", err)}
+	}
+
+	// 2. Request, sign and send the operations
+	node, err := p.VirtualNodes.ForChain(p.AccountClusterId, p.ChainId)
+	if err != nil {
+		return "", err
+	}
+	_, signedOperations, err := p.execute(node, func() (*OperationSet, error) {
+		response, err := node.GetOperationsToSignTypedData(p.AccountClusterId, typedData)
+		if err != nil {
+			return nil, err
+		}
+		return ParseOperationSet(response, "orby_getOperationsToSignTypedData")
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// 3. Return the signature of the operation that signs this typed data for the address
+	for _, signed := range signedOperations {
+		if !strings.EqualFold(signed.From, address) {
+			continue
+		}
+		if signedHash, err := OperationTypedDataHash(Operation{Data: signed.Data}); err == nil && signedHash == hash {
+			return signed.Signature, nil
+		}
+	}
+	return "", fmt.Errorf("the operations were sent, but none of them signed typed data %s for %s", hash.Hex(), address)
+}
+
+// execute runs the sign-and-send flow for an operation set and returns the send result and the
+// operations that were signed and sent
+func (p *RPCProxy) execute(node *OrbyClient, fetch OperationSetFetcher) (json.RawMessage, []SignedOperation, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	quoteOptions, err := QuoteOptionsFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	executor := NewOperationExecutor(node, p.AccountClusterId)
+	executor.QuoteOptions = quoteOptions
+	executor.VirtualNodes = p.VirtualNodes
+
+	result, err := executor.Execute(fetch)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, executor.SignedOperations, nil
+}
+
+// transactionHash returns the hash of the sent operation on the proxy's chain, or else the first hash.
+// The operations were sent, so a response without a hash is a sentWithoutHashError, which tells the
+// client not to retry.
+func (p *RPCProxy) transactionHash(result json.RawMessage) (string, error) {
+	return sentTransactionHash(result, p.ChainId)
+}
+
+// sentTransactionHash returns the hash of the operation sent on a chain, or else the first hash, from an
+// orby_sendSignedOperations result
+func sentTransactionHash(result json.RawMessage, chainId caip.ChainID) (string, error) {
+	var response SendSignedOperationsResponse
+	if err := json.Unmarshal(result, &response); err != nil {
+		log.Printf("[WARN] Sent the operations, but failed to parse the orby_sendSignedOperations response %s: %v", result, err)
+		return "", sentWithoutHashError{Reason: fmt.Sprintf("the orby_sendSignedOperations response could not be parsed: %v", err)}
+	}
+
+	var hash string
+	for _, operation := range response.OperationResponses {
+		if operation.Hash == "" {
+			continue
+		}
+		if operation.ChainId == chainId {
+			return operation.Hash, nil
+		}
+		if hash == "" {
+			hash = operation.Hash
+		}
+	}
+	if hash == "" {
+		return "", sentWithoutHashError{OperationSetId: response.OperationSetId, Reason: "no transaction hash was returned"}
+	}
+	return hash, nil
+}
+
+// forward sends a request to the virtual node of the proxy's chain and relays its response
+func (p *RPCProxy) forward(request rpcMessage) rpcMessage {
+	node, err := p.VirtualNodes.ForChain(p.AccountClusterId, p.ChainId)
+	if err != nil {
+		return errorResponse(request.Id, rpcServerError, err.Error())
+	}
+
+	request.JSONRPC = "2.0"
+	body, err := json.Marshal(request)
+	if err != nil {
+		return errorResponse(request.Id, rpcServerError, err.Error())
+	}
+	resp, err := node.HTTPClient.Post(node.OrbyURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errorResponse(request.Id, rpcServerError, fmt.Sprintf("virtual node request failed: %v", err))
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Id     json.RawMessage `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return errorResponse(request.Id, rpcServerError, fmt.Sprintf("invalid virtual node response: %v", err))
+	}
+	if response.Error != nil {
+		return rpcMessage{JSONRPC: "2.0", Id: request.Id, Error: response.Error}
+	}
+	result := response.Result
+	if result == nil {
+		result = json.RawMessage("null")
+	}
+	return rpcMessage{JSONRPC: "2.0", Id: request.Id, Result: result}
+}
+
+func errorResponse(id json.RawMessage, code int, message string) rpcMessage {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return rpcMessage{JSONRPC: "2.0", Id: id, Error: &rpcError{Code: code, Message: message}}
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("[ERROR] Failed to write proxy response: %v", err)
+	}
+}
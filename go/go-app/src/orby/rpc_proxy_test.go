@@ -0,0 +1,153 @@
+package orby
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-app/src/caip"
+)
+
+const chainIdRequest = `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`
+
+func newTestProxy() *RPCProxy {
+	proxy := NewRPCProxy(nil, "cluster", caip.ChainID{Namespace: "eip155", Reference: "1"})
+	proxy.AllowedOrigins = []string{"http://localhost:3000/"}
+	return proxy
+}
+
+// serveProxy sends one request to the proxy through a test server
+func serveProxy(t *testing.T, proxy *RPCProxy, method string, headers map[string]string, body string) *http.Response {
+	t.Helper()
+	server := httptest.NewServer(proxy)
+	t.Cleanup(server.Close)
+
+	request, err := http.NewRequest(method, server.URL, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("%s request: %v", method, err)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	return response
+}
+
+func TestRPCProxyRejectsOrigins(t *testing.T) {
+	proxy := newTestProxy()
+	for _, origin := range []string{"https://evil.example", "http://localhost:3001", "null"} {
+		response := serveProxy(t, proxy, http.MethodPost, map[string]string{
+			"Origin":       origin,
+			"Content-Type": "application/json",
+		}, chainIdRequest)
+		if response.StatusCode != http.StatusForbidden {
+			t.Errorf("origin %s: status %d, want %d", origin, response.StatusCode, http.StatusForbidden)
+		}
+		if allowed := response.Header.Get("Access-Control-Allow-Origin"); allowed != "" {
+			t.Errorf("origin %s: Access-Control-Allow-Origin = %q, want none", origin, allowed)
+		}
+	}
+}
+
+func TestRPCProxyAllowsOrigins(t *testing.T) {
+	proxy := newTestProxy()
+
+	// The CORS preflight of an allowed origin is answered without running a method
+	response := serveProxy(t, proxy, http.MethodOptions, map[string]string{
+		"Origin":                         "http://localhost:3000",
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "content-type",
+	}, "")
+	if response.StatusCode != http.StatusNoContent {
+		t.Errorf("preflight: status %d, want %d", response.StatusCode, http.StatusNoContent)
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "http://localhost:3000",
+		"Access-Control-Allow-Methods": "POST",
+		"Access-Control-Allow-Headers": "Content-Type",
+	} {
+		if got := response.Header.Get(header); got != want {
+			t.Errorf("preflight: %s = %q, want %q", header, got, want)
+		}
+	}
+
+	// Requests from an allowed origin, and requests without an origin, are answered
+	for _, origin := range []string{"http://localhost:3000", ""} {
+		headers := map[string]string{"Content-Type": "application/json"}
+		if origin != "" {
+			headers["Origin"] = origin
+		}
+		response := serveProxy(t, proxy, http.MethodPost, headers, chainIdRequest)
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("origin %q: status %d, want %d", origin, response.StatusCode, http.StatusOK)
+		}
+		var message rpcMessage
+		if err := json.NewDecoder(response.Body).Decode(&message); err != nil {
+			t.Fatalf("origin %q: invalid response: %v", origin, err)
+		}
+		if message.Result != "0x1" {
+			t.Errorf("origin %q: eth_chainId = %v, want 0x1", origin, message.Result)
+		}
+		if allowed := response.Header.Get("Access-Control-Allow-Origin"); allowed != origin {
+			t.Errorf("origin %q: Access-Control-Allow-Origin = %q", origin, allowed)
+		}
+	}
+}
+
+func TestRPCProxyRequiresJSON(t *testing.T) {
+	proxy := newTestProxy()
+
+	// Content types a page can POST without a preflight are refused
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded", "multipart/form-data; boundary=x"} {
+		response := serveProxy(t, proxy, http.MethodPost, map[string]string{"Content-Type": contentType}, chainIdRequest)
+		if response.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("Content-Type %q: status %d, want %d", contentType, response.StatusCode, http.StatusUnsupportedMediaType)
+		}
+	}
+
+	response := serveProxy(t, proxy, http.MethodPost, map[string]string{"Content-Type": "application/json; charset=utf-8"}, chainIdRequest)
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Content-Type with charset: status %d, want %d", response.StatusCode, http.StatusOK)
+	}
+
+	response = serveProxy(t, proxy, http.MethodGet, map[string]string{"Content-Type": "application/json"}, "")
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want %d", response.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestRPCProxyTransactionHash(t *testing.T) {
+	proxy := newTestProxy()
+
+	hash, err := proxy.transactionHash(json.RawMessage(`{"success":true,"operationSetId":"set-1","operationResponses":[
+		{"hash":"0xbase","chainId":"eip155-8453"},
+		{"hash":"0xmainnet","chainId":"eip155-1"}
+	]}`))
+	if err != nil || hash != "0xmainnet" {
+		t.Errorf("transactionHash = %q, %v, want the hash on the proxy's chain", hash, err)
+	}
+
+	// Without a hash, the error tells the client not to retry and carries the operation set ID
+	_, err = proxy.transactionHash(json.RawMessage(`{"success":true,"operationSetId":"set-1","operationResponses":[]}`))
+	if _, ok := err.(sentWithoutHashError); !ok {
+		t.Fatalf("transactionHash without a hash: got error %v, want sentWithoutHashError", err)
+	}
+	response := methodErrorResponse(json.RawMessage("1"), err)
+	if response.Error.Code != rpcSentWithoutHash || !strings.Contains(response.Error.Message, "do not retry") {
+		t.Errorf("error = %+v, want code %d telling not to retry", response.Error, rpcSentWithoutHash)
+	}
+	if data, ok := response.Error.Data.(map[string]string); !ok || data["operationSetId"] != "set-1" {
+		t.Errorf("error data = %v, want the operation set ID", response.Error.Data)
+	}
+
+	_, err = proxy.transactionHash(json.RawMessage(`"unexpected"`))
+	if _, ok := err.(sentWithoutHashError); !ok {
+		t.Errorf("transactionHash of an unparsable response: got error %v, want sentWithoutHashError", err)
+	}
+}
@@ -0,0 +1,350 @@
+// signer_router.go routes each operation to the configured signer of its From account and chain
+package orby
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"go-app/src/caip"
+)
+
+// SignerRoute is a configured account and how operations from it are signed
+type SignerRoute struct {
+	Account AccountParams
+	// ChainIds limits the chains the account signs on; empty means every chain of its VM
+	ChainIds []caip.ChainID
+	// KeyEnv names the environment variable the key was read from
+	KeyEnv string
+	// WatchOnly accounts have no key; their signatures are collected out-of-band
+	WatchOnly bool
+	// SmartAccount is set for SCA accounts, whose owner key signs in the account's scheme
+	SmartAccount *SmartAccount
+
+	evmKey    *ecdsa.PrivateKey
+	solanaKey ed25519.PrivateKey
+}
+
+// Serves reports whether the route signs operations on a chain
+func (r SignerRoute) Serves(chainId caip.ChainID) bool {
+	if chainId.IsSolana() != (r.Account.VMType == VMTypeSVM) {
+		return false
+	}
+	if len(r.ChainIds) == 0 {
+		return true
+	}
+	for _, allowed := range r.ChainIds {
+		if allowed == chainId {
+			return true
+		}
+	}
+	return false
+}
+
+// Describe returns the account and its signer, for logs and errors
+func (r SignerRoute) Describe() string {
+	signer := "watch-only"
+	if !r.WatchOnly {
+		signer = "key in " + r.KeyEnv
+	}
+	return fmt.Sprintf("%s (%s %s, %s)", r.Account.Address, r.Account.VMType, r.Account.AccountType, signer)
+}
+
+// SignerRouter maps each operation's From address and chain to the configured signer for it
+type SignerRouter struct {
+	routes []SignerRoute
+	// Collector obtains the signatures of watch-only accounts
+	Collector SignatureCollector
+}
+
+// NewSignerRouter creates a router for the given accounts. Accounts with a PrivateKeyEnv are signed
+// with that key (a smart account's key is its owner's); accounts without one are watch-only.
+func NewSignerRouter(configs []ClusterAccountConfig, collector SignatureCollector) (*SignerRouter, error) {
+	router := &SignerRouter{Collector: collector}
+	seen := make(map[string]bool)
+	for _, config := range configs {
+		account, err := config.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		key := AccountsFingerprint([]AccountParams{account})
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		smartAccount, err := config.SmartAccount(account)
+		if err != nil {
+			return nil, err
+		}
+
+		route := SignerRoute{
+			Account:      account,
+			ChainIds:     config.ChainIds,
+			KeyEnv:       config.PrivateKeyEnv,
+			WatchOnly:    config.PrivateKeyEnv == "",
+			SmartAccount: smartAccount,
+		}
+		if !route.WatchOnly {
+			value := strings.TrimSpace(os.Getenv(config.PrivateKeyEnv))
+			if account.VMType == VMTypeSVM {
+				route.solanaKey, err = ParseSolanaPrivateKey(value)
+			} else {
+				route.evmKey, err = ParsePrivateKey(value)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", config.PrivateKeyEnv, err)
+			}
+		}
+		router.routes = append(router.routes, route)
+	}
+	return router, nil
+}
+
+var (
+	defaultSignerRouter     *SignerRouter
+	defaultSignerRouterErr  error
+	defaultSignerRouterOnce sync.Once
+)
+
+// DefaultSignerRouter returns the shared router for the accounts configured in the environment, with
+// watch-only signatures collected through WATCH_ONLY_SIGNATURE_DIR
+func DefaultSignerRouter() (*SignerRouter, error) {
+	defaultSignerRouterOnce.Do(func() {
+		configs, err := ClusterAccountsFromEnv()
+		if err != nil {
+			defaultSignerRouterErr = err
+			return
+		}
+		collector, err := FileSignatureCollectorFromEnv()
+		if err != nil {
+			defaultSignerRouterErr = err
+			return
+		}
+		defaultSignerRouter, defaultSignerRouterErr = NewSignerRouter(configs, collector)
+	})
+	return defaultSignerRouter, defaultSignerRouterErr
+}
+
+// Routes returns the configured signers
+func (r *SignerRouter) Routes() []SignerRoute {
+	return append([]SignerRoute{}, r.routes...)
+}
+
+// Route returns the signer of an operation's From account on its chain. Operations without a From
+// are signed by the first keyed account of their chain's VM, e.g. PRIVATE_KEY.
+func (r *SignerRouter) Route(operation Operation) (SignerRoute, error) {
+	var owned []SignerRoute
+	for _, route := range r.routes {
+		if operation.From == "" {
+			if !route.WatchOnly && route.Account.AccountType == AccountTypeEOA && route.Serves(operation.ChainId) {
+				return route, nil
+			}
+			continue
+		}
+		if sameAddress(route.Account.VMType, route.Account.Address, operation.From) {
+			owned = append(owned, route)
+		}
+	}
+
+	if operation.From == "" {
+		return SignerRoute{}, fmt.Errorf("operation on %s has no from address and no key is configured for its chain",
+			ChainDisplayName(operation.ChainId))
+	}
+	if len(owned) == 0 {
+		return SignerRoute{}, fmt.Errorf("no signer configured for %s; add its key with CLUSTER_ACCOUNTS=env:<VARIABLE>, or list it as a watch-only account",
+			operation.From)
+	}
+
+	// Prefer a key over collecting the signature out-of-band
+	var watchOnly *SignerRoute
+	for i, route := range owned {
+		if !route.Serves(operation.ChainId) {
+			continue
+		}
+		if !route.WatchOnly {
+			return route, nil
+		}
+		if watchOnly == nil {
+			watchOnly = &owned[i]
+		}
+	}
+	if watchOnly != nil {
+		return *watchOnly, nil
+	}
+	return SignerRoute{}, fmt.Errorf("signer %s does not sign on %s", owned[0].Describe(), ChainDisplayName(operation.ChainId))
+}
+
+// HasWatchOnly returns true if an operation of the first intent is signed by a watch-only account
+func (r *SignerRouter) HasWatchOnly(operationSet *OperationSet) bool {
+	if len(operationSet.Intents) == 0 {
+		return false
+	}
+	for _, op := range operationSet.Intents[0].IntentOperations {
+		if route, err := r.Route(op); err == nil && route.WatchOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOperationSet makes sure every operation of the first intent has a signer, so nothing is
+// signed when one of them cannot be
+func (r *SignerRouter) CheckOperationSet(operationSet *OperationSet) error {
+	if len(operationSet.Intents) == 0 {
+		return nil
+	}
+
+	var problems []string
+	for i, op := range operationSet.Intents[0].IntentOperations {
+		if _, err := r.Route(op); err != nil {
+			problems = append(problems, fmt.Sprintf("operation %d (%s): %v", i+1, op.Format, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("cannot sign %d operation(s):\n  %s", len(problems), strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// OperationPrivateKey returns the EVM key that signs an operation
+func (r *SignerRouter) OperationPrivateKey(operation Operation) (*ecdsa.PrivateKey, error) {
+	route, err := r.Route(operation)
+	if err != nil {
+		return nil, err
+	}
+	if route.WatchOnly {
+		return nil, fmt.Errorf("account %s is watch-only; its signature is collected out-of-band", route.Account.Address)
+	}
+	if route.evmKey == nil {
+		return nil, fmt.Errorf("account %s has no EVM key", route.Account.Address)
+	}
+	return route.evmKey, nil
+}
+
+// OperationSmartAccount returns the smart account an operation is signed for, or nil if it is from an EOA
+func (r *SignerRouter) OperationSmartAccount(operation Operation) (*SmartAccount, error) {
+	route, err := r.Route(operation)
+	if err != nil {
+		return nil, err
+	}
+	return route.SmartAccount, nil
+}
+
+// OperationSolanaPrivateKey returns the ed25519 key that signs an SVM operation
+func (r *SignerRouter) OperationSolanaPrivateKey(operation Operation) (ed25519.PrivateKey, error) {
+	route, err := r.Route(operation)
+	if err != nil {
+		return nil, err
+	}
+	if route.WatchOnly {
+		return nil, fmt.Errorf("account %s is watch-only; its signature is collected out-of-band", route.Account.Address)
+	}
+	if route.solanaKey == nil {
+		return nil, fmt.Errorf("account %s has no Solana key", route.Account.Address)
+	}
+	return route.solanaKey, nil
+}
+
+// OperationPrivateKey returns the EVM key that signs an operation with the default router
+func OperationPrivateKey(operation Operation) (*ecdsa.PrivateKey, error) {
+	router, err := DefaultSignerRouter()
+	if err != nil {
+		return nil, err
+	}
+	return router.OperationPrivateKey(operation)
+}
+
+// OperationSmartAccount returns the smart account an operation is signed for with the default router
+func OperationSmartAccount(operation Operation) (*SmartAccount, error) {
+	router, err := DefaultSignerRouter()
+	if err != nil {
+		return nil, err
+	}
+	return router.OperationSmartAccount(operation)
+}
+
+// OperationSolanaPrivateKey returns the ed25519 key that signs an SVM operation with the default router
+func OperationSolanaPrivateKey(operation Operation) (ed25519.PrivateKey, error) {
+	router, err := DefaultSignerRouter()
+	if err != nil {
+		return nil, err
+	}
+	return router.OperationSolanaPrivateKey(operation)
+}
+
+// SignatureCollector obtains the signature of an operation from a watch-only account out-of-band,
+// e.g. from a hardware wallet or another party
+type SignatureCollector interface {
+	Collect(operation Operation) (string, error)
+}
+
+// FileSignatureCollector writes each operation to Dir as <id>.json and waits for its signature to
+// be written next to it as <id>.sig
+type FileSignatureCollector struct {
+	Dir          string
+	Timeout      time.Duration
+	PollInterval time.Duration
+}
+
+// FileSignatureCollectorFromEnv reads WATCH_ONLY_SIGNATURE_DIR (default .orby/pending-signatures) and
+// WATCH_ONLY_SIGNATURE_TIMEOUT_SECONDS (default 300)
+func FileSignatureCollectorFromEnv() (*FileSignatureCollector, error) {
+	timeout, err := strconv.Atoi(GetEnvWithDefault("WATCH_ONLY_SIGNATURE_TIMEOUT_SECONDS", "300"))
+	if err != nil || timeout <= 0 {
+		return nil, fmt.Errorf("invalid WATCH_ONLY_SIGNATURE_TIMEOUT_SECONDS: must be a positive number of seconds")
+	}
+	return &FileSignatureCollector{
+		Dir:          GetEnvWithDefault("WATCH_ONLY_SIGNATURE_DIR", ".orby/pending-signatures"),
+		Timeout:      time.Duration(timeout) * time.Second,
+		PollInterval: time.Second,
+	}, nil
+}
+
+// Collect writes the operation and waits for its signature file
+func (c *FileSignatureCollector) Collect(operation Operation) (string, error) {
+	// 1. Write the operation where the out-of-band signer can pick it up
+	id := operationRequestId(operation)
+	requestPath := filepath.Join(c.Dir, id+".json")
+	signaturePath := filepath.Join(c.Dir, id+".sig")
+	if err := WriteJSONFile(requestPath, operation); err != nil {
+		return "", fmt.Errorf("failed to write signature request %s: %v", requestPath, err)
+	}
+	fmt.Printf("[INFO] %s is watch-only. Sign the operation in %s and write the signature to %s\n",
+		operation.From, requestPath, signaturePath)
+
+	// 2. Wait for the signature
+	deadline := time.Now().Add(c.Timeout)
+	for {
+		data, err := os.ReadFile(signaturePath)
+		if err == nil && strings.TrimSpace(string(data)) != "" {
+			_ = os.Remove(requestPath)
+			_ = os.Remove(signaturePath)
+			return strings.TrimSpace(string(data)), nil
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to read signature %s: %v", signaturePath, err)
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("no signature for %s was written to %s within %s", operation.From, signaturePath, c.Timeout)
+		}
+		time.Sleep(c.PollInterval)
+	}
+}
+
+// operationRequestId identifies an operation by the hash of its chain, sender, format and data
+func operationRequestId(operation Operation) string {
+	encoded, _ := json.Marshal([]string{operation.ChainId.String(), operation.From, operation.Format, operation.Data})
+	return hexutil.Encode(crypto.Keccak256(encoded)[:8])[2:]
+}
@@ -0,0 +1,178 @@
+// signers.go maps operation formats to the functions that sign them
+package orby
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Operation formats with a built-in signer
+const (
+	TransactionFormat = "TRANSACTION"
+	TypedDataFormat   = "TYPED_DATA"
+	// MessageFormat is a personal_sign (EIP-191) message
+	MessageFormat = "MESSAGE"
+)
+
+// OperationSigner signs an operation and returns its signature as a hex string
+type OperationSigner func(operation Operation) (string, error)
+
+// SignerRegistry maps operation formats to their signers
+type SignerRegistry struct {
+	mu      sync.RWMutex
+	signers map[string]OperationSigner
+}
+
+// NewSignerRegistry creates a registry with the built-in signers
+func NewSignerRegistry() *SignerRegistry {
+	registry := &SignerRegistry{signers: make(map[string]OperationSigner)}
+	registry.Register(TransactionFormat, SignTransaction)
+	registry.Register(TypedDataFormat, SignTypedData)
+	registry.Register(MessageFormat, SignMessage)
+	registry.Register(UserOperationFormat, SignUserOperation)
+	registry.Register(AuthorizationFormat, SignAuthorizationOperation)
+	return registry
+}
+
+var (
+	defaultSignerRegistry     *SignerRegistry
+	defaultSignerRegistryOnce sync.Once
+)
+
+// DefaultSignerRegistry returns the shared registry used by SignOperationSet
+func DefaultSignerRegistry() *SignerRegistry {
+	defaultSignerRegistryOnce.Do(func() {
+		defaultSignerRegistry = NewSignerRegistry()
+	})
+	return defaultSignerRegistry
+}
+
+// RegisterOperationSigner adds or replaces the signer of a format in the default registry
+func RegisterOperationSigner(format string, signer OperationSigner) {
+	DefaultSignerRegistry().Register(format, signer)
+}
+
+// Register adds or replaces the signer of a format
+func (r *SignerRegistry) Register(format string, signer OperationSigner) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.signers[strings.ToUpper(format)] = signer
+}
+
+// Get returns the signer of a format
+func (r *SignerRegistry) Get(format string) (OperationSigner, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	signer, ok := r.signers[strings.ToUpper(format)]
+	return signer, ok
+}
+
+// Formats returns the registered formats in sorted order
+func (r *SignerRegistry) Formats() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	formats := make([]string, 0, len(r.signers))
+	for format := range r.signers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// Sign signs an operation with the signer registered for its format
+func (r *SignerRegistry) Sign(operation Operation) (string, error) {
+	signer, ok := r.Get(operation.Format)
+	if !ok {
+		return "", fmt.Errorf("no signer for format %q (supported: %s)", operation.Format, strings.Join(r.Formats(), ", "))
+	}
+	return signer(operation)
+}
+
+// MessageBytes returns the bytes of a MESSAGE operation's data. 0x-prefixed hex is decoded,
+// anything else is signed as UTF-8 text, as personal_sign does.
+func MessageBytes(data string) []byte {
+	if decoded, err := hexutil.Decode(data); err == nil {
+		return decoded
+	}
+	return []byte(data)
+}
+
+// SignMessage signs a MESSAGE operation with personal_sign (EIP-191 version 0x45), or for the smart
+// account in its scheme if the operation is from one
+func SignMessage(operation Operation) (string, error) {
+	// Get the private key of the operation's account
+	privateKey, err := OperationPrivateKey(operation)
+	if err != nil {
+		return "", err
+	}
+
+	// 1. Hash the message with the "\x19Ethereum Signed Message:\n" prefix
+	message := MessageBytes(operation.Data)
+	if utf8.Valid(message) {
+		fmt.Printf("Message to sign: %q\n", string(message))
+	} else {
+		fmt.Printf("Message to sign: %s\n", hexutil.Encode(message))
+	}
+	hash := common.BytesToHash(accounts.TextHash(message))
+	fmt.Printf("EIP-191 message hash: %s\n", hash.Hex())
+
+	// 2. Operations from a smart account are signed by its owner key in the account's scheme
+	account, err := OperationSmartAccount(operation)
+	if err != nil {
+		return "", err
+	}
+	if account != nil {
+		chainId, err := operation.ChainId.BigInt()
+		if err != nil {
+			return "", err
+		}
+		signature, err := account.Sign(chainId, hash, privateKey)
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("Signed for smart account %s (%s scheme)\n", account.Address.Hex(), account.Scheme)
+		return hexutil.Encode(signature), nil
+	}
+
+	// 3. Sign the hash
+	signature, err := crypto.Sign(hash.Bytes(), privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign message: %v", err)
+	}
+
+	// 4. Verify the signature by recovering the public key
+	pubKey, err := crypto.SigToPub(hash.Bytes(), signature)
+	if err != nil {
+		return "", fmt.Errorf("failed to recover public key from signature: %v", err)
+	}
+	if recovered, expected := crypto.PubkeyToAddress(*pubKey), crypto.PubkeyToAddress(privateKey.PublicKey); recovered != expected {
+		return "", fmt.Errorf("signature verification failed: recovered address %s does not match original address %s",
+			recovered.Hex(), expected.Hex())
+	}
+
+	// 5. Adjust v value (add 27) for Ethereum compatibility
+	signature[64] += 27
+
+	return hexutil.Encode(signature), nil
+}
+
+// OperationSigningHash returns the hash a TYPED_DATA or MESSAGE operation's signature is over,
+// as a contract account's isValidSignature receives it
+func OperationSigningHash(operation Operation) (common.Hash, error) {
+	switch strings.ToUpper(operation.Format) {
+	case TypedDataFormat:
+		return OperationTypedDataHash(operation)
+	case MessageFormat:
+		return common.BytesToHash(accounts.TextHash(MessageBytes(operation.Data))), nil
+	}
+	return common.Hash{}, fmt.Errorf("operations of format %s are not signed over a message hash", operation.Format)
+}
@@ -0,0 +1,183 @@
+// smart_account.go signs for smart contract accounts and checks their signatures with ERC-1271
+package orby
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// Account types used when creating account clusters
+const (
+	AccountTypeEOA = "EOA"
+	// AccountTypeSCA is a smart contract account
+	AccountTypeSCA = "SCA"
+)
+
+// ABINameERC1271 is the name of the embedded ERC-1271 ABI
+const ABINameERC1271 = "erc1271"
+
+// ERC1271MagicValue is returned by isValidSignature(bytes32,bytes) for a valid signature
+var ERC1271MagicValue = [4]byte{0x16, 0x26, 0xba, 0x7e}
+
+// SignatureScheme is how a smart account expects its owner's signature to be produced and wrapped
+type SignatureScheme string
+
+const (
+	// SignatureSchemeOwner passes the owner's ECDSA signature of the hash through unchanged,
+	// as simple accounts with a single ECDSA owner expect
+	SignatureSchemeOwner SignatureScheme = "owner"
+	// SignatureSchemePrefixed prepends a fixed prefix to the owner's signature, such as the
+	// validator mode and address that modular accounts use to route signature checks
+	SignatureSchemePrefixed SignatureScheme = "prefixed"
+	// SignatureSchemeSafe has the owner sign the Safe's SafeMessage hash of the original hash,
+	// as the Safe fallback handler checks it
+	SignatureSchemeSafe SignatureScheme = "safe"
+)
+
+// SmartAccount is a smart contract account whose signatures come from an ECDSA owner key
+type SmartAccount struct {
+	Address common.Address
+	Scheme  SignatureScheme
+	// Prefix is prepended to signatures with SignatureSchemePrefixed
+	Prefix []byte
+}
+
+// SmartAccountFromEnv reads the smart account from SMART_ACCOUNT_ADDRESS, SMART_ACCOUNT_SCHEME
+// (owner by default) and SMART_ACCOUNT_SIGNATURE_PREFIX. It returns nil if no address is set.
+func SmartAccountFromEnv() (*SmartAccount, error) {
+	address := GetEnvWithDefault("SMART_ACCOUNT_ADDRESS", "")
+	if address == "" {
+		return nil, nil
+	}
+	account, err := NewSmartAccount(
+		address,
+		GetEnvWithDefault("SMART_ACCOUNT_SCHEME", ""),
+		GetEnvWithDefault("SMART_ACCOUNT_SIGNATURE_PREFIX", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMART_ACCOUNT_* settings: %v", err)
+	}
+	return account, nil
+}
+
+// NewSmartAccount creates a smart account signing in the given scheme (owner if empty). prefix is
+// the 0x-prefixed hex prefix of the prefixed scheme.
+func NewSmartAccount(address string, scheme string, prefix string) (*SmartAccount, error) {
+	if !common.IsHexAddress(address) {
+		return nil, fmt.Errorf("invalid smart account address: %s", address)
+	}
+	if scheme == "" {
+		scheme = string(SignatureSchemeOwner)
+	}
+
+	account := &SmartAccount{
+		Address: common.HexToAddress(address),
+		Scheme:  SignatureScheme(strings.ToLower(scheme)),
+	}
+
+	switch account.Scheme {
+	case SignatureSchemeOwner, SignatureSchemeSafe:
+	case SignatureSchemePrefixed:
+		decoded, err := hexutil.Decode(prefix)
+		if err != nil || len(decoded) == 0 {
+			return nil, fmt.Errorf("smart account %s needs a 0x-prefixed hex signature prefix for scheme %s", account.Address.Hex(), account.Scheme)
+		}
+		account.Prefix = decoded
+	default:
+		return nil, fmt.Errorf("unknown signature scheme %q for smart account %s (expected %s, %s or %s)",
+			account.Scheme, account.Address.Hex(), SignatureSchemeOwner, SignatureSchemePrefixed, SignatureSchemeSafe)
+	}
+
+	return account, nil
+}
+
+// Owns reports whether an operation's from address is the smart account
+func (a *SmartAccount) Owns(from string) bool {
+	return common.IsHexAddress(from) && common.HexToAddress(from) == a.Address
+}
+
+// OwnerHash returns the hash the owner key signs for a hash the account is asked to sign
+func (a *SmartAccount) OwnerHash(chainId *big.Int, hash common.Hash) (common.Hash, error) {
+	if a.Scheme != SignatureSchemeSafe {
+		return hash, nil
+	}
+
+	// SafeMessage(bytes message) with the original hash ABI-encoded as the message, in the
+	// Safe's EIP712Domain(uint256 chainId,address verifyingContract)
+	safeMessage := &apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "chainId", Type: "uint256"},
+				{Name: "verifyingContract", Type: "address"},
+			},
+			"SafeMessage": {{Name: "message", Type: "bytes"}},
+		},
+		PrimaryType: "SafeMessage",
+		Domain: apitypes.TypedDataDomain{
+			ChainId:           (*math.HexOrDecimal256)(new(big.Int).Set(chainId)),
+			VerifyingContract: a.Address.Hex(),
+		},
+		Message: apitypes.TypedDataMessage{"message": hexutil.Encode(hash.Bytes())},
+	}
+	return TypedDataHash(safeMessage)
+}
+
+// WrapSignature wraps the owner's 65-byte signature in the format the account checks
+func (a *SmartAccount) WrapSignature(ownerSignature []byte) []byte {
+	if a.Scheme == SignatureSchemePrefixed {
+		return append(append([]byte{}, a.Prefix...), ownerSignature...)
+	}
+	return ownerSignature
+}
+
+// Sign produces the account's signature of hash with the owner key
+func (a *SmartAccount) Sign(chainId *big.Int, hash common.Hash, ownerKey *ecdsa.PrivateKey) ([]byte, error) {
+	ownerHash, err := a.OwnerHash(chainId, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := crypto.Sign(ownerHash.Bytes(), ownerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign hash: %v", err)
+	}
+
+	// Adjust v value (add 27) for Ethereum compatibility
+	signature[64] += 27
+
+	return a.WrapSignature(signature), nil
+}
+
+// IsValidSignature simulates isValidSignature(hash, signature) on a contract account with eth_call
+// and reports whether it returned the ERC-1271 magic value
+func (c *OrbyClient) IsValidSignature(rpcUrl string, account common.Address, hash common.Hash, signature []byte) (bool, error) {
+	registry, err := DefaultABIRegistry()
+	if err != nil {
+		return false, err
+	}
+	erc1271Abi, err := registry.Get(ABINameERC1271)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := erc1271Abi.Pack("isValidSignature", hash, signature)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := c.EthCall(rpcUrl, account.Hex(), data)
+	if err != nil {
+		return false, fmt.Errorf("isValidSignature call to %s failed: %v", account.Hex(), err)
+	}
+
+	// Accounts return the magic value left-aligned in a 32-byte word
+	return len(result) >= 4 && bytes.Equal(result[:4], ERC1271MagicValue[:]), nil
+}
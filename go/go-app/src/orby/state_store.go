@@ -0,0 +1,329 @@
+// state_store.go remembers the Orby instances, account clusters and virtual nodes created per profile,
+// so they are reused across runs instead of being created again
+package orby
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-app/src/caip"
+)
+
+// InstanceState is an Orby instance created for a profile, along with its account clusters
+type InstanceState struct {
+	Profile        string                  `json:"profile"`
+	Name           string                  `json:"name"`
+	EngineAdminURL string                  `json:"engineAdminUrl"`
+	PrivateURL     string                  `json:"privateUrl"`
+	PublicURL      string                  `json:"publicUrl"`
+	CreatedAt      time.Time               `json:"createdAt"`
+	Clusters       map[string]ClusterState `json:"clusters,omitempty"`
+}
+
+// ClusterState is an account cluster and the virtual nodes obtained for it
+type ClusterState struct {
+	AccountClusterId string                      `json:"accountClusterId"`
+	Accounts         []AccountParams             `json:"accounts"`
+	CreatedAt        time.Time                   `json:"createdAt"`
+	VirtualNodes     map[string]VirtualNodeState `json:"virtualNodes,omitempty"`
+}
+
+// VirtualNodeState is a virtual node RPC URL for a chain and entrypoint account
+type VirtualNodeState struct {
+	ChainId                  caip.ChainID `json:"chainId"`
+	EntrypointAccountAddress string       `json:"entrypointAccountAddress"`
+	RpcUrl                   string       `json:"rpcUrl"`
+	CreatedAt                time.Time    `json:"createdAt"`
+}
+
+// Matches reports whether the instance was created with the given engine admin URL and name
+func (s *InstanceState) Matches(engineAdminURL string, name string) bool {
+	return s.EngineAdminURL == engineAdminURL && s.Name == name
+}
+
+// Cluster returns the cluster created for a set of accounts, in any order
+func (s *InstanceState) Cluster(accounts []AccountParams) (ClusterState, bool) {
+	cluster, ok := s.Clusters[AccountsFingerprint(accounts)]
+	return cluster, ok
+}
+
+// ClusterById returns the cluster with the given ID
+func (s *InstanceState) ClusterById(accountClusterId string) (ClusterState, bool) {
+	for _, cluster := range s.Clusters {
+		if cluster.AccountClusterId == accountClusterId {
+			return cluster, true
+		}
+	}
+	return ClusterState{}, false
+}
+
+// SetCluster records a cluster under the fingerprint of its accounts
+func (s *InstanceState) SetCluster(cluster ClusterState) {
+	if s.Clusters == nil {
+		s.Clusters = make(map[string]ClusterState)
+	}
+	s.Clusters[AccountsFingerprint(cluster.Accounts)] = cluster
+}
+
+// RemoveCluster forgets a cluster, reporting whether it was recorded
+func (s *InstanceState) RemoveCluster(accountClusterId string) bool {
+	for key, cluster := range s.Clusters {
+		if cluster.AccountClusterId == accountClusterId {
+			delete(s.Clusters, key)
+			return true
+		}
+	}
+	return false
+}
+
+// SortedClusters returns the instance's clusters, oldest first
+func (s *InstanceState) SortedClusters() []ClusterState {
+	clusters := make([]ClusterState, 0, len(s.Clusters))
+	for _, cluster := range s.Clusters {
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].CreatedAt.Before(clusters[j].CreatedAt) })
+	return clusters
+}
+
+// VirtualNode returns the virtual node recorded for a chain and entrypoint account
+func (c *ClusterState) VirtualNode(chainId caip.ChainID, entrypointAccountAddress string) (VirtualNodeState, bool) {
+	node, ok := c.VirtualNodes[virtualNodeKey(chainId, entrypointAccountAddress)]
+	return node, ok
+}
+
+// SetVirtualNode records a virtual node
+func (c *ClusterState) SetVirtualNode(node VirtualNodeState) {
+	if c.VirtualNodes == nil {
+		c.VirtualNodes = make(map[string]VirtualNodeState)
+	}
+	c.VirtualNodes[virtualNodeKey(node.ChainId, node.EntrypointAccountAddress)] = node
+}
+
+// SortedVirtualNodes returns the cluster's virtual nodes sorted by chain and entrypoint
+func (c *ClusterState) SortedVirtualNodes() []VirtualNodeState {
+	keys := make([]string, 0, len(c.VirtualNodes))
+	for key := range c.VirtualNodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	nodes := make([]VirtualNodeState, len(keys))
+	for i, key := range keys {
+		nodes[i] = c.VirtualNodes[key]
+	}
+	return nodes
+}
+
+// AccountsFingerprint identifies a set of cluster accounts regardless of their order or address case
+func AccountsFingerprint(accounts []AccountParams) string {
+	parts := make([]string, len(accounts))
+	for i, account := range accounts {
+		address := account.Address
+		if account.VMType != VMTypeSVM {
+			// Base58 Solana addresses are case-sensitive
+			address = strings.ToLower(address)
+		}
+		parts[i] = account.VMType + ":" + account.AccountType + ":" + address
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func virtualNodeKey(chainId caip.ChainID, entrypointAccountAddress string) string {
+	return chainId.String() + "/" + strings.ToLower(entrypointAccountAddress)
+}
+
+// StateStore keeps instance state per profile in a JSON file
+type StateStore struct {
+	mu        sync.Mutex
+	path      string
+	instances map[string]InstanceState
+}
+
+// NewStateStore opens the state stored at path, which is created on the first save
+func NewStateStore(path string) (*StateStore, error) {
+	store := &StateStore{
+		path:      path,
+		instances: make(map[string]InstanceState),
+	}
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// DefaultStateStore opens the state stored in ORBY_STATE_FILE
+func DefaultStateStore() (*StateStore, error) {
+	return NewStateStore(GetEnvWithDefault("ORBY_STATE_FILE", ".orby/state.json"))
+}
+
+// StateProfile returns the profile state is recorded under: ORBY_PROFILE, or the instance name
+func StateProfile() string {
+	return GetEnvWithDefault("ORBY_PROFILE", GetEnvWithDefault("ORBY_INSTANCE_NAME", "default"))
+}
+
+// Path returns the file the state is stored in
+func (s *StateStore) Path() string {
+	return s.path
+}
+
+// Instance returns the instance recorded for a profile
+func (s *StateStore) Instance(profile string) (InstanceState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	instance, ok := s.instances[profile]
+	return instance, ok
+}
+
+// Instances returns the recorded instances sorted by profile
+func (s *StateStore) Instances() []InstanceState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instances := make([]InstanceState, 0, len(s.instances))
+	for _, instance := range s.instances {
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Profile < instances[j].Profile })
+	return instances
+}
+
+// PutInstance records an instance under its profile and saves the store
+func (s *StateStore) PutInstance(instance InstanceState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instances[instance.Profile] = instance
+	return s.save()
+}
+
+// Forget removes a profile's instance and clusters from the store. It does not delete them in Orby.
+func (s *StateStore) Forget(profile string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.instances[profile]; !ok {
+		return fmt.Errorf("no state recorded for profile %q", profile)
+	}
+	delete(s.instances, profile)
+	return s.save()
+}
+
+// ForgetCluster removes a cluster from a profile's instance, so the next run creates a new one
+func (s *StateStore) ForgetCluster(profile string, accountClusterId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	instance, ok := s.instances[profile]
+	if !ok {
+		return fmt.Errorf("no state recorded for profile %q", profile)
+	}
+	if !instance.RemoveCluster(accountClusterId) {
+		return fmt.Errorf("no account cluster %s recorded for profile %q", accountClusterId, profile)
+	}
+	s.instances[profile] = instance
+	return s.save()
+}
+
+// SetVirtualNode records a virtual node of one of a profile's account clusters
+func (s *StateStore) SetVirtualNode(profile string, accountClusterId string, node VirtualNodeState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	instance, ok := s.instances[profile]
+	if !ok {
+		return fmt.Errorf("no state recorded for profile %q", profile)
+	}
+	cluster, ok := instance.ClusterById(accountClusterId)
+	if !ok {
+		return fmt.Errorf("no account cluster %s recorded for profile %q", accountClusterId, profile)
+	}
+	cluster.SetVirtualNode(node)
+	instance.SetCluster(cluster)
+	s.instances[profile] = instance
+	return s.save()
+}
+
+// UpdateInstanceUrls records new URLs for the profiles using an instance, e.g. after they were rotated.
+// Their virtual nodes are forgotten, since they may be served under the old URLs. It returns the
+// number of profiles updated.
+func (s *StateStore) UpdateInstanceUrls(engineAdminURL string, name string, privateURL string, publicURL string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := 0
+	for profile, instance := range s.instances {
+		if !instance.Matches(engineAdminURL, name) {
+			continue
+		}
+		instance.PrivateURL = privateURL
+		instance.PublicURL = publicURL
+		for key, cluster := range instance.Clusters {
+			cluster.VirtualNodes = nil
+			instance.Clusters[key] = cluster
+		}
+		s.instances[profile] = instance
+		updated++
+	}
+	if updated == 0 {
+		return 0, nil
+	}
+	return updated, s.save()
+}
+
+// ForgetInstance removes the profiles using an instance, e.g. after it was deleted. It returns the
+// number of profiles removed.
+func (s *StateStore) ForgetInstance(engineAdminURL string, name string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for profile, instance := range s.instances {
+		if instance.Matches(engineAdminURL, name) {
+			delete(s.instances, profile)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.save()
+}
+
+// load reads the state file
+func (s *StateStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file %s: %v", s.path, err)
+	}
+
+	var state struct {
+		Instances map[string]InstanceState `json:"instances"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse state file %s: %v", s.path, err)
+	}
+	if state.Instances != nil {
+		s.instances = state.Instances
+	}
+	return nil
+}
+
+// save writes the state file
+func (s *StateStore) save() error {
+	state := struct {
+		Instances map[string]InstanceState `json:"instances"`
+	}{Instances: s.instances}
+
+	if err := WriteJSONFile(s.path, state); err != nil {
+		return fmt.Errorf("failed to write state file %s: %v", s.path, err)
+	}
+	return nil
+}
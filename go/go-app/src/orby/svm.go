@@ -0,0 +1,264 @@
+// svm.go supports Solana (SVM) accounts: ed25519 keys, solana: chain IDs and transaction signing
+package orby
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"go-app/src/caip"
+)
+
+// VM types used when creating account clusters
+const (
+	VMTypeEVM = "EVM"
+	VMTypeSVM = "SVM"
+)
+
+// CAIP-2 chain IDs of the Solana clusters, made of the "solana" namespace and the first 32
+// characters of the cluster's genesis hash
+var (
+	SolanaMainnetChainId = caip.MustParseChainID("solana:5eykt4UsFv8P8NJdTREpY1vzqKqZKvdp")
+	SolanaDevnetChainId  = caip.MustParseChainID("solana:EtWTRABZaYq6iMfeYKouRu166VL1wN8K")
+	SolanaTestnetChainId = caip.MustParseChainID("solana:4uhcVJyU9pJkvQyS88uRDiswHXSCkY3z")
+)
+
+// GetSolanaPrivateKey returns the ed25519 key in SOLANA_PRIVATE_KEY, or nil if it is not set
+func GetSolanaPrivateKey() (ed25519.PrivateKey, error) {
+	value := strings.TrimSpace(GetEnvWithDefault("SOLANA_PRIVATE_KEY", ""))
+	if value == "" {
+		return nil, nil
+	}
+
+	privateKey, err := ParseSolanaPrivateKey(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOLANA_PRIVATE_KEY: %v", err)
+	}
+	return privateKey, nil
+}
+
+// ParseSolanaPrivateKey parses an ed25519 key given either as a base58 64-byte secret key (as wallets
+// export it) or a JSON byte array (as solana-keygen writes it); a 32-byte seed is also accepted.
+func ParseSolanaPrivateKey(value string) (ed25519.PrivateKey, error) {
+	value = strings.TrimSpace(value)
+
+	var keyBytes []byte
+	if strings.HasPrefix(value, "[") {
+		var ints []int
+		if err := json.Unmarshal([]byte(value), &ints); err != nil {
+			return nil, fmt.Errorf("invalid byte array: %v", err)
+		}
+		keyBytes = make([]byte, len(ints))
+		for i, v := range ints {
+			if v < 0 || v > 255 {
+				return nil, fmt.Errorf("invalid byte array: %d is not a byte", v)
+			}
+			keyBytes[i] = byte(v)
+		}
+	} else {
+		decoded, err := Base58Decode(value)
+		if err != nil {
+			return nil, err
+		}
+		keyBytes = decoded
+	}
+
+	switch len(keyBytes) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(keyBytes), nil
+	case ed25519.PrivateKeySize:
+		// The secret key is seed || public key; rebuild it from the seed and check the public half
+		privateKey := ed25519.NewKeyFromSeed(keyBytes[:ed25519.SeedSize])
+		if !privateKey.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(keyBytes[ed25519.SeedSize:])) {
+			return nil, fmt.Errorf("public key does not match the seed")
+		}
+		return privateKey, nil
+	}
+	return nil, fmt.Errorf("expected 32 or 64 bytes, got %d", len(keyBytes))
+}
+
+// SolanaAddress returns the base58 address of an ed25519 public key
+func SolanaAddress(publicKey ed25519.PublicKey) string {
+	return Base58Encode(publicKey)
+}
+
+// SignSolanaTransaction signs an SVM TRANSACTION operation. Its data is a serialized legacy or v0
+// transaction in base64 (or base58), and the signed transaction is returned in base64.
+func SignSolanaTransaction(operation Operation) (string, error) {
+	// Get the private key of the operation's account
+	privateKey, err := OperationSolanaPrivateKey(operation)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Println("Solana transaction to sign:")
+	fmt.Println(operation.Data)
+	fmt.Println("Chain ID:", operation.ChainId)
+
+	return signSolanaTransaction(operation.Data, privateKey)
+}
+
+// signSolanaTransaction puts the key's signature of a serialized transaction in its signer's slot
+func signSolanaTransaction(data string, privateKey ed25519.PrivateKey) (string, error) {
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	// 1. Decode the transaction and split it into signatures and message
+	var txBytes []byte
+	var tx *solanaTransaction
+	var err error
+	for _, decode := range []func(string) ([]byte, error){base64.StdEncoding.DecodeString, Base58Decode} {
+		if txBytes, err = decode(data); err != nil {
+			continue
+		}
+		if tx, err = parseSolanaTransaction(txBytes); err == nil {
+			break
+		}
+	}
+	if tx == nil {
+		return "", fmt.Errorf("solana transaction must be a base64 or base58 serialized transaction: %v", err)
+	}
+
+	// 2. Find this key among the required signers
+	signerIndex := -1
+	for i, key := range tx.signerKeys {
+		if ed25519.PublicKey(key).Equal(publicKey) {
+			signerIndex = i
+			break
+		}
+	}
+	if signerIndex < 0 {
+		return "", fmt.Errorf("%s is not a required signer of the transaction", SolanaAddress(publicKey))
+	}
+
+	// 3. Sign the message and put the signature in the signer's slot
+	signature := ed25519.Sign(privateKey, tx.message)
+	copy(txBytes[tx.signaturesOffset+signerIndex*ed25519.SignatureSize:], signature)
+
+	fmt.Printf("Signed as signer %d of %d: %s\n", signerIndex+1, len(tx.signerKeys), SolanaAddress(publicKey))
+	fmt.Printf("Signature: %s\n", Base58Encode(signature))
+
+	return base64.StdEncoding.EncodeToString(txBytes), nil
+}
+
+// solanaTransaction is the part of a serialized transaction needed to sign it
+type solanaTransaction struct {
+	signaturesOffset int
+	message          []byte
+	signerKeys       [][]byte
+}
+
+// parseSolanaTransaction reads the wire format: a compact-u16 signature count and the signatures,
+// then the message. Legacy and v0 messages share the header and account keys layout, v0 messages
+// being prefixed with 0x80.
+func parseSolanaTransaction(txBytes []byte) (*solanaTransaction, error) {
+	signatureCount, offset, err := readCompactU16(txBytes, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction: %v", err)
+	}
+	messageOffset := offset + signatureCount*ed25519.SignatureSize
+	if messageOffset > len(txBytes) {
+		return nil, fmt.Errorf("invalid transaction: truncated signatures")
+	}
+	message := txBytes[messageOffset:]
+
+	// Message header
+	cursor := 0
+	if len(message) > 0 && message[0]&0x80 != 0 {
+		if version := message[0] & 0x7f; version != 0 {
+			return nil, fmt.Errorf("unsupported transaction message version %d", version)
+		}
+		cursor++
+	}
+	if len(message) < cursor+3 {
+		return nil, fmt.Errorf("invalid transaction: truncated message header")
+	}
+	requiredSignatures := int(message[cursor])
+	cursor += 3
+
+	// Account keys, the first requiredSignatures of which must sign
+	keyCount, cursor, err := readCompactU16(message, cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction: %v", err)
+	}
+	if requiredSignatures != signatureCount || requiredSignatures > keyCount {
+		return nil, fmt.Errorf("invalid transaction: %d signatures for %d required signers", signatureCount, requiredSignatures)
+	}
+	if len(message) < cursor+keyCount*ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid transaction: truncated account keys")
+	}
+
+	tx := &solanaTransaction{signaturesOffset: offset, message: message}
+	for i := 0; i < requiredSignatures; i++ {
+		start := cursor + i*ed25519.PublicKeySize
+		tx.signerKeys = append(tx.signerKeys, message[start:start+ed25519.PublicKeySize])
+	}
+	return tx, nil
+}
+
+// readCompactU16 reads Solana's variable-length u16 (7 bits per byte, high bit set if more follow)
+func readCompactU16(data []byte, offset int) (int, int, error) {
+	value := 0
+	for i := 0; i < 3; i++ {
+		if offset >= len(data) {
+			return 0, 0, fmt.Errorf("truncated compact-u16")
+		}
+		b := data[offset]
+		offset++
+		value |= int(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return value, offset, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("compact-u16 is too long")
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// Base58Encode encodes bytes with the Bitcoin base58 alphabet used for Solana keys and signatures
+func Base58Encode(data []byte) string {
+	value := new(big.Int).SetBytes(data)
+	base := big.NewInt(58)
+	mod := new(big.Int)
+
+	var encoded []byte
+	for value.Sign() > 0 {
+		value.DivMod(value, base, mod)
+		encoded = append(encoded, base58Alphabet[mod.Int64()])
+	}
+	// Leading zero bytes are encoded as leading '1's
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
+
+// Base58Decode decodes a base58 string
+func Base58Decode(input string) ([]byte, error) {
+	value := new(big.Int)
+	base := big.NewInt(58)
+	for _, c := range input {
+		digit := strings.IndexRune(base58Alphabet, c)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		value.Mul(value, base)
+		value.Add(value, big.NewInt(int64(digit)))
+	}
+
+	decoded := value.Bytes()
+	leadingZeros := 0
+	for leadingZeros < len(input) && input[leadingZeros] == base58Alphabet[0] {
+		leadingZeros++
+	}
+	return append(make([]byte, leadingZeros), decoded...), nil
+}
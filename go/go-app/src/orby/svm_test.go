@@ -0,0 +1,242 @@
+package orby
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The key of RFC 8032's first ed25519 test vector
+const (
+	testSolanaSeed      = "9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60"
+	testSolanaPublicKey = "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"
+)
+
+func testSolanaKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	seed, err := hex.DecodeString(testSolanaSeed)
+	if err != nil {
+		t.Fatalf("DecodeString: %v", err)
+	}
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+func TestBase58(t *testing.T) {
+	tests := []struct {
+		data    string
+		encoded string
+	}{
+		{data: "", encoded: ""},
+		{data: "61", encoded: "2g"},
+		{data: "626262", encoded: "a3gV"},
+		{data: "516b6fcd0f", encoded: "ABnLTmg"},
+		{data: "73696d706c792061206c6f6e6720737472696e67", encoded: "2cFupjhnEsSn59qHXstmK2ffpLv2"},
+		// Leading zero bytes are leading '1's
+		{data: "00", encoded: "1"},
+		{data: "000001", encoded: "112"},
+		{data: "00eb15231dfceb60925886b67d065299925915aeb172c06647", encoded: "1NS17iag9jJgTHD1VXjvLCEnZuQ3rJDE9L"},
+		{data: "00000000000000000000", encoded: "1111111111"},
+		// The system program's address is 32 zero bytes
+		{data: strings.Repeat("00", 32), encoded: "11111111111111111111111111111111"},
+	}
+
+	for _, test := range tests {
+		data, _ := hex.DecodeString(test.data)
+		if got := Base58Encode(data); got != test.encoded {
+			t.Errorf("Base58Encode(%s) = %q, want %q", test.data, got, test.encoded)
+		}
+		decoded, err := Base58Decode(test.encoded)
+		if err != nil {
+			t.Errorf("Base58Decode(%q): %v", test.encoded, err)
+			continue
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("Base58Decode(%q) = %x, want %s", test.encoded, decoded, test.data)
+		}
+	}
+
+	for _, invalid := range []string{"0", "O", "I", "l", "1+", "abc def"} {
+		if decoded, err := Base58Decode(invalid); err == nil {
+			t.Errorf("Base58Decode(%q) = %x, want an error", invalid, decoded)
+		}
+	}
+}
+
+func TestReadCompactU16(t *testing.T) {
+	tests := []struct {
+		data    []byte
+		value   int
+		offset  int
+		wantErr bool
+	}{
+		{data: []byte{0x00}, value: 0, offset: 1},
+		{data: []byte{0x7f}, value: 0x7f, offset: 1},
+		{data: []byte{0x80, 0x01}, value: 0x80, offset: 2},
+		{data: []byte{0xff, 0x7f}, value: 0x3fff, offset: 2},
+		{data: []byte{0x80, 0x80, 0x01}, value: 0x4000, offset: 3},
+		{data: []byte{0xff, 0xff, 0x03}, value: 0xffff, offset: 3},
+		{data: []byte{0x05, 0xff}, value: 5, offset: 1},
+		{data: []byte{}, wantErr: true},
+		{data: []byte{0x80}, wantErr: true},
+		{data: []byte{0x80, 0x80, 0x80, 0x01}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		value, offset, err := readCompactU16(test.data, 0)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("readCompactU16(%x) = %d, want an error", test.data, value)
+			}
+			continue
+		}
+		if err != nil || value != test.value || offset != test.offset {
+			t.Errorf("readCompactU16(%x) = %d, %d, %v, want %d, %d", test.data, value, offset, err, test.value, test.offset)
+		}
+	}
+}
+
+func TestParseSolanaPrivateKey(t *testing.T) {
+	privateKey := testSolanaKey(t)
+	if publicKey := hex.EncodeToString(privateKey.Public().(ed25519.PublicKey)); publicKey != testSolanaPublicKey {
+		t.Fatalf("public key = %s, want %s", publicKey, testSolanaPublicKey)
+	}
+
+	// The base58 secret key wallets export, the byte array solana-keygen writes and the bare seed
+	byteArray := make([]string, len(privateKey))
+	for i, b := range privateKey {
+		byteArray[i] = fmt.Sprint(b)
+	}
+	for _, value := range []string{
+		Base58Encode(privateKey),
+		"[" + strings.Join(byteArray, ",") + "]",
+		" " + Base58Encode(privateKey.Seed()) + "\n",
+	} {
+		parsed, err := ParseSolanaPrivateKey(value)
+		if err != nil {
+			t.Errorf("ParseSolanaPrivateKey(%q): %v", value, err)
+			continue
+		}
+		if !parsed.Equal(privateKey) {
+			t.Errorf("ParseSolanaPrivateKey(%q) = %x, want %x", value, parsed, privateKey)
+		}
+	}
+
+	// A secret key whose public half is not the seed's is refused
+	mismatched := append(append([]byte(nil), privateKey.Seed()...), make([]byte, ed25519.PublicKeySize)...)
+	for _, value := range []string{Base58Encode(mismatched), Base58Encode(privateKey[:40]), "[256]", "[-1]", "not base58!"} {
+		if _, err := ParseSolanaPrivateKey(value); err == nil {
+			t.Errorf("ParseSolanaPrivateKey(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+// testSolanaTransaction serializes an unsigned transfer from the fee payer to a recipient, signed by
+// the fee payer and a second signer
+func testSolanaTransaction(feePayer, signer ed25519.PublicKey, v0 bool) (txBytes []byte, message []byte) {
+	recipient := bytes.Repeat([]byte{0x22}, ed25519.PublicKeySize)
+	systemProgram := make([]byte, ed25519.PublicKeySize)
+	blockhash := bytes.Repeat([]byte{0x33}, 32)
+
+	if v0 {
+		message = append(message, 0x80)
+	}
+	// Header: 2 required signatures, 0 read-only signers, 1 read-only non-signer
+	message = append(message, 2, 0, 1)
+	message = append(message, 4)
+	message = append(message, feePayer...)
+	message = append(message, signer...)
+	message = append(message, recipient...)
+	message = append(message, systemProgram...)
+	message = append(message, blockhash...)
+	// One system program transfer of 1000 lamports from the fee payer to the recipient
+	message = append(message, 1, 3, 2, 0, 2, 12, 2, 0, 0, 0, 0xe8, 0x03, 0, 0, 0, 0, 0, 0)
+	if v0 {
+		// No address table lookups
+		message = append(message, 0)
+	}
+
+	txBytes = append(txBytes, 2)
+	txBytes = append(txBytes, make([]byte, 2*ed25519.SignatureSize)...)
+	return append(txBytes, message...), message
+}
+
+func TestSignSolanaTransaction(t *testing.T) {
+	privateKey := testSolanaKey(t)
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	feePayer := bytes.Repeat([]byte{0x11}, ed25519.PublicKeySize)
+
+	for _, v0 := range []bool{false, true} {
+		name := map[bool]string{false: "legacy", true: "v0"}[v0]
+		txBytes, message := testSolanaTransaction(feePayer, publicKey, v0)
+
+		tx, err := parseSolanaTransaction(txBytes)
+		if err != nil {
+			t.Fatalf("%s: parseSolanaTransaction: %v", name, err)
+		}
+		if tx.signaturesOffset != 1 || !bytes.Equal(tx.message, message) {
+			t.Errorf("%s: signatures at %d and message %x, want 1 and %x", name, tx.signaturesOffset, tx.message, message)
+		}
+		if len(tx.signerKeys) != 2 || !bytes.Equal(tx.signerKeys[0], feePayer) || !bytes.Equal(tx.signerKeys[1], publicKey) {
+			t.Errorf("%s: signers %x, want the fee payer and the key", name, tx.signerKeys)
+		}
+
+		// The signature goes in the key's slot, and the rest of the transaction is unchanged
+		for _, encoded := range []string{base64.StdEncoding.EncodeToString(txBytes), Base58Encode(txBytes)} {
+			signed, err := signSolanaTransaction(encoded, privateKey)
+			if err != nil {
+				t.Fatalf("%s: signSolanaTransaction: %v", name, err)
+			}
+			signedBytes, err := base64.StdEncoding.DecodeString(signed)
+			if err != nil {
+				t.Fatalf("%s: signed transaction is not base64: %v", name, err)
+			}
+			feePayerSlot := signedBytes[1 : 1+ed25519.SignatureSize]
+			signerSlot := signedBytes[1+ed25519.SignatureSize : 1+2*ed25519.SignatureSize]
+			if !bytes.Equal(feePayerSlot, make([]byte, ed25519.SignatureSize)) {
+				t.Errorf("%s: the fee payer's signature slot was written", name)
+			}
+			if !ed25519.Verify(publicKey, message, signerSlot) {
+				t.Errorf("%s: signature %x does not verify against the message", name, signerSlot)
+			}
+			if !bytes.Equal(signedBytes[1+2*ed25519.SignatureSize:], message) {
+				t.Errorf("%s: the message changed when signing", name)
+			}
+		}
+
+		// A key that is not a required signer cannot sign
+		if _, err := signSolanaTransaction(base64.StdEncoding.EncodeToString(txBytes), ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))); err == nil {
+			t.Errorf("%s: signing with a key that is not a signer succeeded", name)
+		}
+	}
+}
+
+func TestParseSolanaTransactionRejects(t *testing.T) {
+	publicKey := testSolanaKey(t).Public().(ed25519.PublicKey)
+	txBytes, _ := testSolanaTransaction(publicKey, publicKey, true)
+	messageOffset := 1 + 2*ed25519.SignatureSize
+
+	tests := map[string][]byte{
+		"empty":                  {},
+		"truncated signatures":   txBytes[:messageOffset-1],
+		"truncated header":       txBytes[:messageOffset+2],
+		"truncated account keys": txBytes[:messageOffset+5+ed25519.PublicKeySize],
+		"message version 1":      replaceByte(txBytes, messageOffset, 0x81),
+		"fewer signatures":       append([]byte{1}, txBytes[1+ed25519.SignatureSize:]...),
+		"more signers than keys": replaceByte(txBytes, messageOffset+4, 1),
+	}
+	for name, data := range tests {
+		if _, err := parseSolanaTransaction(data); err == nil {
+			t.Errorf("%s: parseSolanaTransaction succeeded, want an error", name)
+		}
+	}
+}
+
+func replaceByte(data []byte, index int, value byte) []byte {
+	replaced := append([]byte(nil), data...)
+	replaced[index] = value
+	return replaced
+}
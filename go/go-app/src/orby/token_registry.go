@@ -0,0 +1,367 @@
+// token_registry.go caches token metadata and standardized token IDs, and resolves tokens by symbol
+package orby
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-app/src/caip"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// TokenInfo is what the token registry knows about a token on a chain
+type TokenInfo struct {
+	ChainId             caip.ChainID `json:"chainId"`
+	Address             string       `json:"address"`
+	Symbol              string       `json:"symbol,omitempty"`
+	Name                string       `json:"name,omitempty"`
+	Decimals            *int         `json:"decimals,omitempty"`
+	StandardizedTokenId string       `json:"standardizedTokenId,omitempty"`
+	LogoURI             string       `json:"logoURI,omitempty"`
+	UpdatedAt           time.Time    `json:"updatedAt"`
+}
+
+// DecimalsString returns the token's decimals, or an empty string if they are unknown
+func (t TokenInfo) DecimalsString() string {
+	if t.Decimals == nil {
+		return ""
+	}
+	return strconv.Itoa(*t.Decimals)
+}
+
+// merge overrides the fields set in other
+func (t TokenInfo) merge(other TokenInfo) TokenInfo {
+	if other.Symbol != "" {
+		t.Symbol = other.Symbol
+	}
+	if other.Name != "" {
+		t.Name = other.Name
+	}
+	if other.Decimals != nil {
+		t.Decimals = other.Decimals
+	}
+	if other.StandardizedTokenId != "" {
+		t.StandardizedTokenId = other.StandardizedTokenId
+	}
+	if other.LogoURI != "" {
+		t.LogoURI = other.LogoURI
+	}
+	return t
+}
+
+// TokenInfoFromToken converts a token returned by Orby
+func TokenInfoFromToken(token Token, standardizedTokenId string) TokenInfo {
+	decimals := token.Currency.Decimals
+	return TokenInfo{
+		ChainId:             token.ChainId,
+		Address:             token.Address,
+		Symbol:              token.Currency.Asset.Symbol,
+		Name:                token.Currency.Asset.Name,
+		Decimals:            &decimals,
+		StandardizedTokenId: standardizedTokenId,
+	}
+}
+
+// TokenList is a token list in the Uniswap token list format (https://tokenlists.org)
+type TokenList struct {
+	Name   string           `json:"name"`
+	Tokens []TokenListEntry `json:"tokens"`
+}
+
+// TokenListEntry is a token in a token list
+type TokenListEntry struct {
+	ChainId  int64  `json:"chainId"`
+	Address  string `json:"address"`
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol"`
+	Decimals int    `json:"decimals"`
+	LogoURI  string `json:"logoURI,omitempty"`
+}
+
+// TokenRegistry caches token metadata per chain and address. Entries expire after the registry's TTL
+// and are persisted to a file, so standardized token IDs are not requested again on every run.
+type TokenRegistry struct {
+	mu        sync.Mutex
+	storePath string
+	ttl       time.Duration
+	tokens    map[string]TokenInfo
+}
+
+// NewTokenRegistry creates a token registry. If storePath is not empty, entries are loaded from and
+// saved to that file. A ttl of 0 keeps entries forever.
+func NewTokenRegistry(storePath string, ttl time.Duration) (*TokenRegistry, error) {
+	registry := &TokenRegistry{
+		storePath: storePath,
+		ttl:       ttl,
+		tokens:    make(map[string]TokenInfo),
+	}
+
+	if err := registry.load(); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+var (
+	defaultTokenRegistry     *TokenRegistry
+	defaultTokenRegistryErr  error
+	defaultTokenRegistryOnce sync.Once
+)
+
+// DefaultTokenRegistry returns the shared token registry stored in TOKEN_REGISTRY_STORE, with entries
+// kept for TOKEN_REGISTRY_TTL_SECONDS and the tokens in the TOKEN_LIST file preloaded
+func DefaultTokenRegistry() (*TokenRegistry, error) {
+	defaultTokenRegistryOnce.Do(func() {
+		ttlSeconds, err := strconv.ParseInt(GetEnvWithDefault("TOKEN_REGISTRY_TTL_SECONDS", "86400"), 10, 64)
+		if err != nil || ttlSeconds < 0 {
+			defaultTokenRegistryErr = fmt.Errorf("invalid TOKEN_REGISTRY_TTL_SECONDS: %s", GetEnvWithDefault("TOKEN_REGISTRY_TTL_SECONDS", ""))
+			return
+		}
+
+		defaultTokenRegistry, defaultTokenRegistryErr = NewTokenRegistry(
+			GetEnvWithDefault("TOKEN_REGISTRY_STORE", ".orby/tokens.json"),
+			time.Duration(ttlSeconds)*time.Second)
+		if defaultTokenRegistryErr != nil {
+			return
+		}
+		if path := GetEnvWithDefault("TOKEN_LIST", ""); path != "" {
+			defaultTokenRegistryErr = defaultTokenRegistry.LoadTokenList(path)
+		}
+	})
+	return defaultTokenRegistry, defaultTokenRegistryErr
+}
+
+// Put adds tokens or merges the fields set into the cached ones, then saves the registry
+func (r *TokenRegistry) Put(tokens ...TokenInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, token := range tokens {
+		if token.ChainId.IsZero() || token.Address == "" {
+			continue
+		}
+		key := tokenKey(token.ChainId, token.Address)
+		if existing, ok := r.tokens[key]; ok && !r.expired(existing, now) {
+			token = existing.merge(token)
+		}
+		token.UpdatedAt = now
+		r.tokens[key] = token
+	}
+	return r.save()
+}
+
+// Get returns the cached metadata of a token, if it has not expired
+func (r *TokenRegistry) Get(chainId caip.ChainID, address string) (TokenInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenKey(chainId, address)]
+	if !ok || r.expired(token, time.Now()) {
+		return TokenInfo{}, false
+	}
+	return token, true
+}
+
+// FindBySymbol returns the cached tokens on a chain with the given symbol, ignoring case
+func (r *TokenRegistry) FindBySymbol(chainId caip.ChainID, symbol string) []TokenInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var matches []TokenInfo
+	for _, token := range r.tokens {
+		if token.ChainId == chainId && strings.EqualFold(token.Symbol, symbol) && !r.expired(token, now) {
+			matches = append(matches, token)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Address < matches[j].Address })
+	return matches
+}
+
+// Resolve returns the token for an address or a symbol on a chain. Unknown addresses are returned
+// without metadata, while symbols must match exactly one cached token.
+func (r *TokenRegistry) Resolve(chainId caip.ChainID, input string) (TokenInfo, error) {
+	input = strings.TrimSpace(input)
+	if input == "" || common.IsHexAddress(input) {
+		if token, ok := r.Get(chainId, input); ok {
+			return token, nil
+		}
+		return TokenInfo{ChainId: chainId, Address: input}, nil
+	}
+
+	matches := r.FindBySymbol(chainId, input)
+	switch len(matches) {
+	case 0:
+		return TokenInfo{}, fmt.Errorf("unknown token %q on chain %s: use its address or load a token list with TOKEN_LIST", input, chainId.String())
+	case 1:
+		return matches[0], nil
+	}
+	addresses := make([]string, len(matches))
+	for i, match := range matches {
+		addresses[i] = match.Address
+	}
+	return TokenInfo{}, fmt.Errorf("token symbol %q is ambiguous on chain %s: use one of %s", input, chainId.String(), strings.Join(addresses, ", "))
+}
+
+// LoadTokenList adds the tokens of a Uniswap-format token list file
+func (r *TokenRegistry) LoadTokenList(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read token list %s: %v", path, err)
+	}
+
+	var list TokenList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to parse token list %s: %v", path, err)
+	}
+
+	tokens := make([]TokenInfo, 0, len(list.Tokens))
+	for _, entry := range list.Tokens {
+		if entry.ChainId <= 0 || !common.IsHexAddress(entry.Address) {
+			continue
+		}
+		decimals := entry.Decimals
+		tokens = append(tokens, TokenInfo{
+			ChainId:  caip.EIP155(entry.ChainId),
+			Address:  common.HexToAddress(entry.Address).Hex(),
+			Symbol:   entry.Symbol,
+			Name:     entry.Name,
+			Decimals: &decimals,
+			LogoURI:  entry.LogoURI,
+		})
+	}
+	return r.Put(tokens...)
+}
+
+// StandardizedTokenIds returns the standardized token IDs of tokens, in order. Only the tokens whose
+// ID is not cached are requested from Orby, and their IDs are cached.
+func (r *TokenRegistry) StandardizedTokenIds(client *OrbyClient, tokens []TokenParams) ([]string, error) {
+	ids := make([]string, len(tokens))
+	var missing []TokenParams
+	var missingIndexes []int
+	for i, token := range tokens {
+		if cached, ok := r.Get(token.ChainId, token.TokenAddress); ok && cached.StandardizedTokenId != "" {
+			ids[i] = cached.StandardizedTokenId
+			continue
+		}
+		missing = append(missing, token)
+		missingIndexes = append(missingIndexes, i)
+	}
+	if len(missing) == 0 {
+		fmt.Println("\n[INFO] Using cached standardized token IDs")
+		return ids, nil
+	}
+
+	result, err := client.GetStandardizedTokenIds(missing)
+	if err != nil {
+		return nil, err
+	}
+	var response StandardizedTokenIdsResponse
+	if err := json.Unmarshal(result, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse orby_getStandardizedTokenIds response: %v", err)
+	}
+	if len(response.StandardizedTokenIds) != len(missing) {
+		return nil, fmt.Errorf("orby_getStandardizedTokenIds returned %d IDs for %d tokens", len(response.StandardizedTokenIds), len(missing))
+	}
+
+	fetched := make([]TokenInfo, len(missing))
+	for i, id := range response.StandardizedTokenIds {
+		ids[missingIndexes[i]] = id
+		fetched[i] = TokenInfo{ChainId: missing[i].ChainId, Address: missing[i].TokenAddress, StandardizedTokenId: id}
+	}
+	if err := r.Put(fetched...); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// RememberOperationSetTokens caches the tokens in an operation set's input and output states
+func (r *TokenRegistry) RememberOperationSetTokens(operationSet *OperationSet) error {
+	var tokens []TokenInfo
+	for _, state := range []State{operationSet.InputState, operationSet.OutputState} {
+		for _, tokenAmount := range state.FungibleTokenAmounts {
+			tokens = append(tokens, TokenInfoFromToken(tokenAmount.Token, ""))
+		}
+	}
+	return r.Put(tokens...)
+}
+
+// ResolveTokenFromEnv resolves the token in an environment variable, given as an address or as a
+// symbol in the default token registry
+func ResolveTokenFromEnv(key string, chainId caip.ChainID) (TokenInfo, error) {
+	registry, err := DefaultTokenRegistry()
+	if err != nil {
+		return TokenInfo{}, err
+	}
+	token, err := registry.Resolve(chainId, GetEnvWithDefault(key, ""))
+	if err != nil {
+		return TokenInfo{}, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return token, nil
+}
+
+func (r *TokenRegistry) expired(token TokenInfo, now time.Time) bool {
+	return r.ttl > 0 && now.Sub(token.UpdatedAt) > r.ttl
+}
+
+func tokenKey(chainId caip.ChainID, address string) string {
+	return chainId.String() + ":" + strings.ToLower(address)
+}
+
+// load reads the cached tokens from the store file
+func (r *TokenRegistry) load() error {
+	if r.storePath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(r.storePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read token registry %s: %v", r.storePath, err)
+	}
+
+	var tokens []TokenInfo
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return fmt.Errorf("failed to parse token registry %s: %v", r.storePath, err)
+	}
+	for _, token := range tokens {
+		r.tokens[tokenKey(token.ChainId, token.Address)] = token
+	}
+	return nil
+}
+
+// save writes the unexpired tokens to the store file
+func (r *TokenRegistry) save() error {
+	if r.storePath == "" {
+		return nil
+	}
+
+	now := time.Now()
+	tokens := make([]TokenInfo, 0, len(r.tokens))
+	for key, token := range r.tokens {
+		if r.expired(token, now) {
+			delete(r.tokens, key)
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokenKey(tokens[i].ChainId, tokens[i].Address) < tokenKey(tokens[j].ChainId, tokens[j].Address)
+	})
+
+	if err := WriteJSONFile(r.storePath, tokens); err != nil {
+		return fmt.Errorf("failed to write token registry %s: %v", r.storePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// typed_data.go loads and validates arbitrary EIP-712 typed data before it is sent to Orby
+package orby
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// eip712DomainFields are the EIP712Domain fields in the order EIP-712 defines them
+var eip712DomainFields = []apitypes.Type{
+	{Name: "name", Type: "string"},
+	{Name: "version", Type: "string"},
+	{Name: "chainId", Type: "uint256"},
+	{Name: "verifyingContract", Type: "address"},
+	{Name: "salt", Type: "bytes32"},
+}
+
+// EIP712DomainType returns the EIP712Domain type for the fields set in domain
+func EIP712DomainType(domain apitypes.TypedDataDomain) []apitypes.Type {
+	values := domain.Map()
+
+	var fields []apitypes.Type
+	for _, field := range eip712DomainFields {
+		if _, ok := values[field.Name]; ok {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// LoadTypedData parses EIP-712 typed data from JSON, or reads it from a file if input is a path.
+// If the types do not define EIP712Domain it is derived from the fields set in the domain.
+func LoadTypedData(input string) (*apitypes.TypedData, error) {
+	data := []byte(input)
+	if !strings.HasPrefix(strings.TrimSpace(input), "{") {
+		fileData, err := os.ReadFile(input)
+		if err != nil {
+			return nil, fmt.Errorf("typed data must be a JSON object or a path to a JSON file: %v", err)
+		}
+		data = fileData
+	}
+
+	var typedData apitypes.TypedData
+	if err := json.Unmarshal(data, &typedData); err != nil {
+		return nil, fmt.Errorf("failed to parse typed data: %v", err)
+	}
+	AddEIP712DomainTypeToTypedData(&typedData)
+
+	if err := ValidateTypedData(&typedData); err != nil {
+		return nil, err
+	}
+	return &typedData, nil
+}
+
+// ValidateTypedData checks the types, primaryType, domain and message against each other:
+// every referenced type is defined, the domain sets exactly the EIP712Domain fields, the message
+// has exactly the fields of the primary type (recursively), and both the domain and message hash.
+func ValidateTypedData(typedData *apitypes.TypedData) error {
+	// 1. Check the primary type and the domain type are defined
+	if typedData.PrimaryType == "" {
+		return fmt.Errorf("typed data missing 'primaryType' field")
+	}
+	if typedData.PrimaryType == "EIP712Domain" {
+		return fmt.Errorf("primaryType cannot be EIP712Domain")
+	}
+	if _, ok := typedData.Types[typedData.PrimaryType]; !ok {
+		return fmt.Errorf("primaryType %s is not defined in types", typedData.PrimaryType)
+	}
+	domainType, ok := typedData.Types["EIP712Domain"]
+	if !ok {
+		return fmt.Errorf("typed data types do not define EIP712Domain")
+	}
+
+	// 2. Check the domain sets exactly the fields of EIP712Domain
+	domainValues := typedData.Domain.Map()
+	if len(domainValues) == 0 {
+		return fmt.Errorf("typed data missing 'domain' field")
+	}
+	if err := validateTypedDataFields("domain", "EIP712Domain", domainType, domainValues); err != nil {
+		return err
+	}
+
+	// 3. Check the message matches the primary type, including nested structs and arrays
+	if len(typedData.Message) == 0 {
+		return fmt.Errorf("typed data missing 'message' field")
+	}
+	if err := validateTypedDataMessage(typedData.Types, "message", typedData.PrimaryType, typedData.Message); err != nil {
+		return err
+	}
+
+	// 4. Hash the domain and message, which checks the types are sound and every value encodes
+	if _, err := TypedDataHash(typedData); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateTypedDataMessage(types apitypes.Types, path string, typeName string, value map[string]interface{}) error {
+	fields := types[typeName]
+	if err := validateTypedDataFields(path, typeName, fields, value); err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		baseType := strings.Split(field.Type, "[")[0]
+		if _, isStruct := types[baseType]; !isStruct {
+			continue
+		}
+		if err := validateTypedDataValue(types, path+"."+field.Name, field.Type, value[field.Name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateTypedDataValue(types apitypes.Types, path string, fieldType string, value interface{}) error {
+	// Arrays: check each element against the element type
+	if strings.HasSuffix(fieldType, "]") {
+		elementType := fieldType[:strings.LastIndex(fieldType, "[")]
+		items := reflect.ValueOf(value)
+		if value == nil || items.Kind() != reflect.Slice {
+			return fmt.Errorf("%s: expected an array of %s", path, elementType)
+		}
+		for i := 0; i < items.Len(); i++ {
+			if err := validateTypedDataValue(types, fmt.Sprintf("%s[%d]", path, i), elementType, items.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Structs: check the object has exactly the struct's fields
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: expected a %s object", path, fieldType)
+	}
+	return validateTypedDataMessage(types, path, fieldType, object)
+}
+
+func validateTypedDataFields(path string, typeName string, fields []apitypes.Type, value map[string]interface{}) error {
+	declared := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		declared[field.Name] = true
+		if _, ok := value[field.Name]; !ok {
+			return fmt.Errorf("%s is missing %s field %q", path, typeName, field.Name)
+		}
+	}
+	for name := range value {
+		if !declared[name] {
+			return fmt.Errorf("%s has field %q which %s does not define", path, name, typeName)
+		}
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package orby
 
 import (
 	"math/big"
+
+	"go-app/src/caip"
 )
 
 // ************************************** Common **************************************
@@ -20,26 +22,26 @@ type Currency struct {
 	IsNative bool  `json:"isNative"`
 }
 
-// AmountValue represents a monetary amount with value information
+// CurrencyAmount represents a monetary amount with value information
 type CurrencyAmount struct {
-	Amount   string   `json:"amount"`
+	Amount   Amount   `json:"amount"`
 	Currency Currency `json:"currency"`
 	Value    string   `json:"value"`
 }
 
 // Token represents a token with its details
 type Token struct {
-	Typename    string   `json:"__typename"`
-	Address     string   `json:"address"`
-	ChainId     string   `json:"chainId"`
-	CoinGeckoId string   `json:"coinGeckoId,omitempty"`
-	Currency    Currency `json:"currency"`
-	IsNative    bool     `json:"isNative"`
+	Typename    string       `json:"__typename"`
+	Address     string       `json:"address"`
+	ChainId     caip.ChainID `json:"chainId"`
+	CoinGeckoId string       `json:"coinGeckoId,omitempty"`
+	Currency    Currency     `json:"currency"`
+	IsNative    bool         `json:"isNative"`
 }
 
 // TokenAmount represents a token with its amount
 type TokenAmount struct {
-	Amount string `json:"amount"`
+	Amount Amount `json:"amount"`
 	Token  Token  `json:"token"`
 	Value  string `json:"value"`
 }
@@ -53,7 +55,7 @@ type State struct {
 
 // Operation represents a blockchain operation/transaction
 type Operation struct {
-	ChainId                            string          `json:"chainId"`
+	ChainId                            caip.ChainID    `json:"chainId"`
 	Data                               string          `json:"data"`
 	EstimatedNetworkFees               *CurrencyAmount `json:"estimatedNetworkFees,omitempty"`
 	EstimatedNetworkFeesInFiatCurrency *CurrencyAmount `json:"estimatedNetworkFeesInFiatCurrency,omitempty"`
@@ -100,11 +102,11 @@ type OperationSet struct {
 
 // SignedOperation represents a signed operation to be sent to orby_sendSignedOperations
 type SignedOperation struct {
-	Type      string `json:"type"`
-	Signature string `json:"signature"`
-	Data      string `json:"data"`
-	ChainId   string `json:"chainId"`
-	From      string `json:"from"`
+	Type      string       `json:"type"`
+	Signature string       `json:"signature"`
+	Data      string       `json:"data"`
+	ChainId   caip.ChainID `json:"chainId"`
+	From      string       `json:"from"`
 }
 
 // SendSignedOperationsParams represents the parameters for orby_sendSignedOperations
@@ -113,6 +115,21 @@ type SendSignedOperationsParams struct {
 	AccountClusterId string            `json:"accountClusterId"`
 }
 
+// OperationResponse is the status of one sent operation in the orby_sendSignedOperations response
+type OperationResponse struct {
+	Id      string       `json:"id"`
+	ChainId caip.ChainID `json:"chainId"`
+	Hash    string       `json:"hash"`
+	Status  string       `json:"status"`
+}
+
+// SendSignedOperationsResponse represents the response from orby_sendSignedOperations
+type SendSignedOperationsResponse struct {
+	Success            bool                `json:"success"`
+	OperationSetId     string              `json:"operationSetId"`
+	OperationResponses []OperationResponse `json:"operationResponses"`
+}
+
 // StandardizedBalance represents balances for a standardized token id
 type StandardizedBalance struct {
 	Typename              *string         `json:"__typename,omitempty"`
@@ -128,9 +145,10 @@ type StandardizedBalance struct {
 
 // GetOperationsToExecuteTransactionParams represents the parameters for orby_getOperationsToExecuteTransaction
 type GetOperationsToExecuteTransactionParams struct {
-	AccountClusterId string `json:"accountClusterId"`
-	Data             string `json:"data"`
-	To               string `json:"to"`
+	AccountClusterId string  `json:"accountClusterId"`
+	Data             string  `json:"data"`
+	To               string  `json:"to"`
+	Value            *Amount `json:"value,omitempty"`
 }
 
 // GetOperationsToSignTypedDataParams represents the parameters for orby_getOperationsToSignTypedData
@@ -151,8 +169,8 @@ type GetFungibleTokenPortfolioResponse struct {
 
 // TokenParams represents the parameters for a token in orby_getStandardizedTokenIds
 type TokenParams struct {
-	ChainId      string `json:"chainId"`
-	TokenAddress string `json:"tokenAddress"`
+	ChainId      caip.ChainID `json:"chainId"`
+	TokenAddress string       `json:"tokenAddress"`
 }
 
 // GetStandardizedTokenIdsParams represents the parameters for orby_getStandardizedTokenIds
@@ -167,21 +185,21 @@ type StandardizedTokenIdsResponse struct {
 
 // TokenSource represents a token
 type TokenSource struct {
-	ChainID string `json:"chainId"`
-	Address string `json:"address,omitempty"`
+	ChainID caip.ChainID `json:"chainId"`
+	Address string       `json:"address,omitempty"`
 }
 
 // InputSwapParam represents the input tokens for orby_getOperationsToSwap
 type InputSwapParam struct {
 	StandardizedTokenId string        `json:"standardizedTokenId"`
-	Amount              string        `json:"amount,omitempty"`
+	Amount              *Amount       `json:"amount,omitempty"`
 	TokenSources        []TokenSource `json:"tokenSources,omitempty"`
 }
 
 // OutputSwapParam represents the output tokens for orby_getOperationsToSwap
 type OutputSwapParam struct {
 	StandardizedTokenId string      `json:"standardizedTokenId"`
-	Amount              string      `json:"amount,omitempty"`
+	Amount              *Amount     `json:"amount,omitempty"`
 	TokenDestination    TokenSource `json:"tokenDestination,omitempty"`
 }
 
@@ -207,12 +225,19 @@ type CreateAccountClusterParams struct {
 	Accounts []AccountParams `json:"accounts"`
 }
 
+// UpdateAccountClusterParams represents the parameters for orby_addAccountsToCluster and
+// orby_removeAccountsFromCluster
+type UpdateAccountClusterParams struct {
+	AccountClusterId string          `json:"accountClusterId"`
+	Accounts         []AccountParams `json:"accounts"`
+}
+
 // AccountClusterAccount represents an account in the account cluster response
 type AccountClusterAccount struct {
-	AccountType string `json:"accountType"`
-	Address     string `json:"address"`
-	ChainId     string `json:"chainId"`
-	VMType      string `json:"vmType"`
+	AccountType string       `json:"accountType"`
+	Address     string       `json:"address"`
+	ChainId     caip.ChainID `json:"chainId"`
+	VMType      string       `json:"vmType"`
 }
 
 // AccountClusterResponse represents the response from orby_createAccountCluster
@@ -224,9 +249,9 @@ type AccountClusterResponse struct {
 
 // GetVirtualNodeRpcUrlParams represents the parameters for orby_getVirtualNodeRpcUrl
 type GetVirtualNodeRpcUrlParams struct {
-	AccountClusterId         string `json:"accountClusterId"`
-	ChainId                  string `json:"chainId"`
-	EntrypointAccountAddress string `json:"entrypointAccountAddress"`
+	AccountClusterId         string       `json:"accountClusterId"`
+	ChainId                  caip.ChainID `json:"chainId"`
+	EntrypointAccountAddress string       `json:"entrypointAccountAddress"`
 }
 
 // VirtualNodeRpcUrlResponse represents the response from orby_getVirtualNodeRpcUrl
@@ -241,8 +266,51 @@ type OrbyInstanceResponse struct {
 	OrbyInstancePublicUrl  string `json:"orbyInstancePublicUrl"`
 }
 
+// OrbyInstanceConfig represents the configurable settings of an Orby instance
+type OrbyInstanceConfig struct {
+	// AllowedOrigins are the origins allowed to call the public URL from a browser
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+	// RateLimitPerMinute caps the requests per minute to the instance, 0 for no limit
+	RateLimitPerMinute *int `json:"rateLimitPerMinute,omitempty"`
+	// ChainIds restricts the chains the instance serves, all supported chains if empty
+	ChainIds []caip.ChainID `json:"chainIds,omitempty"`
+}
+
+// OrbyInstance represents an instance in the orby_listInstances and orby_getInstance responses
+type OrbyInstance struct {
+	Name                   string              `json:"name"`
+	OrbyInstancePrivateUrl string              `json:"orbyInstancePrivateUrl"`
+	OrbyInstancePublicUrl  string              `json:"orbyInstancePublicUrl"`
+	CreatedAt              string              `json:"createdAt,omitempty"`
+	Config                 *OrbyInstanceConfig `json:"config,omitempty"`
+}
+
+// OrbyInstanceNameParams represents the parameters of the admin calls made on a single instance
+type OrbyInstanceNameParams struct {
+	Name string `json:"name"`
+}
+
+// ConfigureOrbyInstanceParams represents the parameters for orby_configureInstance
+type ConfigureOrbyInstanceParams struct {
+	Name   string             `json:"name"`
+	Config OrbyInstanceConfig `json:"config"`
+}
+
+// ListOrbyInstancesResponse represents the response from orby_listInstances
+type ListOrbyInstancesResponse struct {
+	Instances []OrbyInstance `json:"instances"`
+}
+
+// DeleteOrbyInstanceResponse represents the response from orby_deleteInstance
+type DeleteOrbyInstanceResponse struct {
+	Success bool `json:"success"`
+}
+
 // ************************************** Permit2 **************************************
 
+// Permit2Address is the address Permit2 is deployed at on every chain
+const Permit2Address = "0x000000000022d473030f116ddee9f6b43ac78ba3"
+
 // TokenPermissions matches { token: address, amount: uint256 }
 type TokenPermissions struct {
 	Token  string   `json:"token"`
@@ -257,14 +325,19 @@ type PermitTransferFrom struct {
 	Deadline  *big.Int         `json:"deadline"`
 }
 
-// EIP712Domain matches { name: string, chainId: uint256, verifyingContract: address }
+// EIP712Domain matches { name: string, version: string, chainId: uint256, verifyingContract: address, salt: bytes32 }.
+// Unset fields are omitted, as they are from the EIP712Domain type.
 type EIP712Domain struct {
-	Name              string   `json:"name"`
-	ChainId           *big.Int `json:"chainId"`
-	VerifyingContract string   `json:"verifyingContract"`
+	Name              string   `json:"name,omitempty"`
+	Version           string   `json:"version,omitempty"`
+	ChainId           *big.Int `json:"chainId,omitempty"`
+	VerifyingContract string   `json:"verifyingContract,omitempty"`
+	Salt              string   `json:"salt,omitempty"`
 }
 
-// Permit2Object is the root structure
+// Permit2Object is the root structure of a Permit2 PermitTransferFrom
+//
+// Deprecated: use BuildPermitTransferFrom, or any apitypes.TypedData with GetOperationsToSignTypedData
 type Permit2Object struct {
 	Types       map[string][]EIP712Type `json:"types"`
 	Domain      EIP712Domain            `json:"domain"`
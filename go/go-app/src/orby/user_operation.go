@@ -0,0 +1,412 @@
+// user_operation.go hashes and signs ERC-4337 user operations for EntryPoint v0.6 and v0.7
+package orby
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// EntryPoint addresses, the same on every chain
+const (
+	EntryPointV06Address = "0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"
+	EntryPointV07Address = "0x0000000071727De22E5E9d8BAf0edAc6f37da032"
+)
+
+// EntryPoint versions, which decide how a user operation is hashed
+const (
+	EntryPointV06 = "v0.6"
+	EntryPointV07 = "v0.7"
+)
+
+// UserOperationFormat is the operation format of ERC-4337 user operations
+const UserOperationFormat = "USER_OPERATION"
+
+// EntryPointVersion returns the version of a known EntryPoint address
+func EntryPointVersion(entryPoint common.Address) (string, error) {
+	switch entryPoint {
+	case common.HexToAddress(EntryPointV06Address):
+		return EntryPointV06, nil
+	case common.HexToAddress(EntryPointV07Address):
+		return EntryPointV07, nil
+	}
+	return "", fmt.Errorf("unknown EntryPoint %s: give its version (%s or %s) with entryPointVersion", entryPoint.Hex(), EntryPointV06, EntryPointV07)
+}
+
+// ParseEntryPointVersion parses an EntryPoint version such as "v0.6" or "0.7"
+func ParseEntryPointVersion(version string) (string, error) {
+	switch strings.TrimPrefix(strings.ToLower(strings.TrimSpace(version)), "v") {
+	case "0.6":
+		return EntryPointV06, nil
+	case "0.7":
+		return EntryPointV07, nil
+	}
+	return "", fmt.Errorf("unknown EntryPoint version %q (expected %s or %s)", version, EntryPointV06, EntryPointV07)
+}
+
+// ResolveEntryPoint returns the EntryPoint address and version of a user operation. The version is
+// that of the EntryPoint address, or the given version for other EntryPoints; an EntryPoint may be
+// omitted if the version is given, and then is the version's canonical EntryPoint.
+func ResolveEntryPoint(entryPoint string, version string) (common.Address, string, error) {
+	if entryPoint != "" && !common.IsHexAddress(entryPoint) {
+		return common.Address{}, "", fmt.Errorf("invalid entry point address: %s", entryPoint)
+	}
+
+	if version == "" {
+		if entryPoint == "" {
+			return common.Address{}, "", fmt.Errorf("the user operation has no EntryPoint: give entryPoint or entryPointVersion, or set USER_OPERATION_ENTRY_POINT")
+		}
+		address := common.HexToAddress(entryPoint)
+		version, err := EntryPointVersion(address)
+		return address, version, err
+	}
+
+	version, err := ParseEntryPointVersion(version)
+	if err != nil {
+		return common.Address{}, "", err
+	}
+	if entryPoint == "" {
+		if version == EntryPointV06 {
+			return common.HexToAddress(EntryPointV06Address), version, nil
+		}
+		return common.HexToAddress(EntryPointV07Address), version, nil
+	}
+	address := common.HexToAddress(entryPoint)
+	if known, err := EntryPointVersion(address); err == nil && known != version {
+		return common.Address{}, "", fmt.Errorf("EntryPoint %s is %s, not %s", address.Hex(), known, version)
+	}
+	return address, version, nil
+}
+
+// UserOperationV06 is a v0.6 user operation in its JSON-RPC form
+type UserOperationV06 struct {
+	Sender               common.Address `json:"sender"`
+	Nonce                *hexutil.Big   `json:"nonce"`
+	InitCode             hexutil.Bytes  `json:"initCode"`
+	CallData             hexutil.Bytes  `json:"callData"`
+	CallGasLimit         *hexutil.Big   `json:"callGasLimit"`
+	VerificationGasLimit *hexutil.Big   `json:"verificationGasLimit"`
+	PreVerificationGas   *hexutil.Big   `json:"preVerificationGas"`
+	MaxFeePerGas         *hexutil.Big   `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big   `json:"maxPriorityFeePerGas"`
+	PaymasterAndData     hexutil.Bytes  `json:"paymasterAndData"`
+	Signature            hexutil.Bytes  `json:"signature"`
+}
+
+// UserOperationV07 is a v0.7 user operation in its unpacked JSON-RPC form
+type UserOperationV07 struct {
+	Sender                        common.Address  `json:"sender"`
+	Nonce                         *hexutil.Big    `json:"nonce"`
+	Factory                       *common.Address `json:"factory,omitempty"`
+	FactoryData                   hexutil.Bytes   `json:"factoryData,omitempty"`
+	CallData                      hexutil.Bytes   `json:"callData"`
+	CallGasLimit                  *hexutil.Big    `json:"callGasLimit"`
+	VerificationGasLimit          *hexutil.Big    `json:"verificationGasLimit"`
+	PreVerificationGas            *hexutil.Big    `json:"preVerificationGas"`
+	MaxFeePerGas                  *hexutil.Big    `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas          *hexutil.Big    `json:"maxPriorityFeePerGas"`
+	Paymaster                     *common.Address `json:"paymaster,omitempty"`
+	PaymasterVerificationGasLimit *hexutil.Big    `json:"paymasterVerificationGasLimit,omitempty"`
+	PaymasterPostOpGasLimit       *hexutil.Big    `json:"paymasterPostOpGasLimit,omitempty"`
+	PaymasterData                 hexutil.Bytes   `json:"paymasterData,omitempty"`
+	Signature                     hexutil.Bytes   `json:"signature"`
+}
+
+// PackedUserOperation is a v0.7 user operation as the EntryPoint receives it, with the gas fields
+// packed into 32-byte words
+type PackedUserOperation struct {
+	Sender             common.Address `json:"sender"`
+	Nonce              *hexutil.Big   `json:"nonce"`
+	InitCode           hexutil.Bytes  `json:"initCode"`
+	CallData           hexutil.Bytes  `json:"callData"`
+	AccountGasLimits   common.Hash    `json:"accountGasLimits"`
+	PreVerificationGas *hexutil.Big   `json:"preVerificationGas"`
+	GasFees            common.Hash    `json:"gasFees"`
+	PaymasterAndData   hexutil.Bytes  `json:"paymasterAndData"`
+	Signature          hexutil.Bytes  `json:"signature"`
+}
+
+// Pack converts the unpacked v0.7 user operation to its packed form
+func (u *UserOperationV07) Pack() (*PackedUserOperation, error) {
+	if u.Nonce == nil || u.CallGasLimit == nil || u.VerificationGasLimit == nil || u.PreVerificationGas == nil ||
+		u.MaxFeePerGas == nil || u.MaxPriorityFeePerGas == nil {
+		return nil, fmt.Errorf("user operation is missing nonce, gas limits or fees")
+	}
+
+	packed := &PackedUserOperation{
+		Sender:             u.Sender,
+		Nonce:              u.Nonce,
+		CallData:           u.CallData,
+		PreVerificationGas: u.PreVerificationGas,
+		Signature:          u.Signature,
+	}
+
+	// initCode is factory || factoryData
+	if u.Factory != nil {
+		packed.InitCode = append(u.Factory.Bytes(), u.FactoryData...)
+	}
+
+	// accountGasLimits is verificationGasLimit || callGasLimit and gasFees is
+	// maxPriorityFeePerGas || maxFeePerGas, as two 16-byte halves
+	var err error
+	if packed.AccountGasLimits, err = packUint128Pair(u.VerificationGasLimit, u.CallGasLimit); err != nil {
+		return nil, fmt.Errorf("invalid gas limits: %v", err)
+	}
+	if packed.GasFees, err = packUint128Pair(u.MaxPriorityFeePerGas, u.MaxFeePerGas); err != nil {
+		return nil, fmt.Errorf("invalid gas fees: %v", err)
+	}
+
+	// paymasterAndData is paymaster || paymasterVerificationGasLimit || paymasterPostOpGasLimit || paymasterData
+	if u.Paymaster != nil {
+		gasLimits, err := packUint128Pair(u.PaymasterVerificationGasLimit, u.PaymasterPostOpGasLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid paymaster gas limits: %v", err)
+		}
+		packed.PaymasterAndData = append(append(u.Paymaster.Bytes(), gasLimits.Bytes()...), u.PaymasterData...)
+	}
+
+	return packed, nil
+}
+
+// Hash returns the userOpHash of a v0.6 user operation for an EntryPoint and chain
+func (u *UserOperationV06) Hash(entryPoint common.Address, chainId *big.Int) (common.Hash, error) {
+	if u.Nonce == nil || u.CallGasLimit == nil || u.VerificationGasLimit == nil || u.PreVerificationGas == nil ||
+		u.MaxFeePerGas == nil || u.MaxPriorityFeePerGas == nil {
+		return common.Hash{}, fmt.Errorf("user operation is missing nonce, gas limits or fees")
+	}
+
+	encoded, err := userOperationV06Arguments.Pack(
+		u.Sender,
+		u.Nonce.ToInt(),
+		crypto.Keccak256Hash(u.InitCode),
+		crypto.Keccak256Hash(u.CallData),
+		u.CallGasLimit.ToInt(),
+		u.VerificationGasLimit.ToInt(),
+		u.PreVerificationGas.ToInt(),
+		u.MaxFeePerGas.ToInt(),
+		u.MaxPriorityFeePerGas.ToInt(),
+		crypto.Keccak256Hash(u.PaymasterAndData),
+	)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to encode user operation: %v", err)
+	}
+
+	return userOperationHash(crypto.Keccak256Hash(encoded), entryPoint, chainId)
+}
+
+// Hash returns the userOpHash of a packed v0.7 user operation for an EntryPoint and chain
+func (u *PackedUserOperation) Hash(entryPoint common.Address, chainId *big.Int) (common.Hash, error) {
+	if u.Nonce == nil || u.PreVerificationGas == nil {
+		return common.Hash{}, fmt.Errorf("user operation is missing nonce or preVerificationGas")
+	}
+
+	encoded, err := packedUserOperationArguments.Pack(
+		u.Sender,
+		u.Nonce.ToInt(),
+		crypto.Keccak256Hash(u.InitCode),
+		crypto.Keccak256Hash(u.CallData),
+		u.AccountGasLimits,
+		u.PreVerificationGas.ToInt(),
+		u.GasFees,
+		crypto.Keccak256Hash(u.PaymasterAndData),
+	)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to encode user operation: %v", err)
+	}
+
+	return userOperationHash(crypto.Keccak256Hash(encoded), entryPoint, chainId)
+}
+
+// UserOperationHash parses a user operation for an EntryPoint version and returns its userOpHash.
+// v0.7 user operations are given unpacked, or packed with accountGasLimits and gasFees. Fields of
+// the other version are rejected rather than ignored, so the operation is not hashed as something else.
+func UserOperationHash(data []byte, version string, entryPoint common.Address, chainId *big.Int) (common.Hash, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return common.Hash{}, fmt.Errorf("failed to parse user operation: %v", err)
+	}
+
+	switch version {
+	case EntryPointV06:
+		if field := firstField(fields, userOperationV07OnlyFields); field != "" {
+			return common.Hash{}, fmt.Errorf("%s user operation has the v0.7 field %s", version, field)
+		}
+		var userOp UserOperationV06
+		if err := json.Unmarshal(data, &userOp); err != nil {
+			return common.Hash{}, fmt.Errorf("failed to parse v0.6 user operation: %v", err)
+		}
+		return userOp.Hash(entryPoint, chainId)
+
+	case EntryPointV07:
+		if _, packed := fields["accountGasLimits"]; packed {
+			var userOp PackedUserOperation
+			if err := json.Unmarshal(data, &userOp); err != nil {
+				return common.Hash{}, fmt.Errorf("failed to parse packed user operation: %v", err)
+			}
+			return userOp.Hash(entryPoint, chainId)
+		}
+		if field := firstField(fields, userOperationV06OnlyFields); field != "" {
+			return common.Hash{}, fmt.Errorf("%s user operation has the v0.6 field %s", version, field)
+		}
+		var userOp UserOperationV07
+		if err := json.Unmarshal(data, &userOp); err != nil {
+			return common.Hash{}, fmt.Errorf("failed to parse v0.7 user operation: %v", err)
+		}
+		packed, err := userOp.Pack()
+		if err != nil {
+			return common.Hash{}, err
+		}
+		return packed.Hash(entryPoint, chainId)
+	}
+
+	return common.Hash{}, fmt.Errorf("unknown EntryPoint version %q (expected %s or %s)", version, EntryPointV06, EntryPointV07)
+}
+
+// Fields that only one form of user operation has
+var (
+	userOperationV06OnlyFields = []string{"initCode", "paymasterAndData"}
+	userOperationV07OnlyFields = []string{"accountGasLimits", "gasFees", "factory", "factoryData", "paymaster",
+		"paymasterVerificationGasLimit", "paymasterPostOpGasLimit", "paymasterData"}
+)
+
+func firstField(fields map[string]json.RawMessage, names []string) string {
+	for _, name := range names {
+		if _, ok := fields[name]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// SignUserOperationHash signs a userOpHash the way ECDSA-owned accounts such as SimpleAccount check
+// it: the owner signs the EIP-191 message hash of the userOpHash. The signature is wrapped for the
+// smart account, if one is given.
+func SignUserOperationHash(userOpHash common.Hash, ownerKey *ecdsa.PrivateKey, account *SmartAccount) ([]byte, error) {
+	signature, err := crypto.Sign(accounts.TextHash(userOpHash.Bytes()), ownerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign user operation hash: %v", err)
+	}
+
+	// Adjust v value (add 27) for Ethereum compatibility
+	signature[64] += 27
+
+	if account != nil {
+		return account.WrapSignature(signature), nil
+	}
+	return signature, nil
+}
+
+// SignUserOperation signs a USER_OPERATION operation. Its data is either the user operation itself or
+// {"entryPoint": ..., "entryPointVersion": ..., "userOperation": {...}}. The operation is hashed for the
+// version of its EntryPoint (USER_OPERATION_ENTRY_POINT if the data has none), or for entryPointVersion
+// if the EntryPoint is not a known one.
+func SignUserOperation(operation Operation) (string, error) {
+	// Get the private key of the operation's account
+	privateKey, err := OperationPrivateKey(operation)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Println("Raw user operation JSON:")
+	fmt.Println(operation.Data)
+
+	// 1. Parse the user operation and its EntryPoint, which decides its version
+	var request struct {
+		EntryPoint        string          `json:"entryPoint"`
+		EntryPointVersion string          `json:"entryPointVersion"`
+		UserOperation     json.RawMessage `json:"userOperation"`
+	}
+	if err := json.Unmarshal([]byte(operation.Data), &request); err != nil {
+		return "", fmt.Errorf("failed to parse user operation JSON: %v", err)
+	}
+	userOpData := []byte(operation.Data)
+	if len(request.UserOperation) > 0 {
+		userOpData = request.UserOperation
+	}
+
+	if request.EntryPoint == "" && request.EntryPointVersion == "" {
+		request.EntryPoint = GetEnvWithDefault("USER_OPERATION_ENTRY_POINT", "")
+	}
+	entryPoint, version, err := ResolveEntryPoint(request.EntryPoint, request.EntryPointVersion)
+	if err != nil {
+		return "", err
+	}
+
+	chainId, err := operation.ChainId.BigInt()
+	if err != nil {
+		return "", err
+	}
+
+	// 2. Compute the userOpHash
+	userOpHash, err := UserOperationHash(userOpData, version, entryPoint, chainId)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("User operation: %s, EntryPoint %s\n", version, entryPoint.Hex())
+	fmt.Printf("User operation hash: %s\n", userOpHash.Hex())
+
+	// 3. Sign it, wrapped for the smart account if the operation is from one
+	account, err := OperationSmartAccount(operation)
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := SignUserOperationHash(userOpHash, privateKey, account)
+	if err != nil {
+		return "", err
+	}
+
+	return hexutil.Encode(signature), nil
+}
+
+var (
+	userOperationV06Arguments = mustArguments(
+		"address", "uint256", "bytes32", "bytes32", "uint256", "uint256", "uint256", "uint256", "uint256", "bytes32")
+	packedUserOperationArguments = mustArguments(
+		"address", "uint256", "bytes32", "bytes32", "bytes32", "uint256", "bytes32", "bytes32")
+	userOperationHashArguments = mustArguments("bytes32", "address", "uint256")
+)
+
+// userOperationHash is keccak256(abi.encode(keccak256(pack(userOp)), entryPoint, chainId))
+func userOperationHash(packedHash common.Hash, entryPoint common.Address, chainId *big.Int) (common.Hash, error) {
+	encoded, err := userOperationHashArguments.Pack(packedHash, entryPoint, chainId)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to encode user operation hash: %v", err)
+	}
+	return crypto.Keccak256Hash(encoded), nil
+}
+
+func packUint128Pair(high *hexutil.Big, low *hexutil.Big) (common.Hash, error) {
+	var packed common.Hash
+	for i, value := range []*hexutil.Big{high, low} {
+		v := new(big.Int)
+		if value != nil {
+			v = value.ToInt()
+		}
+		if v.Sign() < 0 || v.BitLen() > 128 {
+			return common.Hash{}, fmt.Errorf("%s does not fit in uint128", v.String())
+		}
+		v.FillBytes(packed[i*16 : (i+1)*16])
+	}
+	return packed, nil
+}
+
+func mustArguments(types ...string) abi.Arguments {
+	arguments := make(abi.Arguments, len(types))
+	for i, typeName := range types {
+		argType, err := abi.NewType(typeName, "", nil)
+		if err != nil {
+			panic(err)
+		}
+		arguments[i] = abi.Argument{Type: argType}
+	}
+	return arguments
+}
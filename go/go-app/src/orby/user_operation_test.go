@@ -0,0 +1,182 @@
+package orby
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Every field has its own value, so hashing two fields in each other's place changes the hash
+var (
+	testUserOpSender    = "0x00000000000000000000000000000000000000e1"
+	testUserOpFactory   = "0x00000000000000000000000000000000000000f2"
+	testPaymaster       = "0x00000000000000000000000000000000000000f3"
+	testUserOpChainId   = big.NewInt(8453)
+	testCallData        = common.FromHex("0xb61d27f6")
+	testFactoryData     = common.FromHex("0x5fbfb9cf")
+	testPaymasterData   = common.FromHex("0xdeadbeef")
+	testUserOpNonce     = int64(0x2a)
+	testCallGas         = int64(0x030d40)
+	testVerificationGas = int64(0x0186a0)
+	testPreVerifyGas    = int64(0xc350)
+	testMaxFee          = int64(0x3b9aca00)
+	testMaxPriorityFee  = int64(0x05f5e100)
+)
+
+// getUserOpHash is keccak256(abi.encode(keccak256(encodedUserOp), entryPoint, chainid)), as both
+// EntryPoint versions compute it
+func getUserOpHash(encodedUserOp []byte, entryPoint string) common.Hash {
+	return crypto.Keccak256Hash(encode(crypto.Keccak256Hash(encodedUserOp), entryPoint, testUserOpChainId))
+}
+
+func TestUserOperationHashV06(t *testing.T) {
+	initCode := append(common.HexToAddress(testUserOpFactory).Bytes(), testFactoryData...)
+	paymasterAndData := append(common.HexToAddress(testPaymaster).Bytes(), testPaymasterData...)
+	data := `{
+		"sender": "` + testUserOpSender + `",
+		"nonce": "0x2a",
+		"initCode": "` + hexBytes(initCode) + `",
+		"callData": "0xb61d27f6",
+		"callGasLimit": "0x30d40",
+		"verificationGasLimit": "0x186a0",
+		"preVerificationGas": "0xc350",
+		"maxFeePerGas": "0x3b9aca00",
+		"maxPriorityFeePerGas": "0x5f5e100",
+		"paymasterAndData": "` + hexBytes(paymasterAndData) + `",
+		"signature": "0x"
+	}`
+
+	// UserOperationLib.pack of EntryPoint v0.6
+	expected := getUserOpHash(encode(
+		testUserOpSender,
+		testUserOpNonce,
+		crypto.Keccak256Hash(initCode),
+		crypto.Keccak256Hash(testCallData),
+		testCallGas,
+		testVerificationGas,
+		testPreVerifyGas,
+		testMaxFee,
+		testMaxPriorityFee,
+		crypto.Keccak256Hash(paymasterAndData),
+	), EntryPointV06Address)
+
+	hash, err := UserOperationHash([]byte(data), EntryPointV06, common.HexToAddress(EntryPointV06Address), testUserOpChainId)
+	if err != nil {
+		t.Fatalf("UserOperationHash: %v", err)
+	}
+	if hash != expected {
+		t.Errorf("v0.6 userOpHash = %s, want %s", hash.Hex(), expected.Hex())
+	}
+}
+
+func TestUserOperationHashV07(t *testing.T) {
+	// The v0.7 fields as packed by PackedUserOperation: initCode is factory || factoryData,
+	// accountGasLimits is verificationGasLimit || callGasLimit, gasFees is maxPriorityFeePerGas ||
+	// maxFeePerGas and paymasterAndData is paymaster || verification gas || postOp gas || paymasterData
+	initCode := append(common.HexToAddress(testUserOpFactory).Bytes(), testFactoryData...)
+	accountGasLimits := common.HexToHash("0x000000000000000000000000000186a000000000000000000000000000030d40")
+	gasFees := common.HexToHash("0x00000000000000000000000005f5e1000000000000000000000000003b9aca00")
+	paymasterGasLimits := common.HexToHash("0x0000000000000000000000000000753000000000000000000000000000004e20")
+	paymasterAndData := append(append(common.HexToAddress(testPaymaster).Bytes(), paymasterGasLimits.Bytes()...), testPaymasterData...)
+
+	// UserOperationLib.encode of EntryPoint v0.7
+	expected := getUserOpHash(encode(
+		testUserOpSender,
+		testUserOpNonce,
+		crypto.Keccak256Hash(initCode),
+		crypto.Keccak256Hash(testCallData),
+		accountGasLimits,
+		testPreVerifyGas,
+		gasFees,
+		crypto.Keccak256Hash(paymasterAndData),
+	), EntryPointV07Address)
+
+	unpacked := `{
+		"sender": "` + testUserOpSender + `",
+		"nonce": "0x2a",
+		"factory": "` + testUserOpFactory + `",
+		"factoryData": "0x5fbfb9cf",
+		"callData": "0xb61d27f6",
+		"callGasLimit": "0x30d40",
+		"verificationGasLimit": "0x186a0",
+		"preVerificationGas": "0xc350",
+		"maxFeePerGas": "0x3b9aca00",
+		"maxPriorityFeePerGas": "0x5f5e100",
+		"paymaster": "` + testPaymaster + `",
+		"paymasterVerificationGasLimit": "0x7530",
+		"paymasterPostOpGasLimit": "0x4e20",
+		"paymasterData": "0xdeadbeef",
+		"signature": "0x"
+	}`
+	packed := `{
+		"sender": "` + testUserOpSender + `",
+		"nonce": "0x2a",
+		"initCode": "` + hexBytes(initCode) + `",
+		"callData": "0xb61d27f6",
+		"accountGasLimits": "` + accountGasLimits.Hex() + `",
+		"preVerificationGas": "0xc350",
+		"gasFees": "` + gasFees.Hex() + `",
+		"paymasterAndData": "` + hexBytes(paymasterAndData) + `",
+		"signature": "0x"
+	}`
+
+	for name, data := range map[string]string{"unpacked": unpacked, "packed": packed} {
+		hash, err := UserOperationHash([]byte(data), EntryPointV07, common.HexToAddress(EntryPointV07Address), testUserOpChainId)
+		if err != nil {
+			t.Fatalf("UserOperationHash of the %s user operation: %v", name, err)
+		}
+		if hash != expected {
+			t.Errorf("v0.7 userOpHash of the %s user operation = %s, want %s", name, hash.Hex(), expected.Hex())
+		}
+	}
+}
+
+func TestUserOperationHashRejectsOtherVersion(t *testing.T) {
+	v06 := `{"sender": "` + testUserOpSender + `", "nonce": "0x0", "initCode": "0x", "callData": "0x",
+		"callGasLimit": "0x1", "verificationGasLimit": "0x1", "preVerificationGas": "0x1",
+		"maxFeePerGas": "0x1", "maxPriorityFeePerGas": "0x1", "paymasterAndData": "0x", "signature": "0x"}`
+	if _, err := UserOperationHash([]byte(v06), EntryPointV07, common.HexToAddress(EntryPointV07Address), testUserOpChainId); err == nil {
+		t.Errorf("hashing a v0.6 user operation for v0.7 succeeded, want an error")
+	}
+
+	v07 := `{"sender": "` + testUserOpSender + `", "nonce": "0x0", "factory": "` + testUserOpFactory + `", "callData": "0x",
+		"callGasLimit": "0x1", "verificationGasLimit": "0x1", "preVerificationGas": "0x1",
+		"maxFeePerGas": "0x1", "maxPriorityFeePerGas": "0x1", "signature": "0x"}`
+	if _, err := UserOperationHash([]byte(v07), EntryPointV06, common.HexToAddress(EntryPointV06Address), testUserOpChainId); err == nil {
+		t.Errorf("hashing a v0.7 user operation for v0.6 succeeded, want an error")
+	}
+}
+
+func TestSignUserOperationHash(t *testing.T) {
+	key, err := crypto.HexToECDSA("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	if err != nil {
+		t.Fatalf("HexToECDSA: %v", err)
+	}
+	userOpHash := crypto.Keccak256Hash([]byte("user operation"))
+
+	signature, err := SignUserOperationHash(userOpHash, key, nil)
+	if err != nil {
+		t.Fatalf("SignUserOperationHash: %v", err)
+	}
+	if len(signature) != 65 || (signature[64] != 27 && signature[64] != 28) {
+		t.Fatalf("signature %x is not a 65 byte signature with v 27 or 28", signature)
+	}
+
+	// SimpleAccount recovers the owner from ECDSA.toEthSignedMessageHash(userOpHash)
+	ethSignedMessageHash := crypto.Keccak256([]byte("\x19Ethereum Signed Message:\n32"), userOpHash.Bytes())
+	recoverable := append([]byte(nil), signature...)
+	recoverable[64] -= 27
+	publicKey, err := crypto.SigToPub(ethSignedMessageHash, recoverable)
+	if err != nil {
+		t.Fatalf("SigToPub: %v", err)
+	}
+	if owner := crypto.PubkeyToAddress(*publicKey); owner != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Errorf("signature recovers to %s, want the owner %s", owner.Hex(), crypto.PubkeyToAddress(key.PublicKey).Hex())
+	}
+}
+
+func hexBytes(data []byte) string {
+	return "0x" + common.Bytes2Hex(data)
+}
@@ -9,9 +9,12 @@ import (
 	"log"
 	"math/big"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
+	"go-app/src/caip"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -27,31 +30,70 @@ func GetEnvWithDefault(key, defaultValue string) string {
 	return value
 }
 
-// Version for use with go-ethereum's apitypes.TypedData
-func AddEIP712DomainTypeToTypedData(typedData *apitypes.TypedData) {
-	// Check if Types exists, initialize if not
-	if typedData.Types == nil {
-		typedData.Types = make(map[string][]apitypes.Type)
+// WriteJSONFile writes a value as indented JSON, creating the file's directory and replacing the file
+// atomically so readers never see a partial write
+func WriteJSONFile(path string, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
 	}
 
-	// Define EIP712Domain type
-	eip712Domain := []apitypes.Type{
-		{Name: "name", Type: "string"},
-		{Name: "chainId", Type: "uint256"},
-		{Name: "verifyingContract", Type: "address"},
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", dir, err)
+		}
 	}
 
-	// Add to Types
-	typedData.Types["EIP712Domain"] = eip712Domain
+	// Write to a uniquely named temporary file in the same directory, so concurrent writers do not
+	// share it and the rename stays on one filesystem
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// GetChainIdFromEnv parses the chain ID in an environment variable, given as a bare EVM chain ID
+// such as 1, in CAIP-2 form (eip155:1) or in Orby's form (eip155-1)
+func GetChainIdFromEnv(key string) (caip.ChainID, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return caip.ChainID{}, fmt.Errorf("%s is required", key)
+	}
+	chainId, err := caip.ParseChainID(value)
+	if err != nil {
+		return caip.ChainID{}, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return chainId, nil
 }
 
-// GetExternalChainIdFromInternalChainId converts an internal chain ID to the external format
-// Returns a string in the format "eip155-{chainId}" or empty string if the input is 0
-func GetExternalChainIdFromInternalChainId(chainId int64) string {
-	if chainId == 0 {
-		return ""
+// AddEIP712DomainTypeToTypedData adds the EIP712Domain type for use with go-ethereum's apitypes.TypedData.
+// Typed data that already defines EIP712Domain is left unchanged; otherwise the type is derived from
+// the fields set in the domain, so domains with a version or salt hash correctly.
+func AddEIP712DomainTypeToTypedData(typedData *apitypes.TypedData) {
+	// Check if Types exists, initialize if not
+	if typedData.Types == nil {
+		typedData.Types = make(map[string][]apitypes.Type)
+	}
+
+	if _, ok := typedData.Types["EIP712Domain"]; ok {
+		return
 	}
-	return fmt.Sprintf("eip155-%d", chainId)
+	typedData.Types["EIP712Domain"] = EIP712DomainType(typedData.Domain)
 }
 
 func GetPrivateKey() *ecdsa.PrivateKey {
@@ -60,13 +102,8 @@ func GetPrivateKey() *ecdsa.PrivateKey {
 		log.Fatalf("[ERROR] PRIVATE_KEY environment variable is required")
 	}
 
-	// If private key starts with "0x", remove it
-	if len(privateKeyHex) > 2 && privateKeyHex[:2] == "0x" {
-		privateKeyHex = privateKeyHex[2:]
-	}
-
 	// Parse private key
-	privateKey, err := crypto.HexToECDSA(privateKeyHex)
+	privateKey, err := ParsePrivateKey(privateKeyHex)
 	if err != nil {
 		log.Fatalf("[ERROR] Failed to parse private key: %v", err)
 	}
@@ -74,14 +111,42 @@ func GetPrivateKey() *ecdsa.PrivateKey {
 	return privateKey
 }
 
+// ParsePrivateKey parses a hex secp256k1 private key, with or without the 0x prefix
+func ParsePrivateKey(privateKeyHex string) (*ecdsa.PrivateKey, error) {
+	// If private key starts with "0x", remove it
+	if len(privateKeyHex) > 2 && privateKeyHex[:2] == "0x" {
+		privateKeyHex = privateKeyHex[2:]
+	}
+	return crypto.HexToECDSA(privateKeyHex)
+}
+
+// OperationCalldata returns the calldata of a TRANSACTION operation, whose data is either
+// a JSON transaction object or raw hex calldata
+func OperationCalldata(operation Operation) []byte {
+	var txMap map[string]interface{}
+	if err := json.Unmarshal([]byte(operation.Data), &txMap); err == nil {
+		dataStr, _ := txMap["data"].(string)
+		return common.FromHex(dataStr)
+	}
+	return common.FromHex(operation.Data)
+}
+
 func SignTransaction(operation Operation) (string, error) {
-	// Get private key
-	privateKey := GetPrivateKey()
+	// SVM transactions are signed with the Solana ed25519 key
+	if operation.ChainId.IsSolana() {
+		return SignSolanaTransaction(operation)
+	}
+
+	// Get the private key of the operation's account
+	privateKey, err := OperationPrivateKey(operation)
+	if err != nil {
+		return "", err
+	}
 
 	// Output the incoming data for debugging
 	fmt.Println("Transaction Data to sign:")
 	fmt.Println(operation.Data)
-	fmt.Println("Chain ID:", operation.ChainId)
+	fmt.Println("Chain ID:", ChainDisplayName(operation.ChainId))
 	fmt.Println("To Address:", operation.To)
 
 	// Check if the txData is a hex string or JSON
@@ -96,11 +161,26 @@ func SignTransaction(operation Operation) (string, error) {
 		fmt.Println("Transaction data is in hex format")
 	}
 
+	// Transactions with an authorization list delegate the EOA's code with EIP-7702
+	if isJSON && txMap["authorizationList"] != nil {
+		return SignSetCodeTransaction(operation)
+	}
+
 	// Parse the chain ID
-	chainIDStr := strings.TrimPrefix(operation.ChainId, "eip155-")
-	chainID, ok := new(big.Int).SetString(operation.ChainId, 10)
-	if !ok {
-		return "", fmt.Errorf("invalid chain ID: %s", chainIDStr)
+	chainID, err := operation.ChainId.BigInt()
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateTransactionChain(operation.ChainId); err != nil {
+		return "", err
+	}
+	if isJSON {
+		if txChainId, ok := txMap["chainId"].(string); ok && txChainId != "" {
+			parsed, ok := new(big.Int).SetString(strings.TrimPrefix(txChainId, "0x"), 16)
+			if !ok || parsed.Cmp(chainID) != 0 {
+				return "", fmt.Errorf("transaction chainId %s does not match operation chain %s", txChainId, operation.ChainId.String())
+			}
+		}
 	}
 
 	// Create the transaction
@@ -193,8 +273,8 @@ func SignTransaction(operation Operation) (string, error) {
 		)
 	}
 
-	// Sign the transaction
-	signer := types.NewEIP155Signer(chainID)
+	// Sign the transaction with the signer for its type (EIP-155 for legacy, London for EIP-1559)
+	signer := types.LatestSignerForChainID(chainID)
 	signedTx, err := types.SignTx(tx, signer, privateKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign transaction: %v", err)
@@ -229,8 +309,11 @@ func SignTransaction(operation Operation) (string, error) {
 }
 
 func SignTypedData(operation Operation) (string, error) {
-	// Get private key
-	privateKey := GetPrivateKey()
+	// Get the private key of the operation's account
+	privateKey, err := OperationPrivateKey(operation)
+	if err != nil {
+		return "", err
+	}
 
 	// Log the typed data JSON for inspection
 	fmt.Println("Raw typed data JSON:")
@@ -278,7 +361,25 @@ func SignTypedData(operation Operation) (string, error) {
 
 	fmt.Printf("EIP-712 encoded hash: %s\n", finalHash.Hex())
 
-	// 4. Sign the hash
+	// 4. Operations from a smart account are signed by its owner key in the account's scheme
+	account, err := OperationSmartAccount(operation)
+	if err != nil {
+		return "", err
+	}
+	if account != nil {
+		chainId, err := operation.ChainId.BigInt()
+		if err != nil {
+			return "", err
+		}
+		signature, err := account.Sign(chainId, finalHash, privateKey)
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("Signed for smart account %s (%s scheme)\n", account.Address.Hex(), account.Scheme)
+		return "0x" + hex.EncodeToString(signature), nil
+	}
+
+	// 5. Sign the hash
 	signature, err := crypto.Sign(finalHash.Bytes(), privateKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign typed data hash: %v", err)
@@ -288,12 +389,12 @@ func SignTypedData(operation Operation) (string, error) {
 	originalSignature := make([]byte, len(signature))
 	copy(originalSignature, signature)
 
-	// 5. Adjust v value (add 27) for Ethereum compatibility
+	// 6. Adjust v value (add 27) for Ethereum compatibility
 	if signature[64] < 27 {
 		signature[64] += 27
 	}
 
-	// 6. Verify the signature by recovering the public key
+	// 7. Verify the signature by recovering the public key
 	pubKey, err := crypto.Ecrecover(finalHash.Bytes(), originalSignature)
 	if err != nil {
 		return "", fmt.Errorf("failed to recover public key from signature: %v", err)
@@ -318,3 +419,13 @@ func SignTypedData(operation Operation) (string, error) {
 	// Return the signature in hex format
 	return "0x" + hex.EncodeToString(signature), nil
 }
+
+// OperationTypedDataHash returns the EIP-712 hash of a TYPED_DATA operation
+func OperationTypedDataHash(operation Operation) (common.Hash, error) {
+	var typedData apitypes.TypedData
+	if err := json.Unmarshal([]byte(operation.Data), &typedData); err != nil {
+		return common.Hash{}, fmt.Errorf("failed to parse typed data JSON: %v", err)
+	}
+	AddEIP712DomainTypeToTypedData(&typedData)
+	return TypedDataHash(&typedData)
+}
@@ -0,0 +1,179 @@
+// virtual_node_backend.go exposes a virtual node as a go-ethereum client and contract backend, so
+// abigen bindings can read chain-abstracted state and send transactions as Orby operation sets
+package orby
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"go-app/src/caip"
+)
+
+// VirtualNodeBackend is an ethclient.Client for a virtual node whose SendTransaction requests the
+// transaction from Orby with orby_getOperationsToExecuteTransaction, then signs and sends the
+// operations of the account cluster instead of broadcasting the transaction itself.
+//
+// The transactions of TransactOpts are placeholders that are never broadcast. Each has a distinct
+// hash, which TransactionReceipt and TransactionByHash translate to the hash of the operation Orby
+// sent on the backend's chain, so bind.WaitMined works on the transactions bindings return.
+type VirtualNodeBackend struct {
+	*ethclient.Client
+
+	node             *OrbyClient
+	accountClusterId string
+
+	// Executor signs and sends the operation sets; it defaults to NewOperationExecutor's
+	Executor *OperationExecutor
+
+	mu               sync.Mutex
+	placeholderNonce uint64
+	sent             map[common.Hash]sentTransaction
+}
+
+// sentTransaction is the orby_sendSignedOperations result of a placeholder transaction and the hash
+// of the transaction Orby sent for it
+type sentTransaction struct {
+	result json.RawMessage
+	hash   common.Hash
+}
+
+var _ bind.ContractBackend = (*VirtualNodeBackend)(nil)
+
+// NewVirtualNodeBackend dials the virtual node of a client from the VirtualNodePool
+func NewVirtualNodeBackend(ctx context.Context, node *OrbyClient, accountClusterId string) (*VirtualNodeBackend, error) {
+	client, err := ethclient.DialContext(ctx, node.OrbyURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial virtual node %s: %v", node.OrbyURL, err)
+	}
+	return &VirtualNodeBackend{
+		Client:           client,
+		node:             node,
+		accountClusterId: accountClusterId,
+		Executor:         NewOperationExecutor(node, accountClusterId),
+		sent:             make(map[common.Hash]sentTransaction),
+	}, nil
+}
+
+// Backend returns a contract backend for the virtual node of a chain
+func (p *VirtualNodePool) Backend(ctx context.Context, accountClusterId string, chainId caip.ChainID) (*VirtualNodeBackend, error) {
+	node, err := p.ForChain(accountClusterId, chainId)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := NewVirtualNodeBackend(ctx, node, accountClusterId)
+	if err != nil {
+		return nil, err
+	}
+	backend.Executor.VirtualNodes = p
+	return backend, nil
+}
+
+// TransactOpts returns options for sending transactions from an account of the cluster through the
+// backend. The transaction is not signed locally, since Orby returns the operations to sign, and it is
+// a legacy transaction so no fee market lookups are made; the gas limit is still estimated, which
+// surfaces reverts before Orby is asked for operations. The Signer gives every transaction its own
+// placeholder nonce, so identical calls have distinct hashes.
+func (b *VirtualNodeBackend) TransactOpts(ctx context.Context, from common.Address) *bind.TransactOpts {
+	return &bind.TransactOpts{
+		From:     from,
+		Nonce:    big.NewInt(0),
+		GasPrice: big.NewInt(0),
+		Context:  ctx,
+		Signer: func(address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			b.mu.Lock()
+			b.placeholderNonce++
+			nonce := b.placeholderNonce
+			b.mu.Unlock()
+			return types.NewTx(&types.LegacyTx{
+				Nonce:    nonce,
+				GasPrice: tx.GasPrice(),
+				Gas:      tx.Gas(),
+				To:       tx.To(),
+				Value:    tx.Value(),
+				Data:     tx.Data(),
+			}), nil
+		},
+	}
+}
+
+// SendTransaction turns the transaction into an operation set of the account cluster, then signs and
+// sends its operations. The result of orby_sendSignedOperations and the hash of the transaction sent on
+// the backend's chain are kept under the transaction's hash. If no hash was returned, the operations
+// were still sent, and the error says not to send the transaction again.
+func (b *VirtualNodeBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if tx.To() == nil {
+		return fmt.Errorf("contract deployment is not supported through the virtual node")
+	}
+	chainId, err := b.ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the virtual node's chain ID: %v", err)
+	}
+
+	to := tx.To().Hex()
+	data := "0x" + common.Bytes2Hex(tx.Data())
+	value := NewAmount(tx.Value())
+	fmt.Printf("\n[INFO] Sending transaction to %s through Orby (value: %s, data: %s)\n", to, value.String(), data)
+
+	result, err := b.Executor.Execute(func() (*OperationSet, error) {
+		response, err := b.node.GetOperationsToExecuteTransaction(b.accountClusterId, data, to, value)
+		if err != nil {
+			return nil, err
+		}
+		return ParseOperationSet(response, "orby_getOperationsToExecuteTransaction")
+	})
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return fmt.Errorf("no operations were signed for the transaction to %s", to)
+	}
+
+	sentHash, err := sentTransactionHash(result, caip.EIP155FromBig(chainId))
+	b.mu.Lock()
+	b.sent[tx.Hash()] = sentTransaction{result: result, hash: common.HexToHash(sentHash)}
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n[INFO] Transaction %s was sent as %s\n", tx.Hash().Hex(), sentHash)
+	return nil
+}
+
+// SendResult returns the orby_sendSignedOperations result of a transaction sent through the backend
+func (b *VirtualNodeBackend) SendResult(hash common.Hash) (json.RawMessage, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sent, ok := b.sent[hash]
+	return sent.result, ok
+}
+
+// SentHash returns the hash of the transaction Orby sent for a transaction of the backend, or the
+// hash itself for any other transaction
+func (b *VirtualNodeBackend) SentHash(hash common.Hash) common.Hash {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if sent, ok := b.sent[hash]; ok && sent.hash != (common.Hash{}) {
+		return sent.hash
+	}
+	return hash
+}
+
+// TransactionReceipt returns the receipt of a transaction, looking up the transaction Orby sent for the
+// backend's placeholder transactions
+func (b *VirtualNodeBackend) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	return b.Client.TransactionReceipt(ctx, b.SentHash(hash))
+}
+
+// TransactionByHash returns a transaction, looking up the transaction Orby sent for the backend's
+// placeholder transactions
+func (b *VirtualNodeBackend) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	return b.Client.TransactionByHash(ctx, b.SentHash(hash))
+}
@@ -0,0 +1,187 @@
+// virtual_node_pool.go hands out virtual node clients per account cluster, chain and entrypoint account
+package orby
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go-app/src/caip"
+)
+
+// VirtualNodePool lazily gets the virtual node RPC URL of each (account cluster, chain, entrypoint
+// account) from the private instance, records it in the state store and caches a client for it
+type VirtualNodePool struct {
+	mu      sync.Mutex
+	client  *OrbyClient
+	store   *StateStore
+	profile string
+	clients map[string]*OrbyClient
+	rpcUrls map[string]string
+
+	// Refresh ignores the virtual nodes recorded in the state store, as ORBY_STATE_REFRESH does
+	Refresh bool
+	// DefaultChainId is the chain of the virtual node used for calls that are not tied to a chain
+	DefaultChainId caip.ChainID
+}
+
+// NewVirtualNodePool creates a pool that gets virtual nodes through the private instance client and
+// records them under a profile of the state store
+func NewVirtualNodePool(privateClient *OrbyClient, store *StateStore, profile string) *VirtualNodePool {
+	return &VirtualNodePool{
+		client:  privateClient,
+		store:   store,
+		profile: profile,
+		clients: make(map[string]*OrbyClient),
+		rpcUrls: make(map[string]string),
+	}
+}
+
+// DefaultVirtualNodeChainId returns the chain of the default virtual node: VIRTUAL_NODE_CHAIN_ID, or
+// INPUT_TOKEN_CHAIN_ID, or Ethereum mainnet
+func DefaultVirtualNodeChainId() (caip.ChainID, error) {
+	for _, key := range []string{"VIRTUAL_NODE_CHAIN_ID", "INPUT_TOKEN_CHAIN_ID"} {
+		if GetEnvWithDefault(key, "") != "" {
+			return GetChainIdFromEnv(key)
+		}
+	}
+	return caip.ParseChainID("eip155:1")
+}
+
+// Client returns the client of the virtual node for a cluster, chain and entrypoint account
+func (p *VirtualNodePool) Client(accountClusterId string, chainId caip.ChainID, entrypointAccountAddress string) (*OrbyClient, error) {
+	rpcUrl, err := p.RpcUrl(accountClusterId, chainId, entrypointAccountAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := poolKey(accountClusterId, chainId, entrypointAccountAddress)
+	if client, ok := p.clients[key]; ok {
+		return client, nil
+	}
+	client := NewOrbyClient(rpcUrl, rpcUrl)
+	p.clients[key] = client
+	return client, nil
+}
+
+// ForChain returns the client of the virtual node for a chain, entered through the cluster's account
+// on that chain's VM
+func (p *VirtualNodePool) ForChain(accountClusterId string, chainId caip.ChainID) (*OrbyClient, error) {
+	cluster, err := p.Cluster(accountClusterId)
+	if err != nil {
+		return nil, err
+	}
+
+	entrypoint, err := EntrypointAccount(cluster.Accounts, chainId)
+	if err != nil {
+		return nil, err
+	}
+	return p.Client(accountClusterId, chainId, entrypoint)
+}
+
+// Cluster returns the recorded account cluster with the given ID
+func (p *VirtualNodePool) Cluster(accountClusterId string) (ClusterState, error) {
+	instance, ok := p.store.Instance(p.profile)
+	if !ok {
+		return ClusterState{}, fmt.Errorf("no state recorded for profile %q", p.profile)
+	}
+	cluster, ok := instance.ClusterById(accountClusterId)
+	if !ok {
+		return ClusterState{}, fmt.Errorf("no account cluster %s recorded for profile %q", accountClusterId, p.profile)
+	}
+	return cluster, nil
+}
+
+// Default returns the client of the virtual node on DefaultChainId, for calls such as token IDs and
+// portfolios that are not tied to a chain
+func (p *VirtualNodePool) Default(accountClusterId string) (*OrbyClient, error) {
+	return p.ForChain(accountClusterId, p.DefaultChainId)
+}
+
+// RpcUrl returns the virtual node RPC URL for a cluster, chain and entrypoint account, reusing the
+// recorded one unless Refresh is set. Each URL is only obtained once per pool.
+func (p *VirtualNodePool) RpcUrl(accountClusterId string, chainId caip.ChainID, entrypointAccountAddress string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 1. Reuse the URL obtained earlier, or the recorded virtual node
+	key := poolKey(accountClusterId, chainId, entrypointAccountAddress)
+	if rpcUrl, ok := p.rpcUrls[key]; ok {
+		return rpcUrl, nil
+	}
+	instance, recorded := p.store.Instance(p.profile)
+	if recorded && !p.Refresh {
+		if cluster, ok := instance.ClusterById(accountClusterId); ok {
+			if node, ok := cluster.VirtualNode(chainId, entrypointAccountAddress); ok {
+				p.rpcUrls[key] = node.RpcUrl
+				return node.RpcUrl, nil
+			}
+		}
+	}
+
+	// 2. Get the RPC URL from the private instance
+	fmt.Printf("\nGetting virtual node RPC URL for %s...\n", ChainDisplayName(chainId))
+	result, err := p.client.GetVirtualNodeRpcUrl(accountClusterId, chainId, entrypointAccountAddress)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the virtual node RPC URL for %s: %v", ChainDisplayName(chainId), err)
+	}
+	var response VirtualNodeRpcUrlResponse
+	if err := json.Unmarshal(result, &response); err != nil {
+		return "", fmt.Errorf("failed to parse orby_getVirtualNodeRpcUrl response: %v", err)
+	}
+	if response.VirtualNodeRpcUrl == "" {
+		return "", fmt.Errorf("orby_getVirtualNodeRpcUrl returned no URL for %s", ChainDisplayName(chainId))
+	}
+	fmt.Printf("[INFO] Virtual Node RPC URL for %s: %s\n", ChainDisplayName(chainId), response.VirtualNodeRpcUrl)
+
+	// 3. Record it for later runs
+	p.rpcUrls[key] = response.VirtualNodeRpcUrl
+	if recorded {
+		if _, ok := instance.ClusterById(accountClusterId); ok {
+			err := p.store.SetVirtualNode(p.profile, accountClusterId, VirtualNodeState{
+				ChainId:                  chainId,
+				EntrypointAccountAddress: entrypointAccountAddress,
+				RpcUrl:                   response.VirtualNodeRpcUrl,
+				CreatedAt:                time.Now(),
+			})
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return response.VirtualNodeRpcUrl, nil
+}
+
+func poolKey(accountClusterId string, chainId caip.ChainID, entrypointAccountAddress string) string {
+	return accountClusterId + "/" + virtualNodeKey(chainId, entrypointAccountAddress)
+}
+
+// EntrypointAccount returns the account a virtual node on a chain is entered through: the cluster's
+// first EOA on the chain's VM, or else its first smart account on it
+func EntrypointAccount(accounts []AccountParams, chainId caip.ChainID) (string, error) {
+	vmType := VMTypeEVM
+	if chainId.IsSolana() {
+		vmType = VMTypeSVM
+	}
+
+	var smartAccount string
+	for _, account := range accounts {
+		if !strings.EqualFold(account.VMType, vmType) {
+			continue
+		}
+		if account.AccountType == AccountTypeEOA {
+			return account.Address, nil
+		}
+		if smartAccount == "" {
+			smartAccount = account.Address
+		}
+	}
+	if smartAccount != "" {
+		return smartAccount, nil
+	}
+	return "", fmt.Errorf("the account cluster has no %s account to enter %s through", vmType, ChainDisplayName(chainId))
+}